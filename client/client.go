@@ -0,0 +1,203 @@
+// Package client calls a pubsub serve daemon. A *Client has the same methods as *pubsub.Service, and both implement
+// pubsub.API, so code can use a daemon shared between processes or open the database itself without changing.
+//
+//	var api pubsub.API
+//	api, err := client.New("localhost:8087", nil) // pubsub serve --rpc
+//	// or: api, err := client.New("unix:pubsub.sock", nil) // pubsub serve --socket
+//	// or: api, err := pubsub.NewService(pubsub.DefaultFilename)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nigel-campbell/pubsub/internal/rpc"
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// Defaults used for zero Options fields.
+const (
+	DefaultTimeout     = 30 * time.Second
+	DefaultMaxAttempts = 3
+	DefaultBackoff     = 100 * time.Millisecond
+)
+
+// Options tunes a Client. Zero values select the defaults.
+type Options struct {
+	// Timeout bounds calls whose context has no deadline.
+	Timeout time.Duration
+	// MaxAttempts is how many times an idempotent call is attempted when the daemon cannot be reached or is
+	// unavailable. Calls that are not idempotent, such as Publish or Pull, are attempted once.
+	MaxAttempts int
+	// Backoff is the wait before the second attempt, doubled for every following one.
+	Backoff time.Duration
+	// HTTPClient makes the calls. By default, a client of its own keeps connections to the daemon open for reuse.
+	HTTPClient *http.Client
+	// Project scopes calls whose context has no project set with pubsub.WithProject. By default, they are scoped to
+	// pubsub.DefaultProject.
+	Project string
+}
+
+// Client calls the methods of pubsub.API on a daemon. It is safe for concurrent use.
+type Client struct {
+	base string
+	http *http.Client
+	opts Options
+}
+
+var _ pubsub.API = (*Client)(nil)
+
+// New returns a client for the daemon serving the RPC API on addr, given as host:port, as an http URL, or as
+// unix:PATH for a Unix socket. Nothing is sent until the first call. A nil opts selects the defaults.
+func New(addr string, opts *Options) (*Client, error) {
+	c := &Client{}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.Timeout <= 0 {
+		c.opts.Timeout = DefaultTimeout
+	}
+	if c.opts.MaxAttempts <= 0 {
+		c.opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.opts.Backoff <= 0 {
+		c.opts.Backoff = DefaultBackoff
+	}
+
+	var socket string
+	switch {
+	case addr == "":
+		return nil, errors.New("an address is required")
+	case strings.HasPrefix(addr, "unix:"):
+		socket = strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+		if socket == "" {
+			return nil, fmt.Errorf("invalid address %q, expected unix:PATH", addr)
+		}
+		// The host is ignored, as every request goes to the socket.
+		c.base = "http://pubsub"
+	case strings.HasPrefix(addr, "http://"), strings.HasPrefix(addr, "https://"):
+		c.base = strings.TrimSuffix(addr, "/")
+	default:
+		c.base = "http://" + addr
+	}
+
+	c.http = c.opts.HTTPClient
+	if c.http != nil && socket != "" {
+		return nil, errors.New("an HTTPClient cannot be used with a Unix socket")
+	}
+	if c.http == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// Concurrent callers each keep a connection, instead of the default two.
+		transport.MaxIdleConnsPerHost = 32
+		if socket != "" {
+			transport.Proxy = nil
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			}
+		}
+		c.http = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+// errUnavailable marks failures worth retrying: the daemon could not be reached, or answered that it is unavailable.
+var errUnavailable = errors.New("daemon unavailable")
+
+// call calls a method of the daemon with args, decoding its results into results, which must be pointers. Idempotent
+// calls are retried while the daemon is unavailable.
+func (c *Client) call(ctx context.Context, method string, idempotent bool, results []any, args ...any) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	if args == nil {
+		args = []any{}
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("failed to encode arguments of %s: %w", method, err)
+	}
+
+	backoff := c.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err = c.do(ctx, method, body, results)
+		if !errors.Is(err, errUnavailable) || !idempotent || attempt == c.opts.MaxAttempts {
+			return err
+		}
+		// Do not wait past the deadline, as the call could not be attempted again.
+		if deadline, _ := ctx.Deadline(); time.Until(deadline) < backoff {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// do makes a single attempt at a call.
+func (c *Client) do(ctx context.Context, method string, body []byte, results []any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+rpc.PathPrefix+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if project, ok := pubsub.ProjectFromContext(ctx); ok {
+		req.Header.Set(rpc.ProjectHeader, project)
+	} else if c.opts.Project != "" {
+		req.Header.Set(rpc.ProjectHeader, c.opts.Project)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%s: %w", method, ctxErr)
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w: %v", method, errUnavailable, err)
+		}
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var res rpc.Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		// Drain the body, so that the connection can be reused.
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable {
+			return fmt.Errorf("%s: %w: %s", method, errUnavailable, resp.Status)
+		}
+		return fmt.Errorf("%s: invalid response (%s): %w", method, resp.Status, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	if res.Error != nil {
+		return res.Error.Err()
+	}
+	if len(res.Results) != len(results) {
+		return fmt.Errorf("%s: expected %d results, got %d", method, len(results), len(res.Results))
+	}
+	for i, result := range res.Results {
+		if err := json.Unmarshal(result, results[i]); err != nil {
+			return fmt.Errorf("%s: invalid result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// Close releases the idle connections to the daemon. It does not stop the daemon.
+func (c *Client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
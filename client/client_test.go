@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"github.com/nigel-campbell/pubsub/server"
+)
+
+// newTestDaemon serves a fresh service the way pubsub serve --rpc does, wrapping the handler with wrap when given.
+func newTestDaemon(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	svc, err := pubsub.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	t.Cleanup(func() { svc.Close() })
+	if err := svc.Init(context.Background()); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+	handler := server.NewRPC(svc)
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestDaemon(t, nil)
+	var api pubsub.API
+	api, err := New(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer api.Close()
+
+	if err := api.CreateTopic(ctx, "orders", &pubsub.TopicConfig{Labels: map[string]string{"team": "payments"}}); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	topic, err := api.GetTopic(ctx, "projects/default/topics/orders")
+	if err != nil {
+		t.Fatalf("failed to get topic: %v", err)
+	}
+	if topic.Name != "orders" || topic.Config.Labels["team"] != "payments" || topic.ID == 0 {
+		t.Fatalf("unexpected topic %+v", topic)
+	}
+	config := &pubsub.SubscriptionConfig{AckDeadline: 30 * time.Second, Filter: `attributes.region = "eu"`}
+	if err := api.CreateSubscription(ctx, "orders", "billing", config); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+
+	id, err := api.Publish(ctx, "orders", "hello", map[string]string{"region": "eu"})
+	if err != nil || id == 0 {
+		t.Fatalf("failed to publish message: %d, %v", id, err)
+	}
+	messages, err := api.Pull(ctx, "billing", 10, time.Time{})
+	if err != nil {
+		t.Fatalf("failed to pull messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Content != "hello" || messages[0].PublishID != id {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+	if err := api.AcknowledgeMessage(ctx, "billing", messages[0].ID); err != nil {
+		t.Fatalf("failed to acknowledge message: %v", err)
+	}
+	result, err := api.Seek(ctx, "billing", time.Now().Add(-time.Hour))
+	if err != nil || result.Redelivered != 1 {
+		t.Fatalf("expected seek to redeliver the message, got %+v, %v", result, err)
+	}
+
+	// Errors keep their types and sentinels across the call.
+	_, err = api.GetSubscription(ctx, "missing")
+	var notFound *pubsub.NotFoundError
+	if !errors.Is(err, pubsub.ErrNotFound) || !errors.As(err, &notFound) || notFound.Resource != "subscription" {
+		t.Fatalf("expected a *pubsub.NotFoundError, got %v", err)
+	}
+	if err := api.CreateTopic(ctx, "orders", nil); !errors.Is(err, pubsub.ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists, got %v", err)
+	}
+	err = api.CreateSubscription(ctx, "orders", "invalid", &pubsub.SubscriptionConfig{AckDeadline: time.Hour, Labels: map[string]string{"Team": "x"}})
+	var configErr *pubsub.ConfigError
+	if !errors.Is(err, pubsub.ErrInvalidConfig) || !errors.As(err, &configErr) {
+		t.Fatalf("expected a *pubsub.ConfigError, got %v", err)
+	}
+	if err := api.SetTopicLimits(ctx, "orders", pubsub.Limits{MaxMessageBytes: 3}); err != nil {
+		t.Fatalf("failed to set limits: %v", err)
+	}
+	var limitErr *pubsub.LimitError
+	if _, err := api.Publish(ctx, "orders", "too long", nil); !errors.As(err, &limitErr) || limitErr.Max != 3 {
+		t.Fatalf("expected a *pubsub.LimitError, got %v", err)
+	}
+	if err := api.CreateSchema(ctx, &pubsub.Schema{Name: "orders", Type: pubsub.SchemaTypeJSON, Definition: `{"type": "object"}`}); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	if err := api.SetTopicSchema(ctx, "orders", &pubsub.SchemaSettings{Schema: "orders"}); err != nil {
+		t.Fatalf("failed to bind schema: %v", err)
+	}
+	if err := api.DeleteSchema(ctx, "orders"); !errors.Is(err, pubsub.ErrSchemaInUse) {
+		t.Fatalf("expected ErrSchemaInUse, got %v", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	ctx := context.Background()
+	var failures atomic.Int32
+	failures.Store(2)
+	var requests atomic.Int32
+	srv := newTestDaemon(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			if failures.Add(-1) >= 0 {
+				http.Error(w, "restarting", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c, err := New(srv.URL, &Options{Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.ListTopics(ctx); err != nil {
+		t.Fatalf("expected idempotent calls to be retried, got %v", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	failures.Store(1)
+	requests.Store(0)
+	if err := c.CreateTopic(ctx, "orders", nil); err == nil {
+		t.Fatal("expected calls that are not idempotent to fail without retrying")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected a single attempt, got %d", n)
+	}
+
+	// Calls give up at the context's deadline.
+	release := make(chan struct{})
+	slow := newTestDaemon(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			next.ServeHTTP(w, r)
+		})
+	})
+	t.Cleanup(func() { close(release) })
+	c, err = New(slow.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.ListTopics(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the call to time out, got %v", err)
+	}
+}
+
+func TestClientProject(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestDaemon(t, nil)
+	c, err := New(srv.URL, &Options{Project: "demo"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.CreateTopic(ctx, "orders", nil); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	topic, err := c.GetTopic(ctx, "projects/demo/topics/orders")
+	if err != nil || topic.Project != "demo" {
+		t.Fatalf("expected the topic to be created in the client's project, got %+v, %v", topic, err)
+	}
+	// The project of the context takes precedence over the client's.
+	if err := c.CreateTopic(pubsub.WithProject(ctx, "other"), "orders", nil); err != nil {
+		t.Fatalf("expected the name to be free in another project, got %v", err)
+	}
+	topics, err := c.ListTopics(pubsub.WithProject(ctx, pubsub.DefaultProject))
+	if err != nil || len(topics) != 0 {
+		t.Fatalf("expected no topics in the default project, got %+v, %v", topics, err)
+	}
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	ctx := context.Background()
+	svc, err := pubsub.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	defer svc.Close()
+	if err := svc.Init(ctx); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+	socket := filepath.Join(t.TempDir(), "pubsub.sock")
+	lis, err := server.ListenUnix(socket, 0600)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := &http.Server{Handler: server.NewRPC(svc)}
+	go srv.Serve(lis)
+	defer srv.Close()
+
+	c, err := New("unix:"+socket, nil)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+	if err := c.CreateTopic(ctx, "orders", nil); err != nil {
+		t.Fatalf("failed to create topic over the socket: %v", err)
+	}
+	if _, err := svc.GetTopic(ctx, "orders"); err != nil {
+		t.Fatalf("expected the topic to be created by the daemon, got %v", err)
+	}
+
+	if _, err := New("unix:"+socket, &Options{HTTPClient: http.DefaultClient}); err == nil {
+		t.Fatal("expected an HTTP client to be refused along with a socket")
+	}
+}
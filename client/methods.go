@@ -0,0 +1,247 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// Whether a call may be attempted again, see Options.MaxAttempts. Creating, deleting, publishing and pulling are not
+// retried, as an attempt whose response was lost may have taken effect.
+const (
+	idempotent = true
+	once       = false
+)
+
+func (c *Client) Init(ctx context.Context) error {
+	return c.call(ctx, "Init", idempotent, nil)
+}
+
+func (c *Client) CreateTopic(ctx context.Context, name string, config *pubsub.TopicConfig) error {
+	return c.call(ctx, "CreateTopic", once, nil, name, config)
+}
+
+func (c *Client) GetTopic(ctx context.Context, name string) (*pubsub.Topic, error) {
+	var result *pubsub.Topic
+	err := c.call(ctx, "GetTopic", idempotent, []any{&result}, name)
+	return result, err
+}
+
+func (c *Client) ListTopics(ctx context.Context) ([]*pubsub.Topic, error) {
+	var result []*pubsub.Topic
+	err := c.call(ctx, "ListTopics", idempotent, []any{&result})
+	return result, err
+}
+
+func (c *Client) UpdateTopic(ctx context.Context, name string, config *pubsub.TopicConfig, mask []string) (*pubsub.Topic, error) {
+	var result *pubsub.Topic
+	err := c.call(ctx, "UpdateTopic", idempotent, []any{&result}, name, config, mask)
+	return result, err
+}
+
+func (c *Client) DeleteTopic(ctx context.Context, name string) error {
+	return c.call(ctx, "DeleteTopic", once, nil, name)
+}
+
+func (c *Client) SetTopicLimits(ctx context.Context, topicName string, limits pubsub.Limits) error {
+	return c.call(ctx, "SetTopicLimits", idempotent, nil, topicName, limits)
+}
+
+func (c *Client) SetTopicCompression(ctx context.Context, topicName string, compression pubsub.Compression) error {
+	return c.call(ctx, "SetTopicCompression", idempotent, nil, topicName, compression)
+}
+
+func (c *Client) SetTopicSchema(ctx context.Context, topicName string, settings *pubsub.SchemaSettings) error {
+	return c.call(ctx, "SetTopicSchema", idempotent, nil, topicName, settings)
+}
+
+func (c *Client) Stats(ctx context.Context, topicName string) (*pubsub.TopicStats, error) {
+	var result *pubsub.TopicStats
+	err := c.call(ctx, "Stats", idempotent, []any{&result}, topicName)
+	return result, err
+}
+
+func (c *Client) CreateSubscription(ctx context.Context, topicName, name string, config *pubsub.SubscriptionConfig) error {
+	return c.call(ctx, "CreateSubscription", once, nil, topicName, name, config)
+}
+
+func (c *Client) CreateSubscriptionFromSnapshot(ctx context.Context, name, snapshotName string, config *pubsub.SubscriptionConfig) error {
+	return c.call(ctx, "CreateSubscriptionFromSnapshot", once, nil, name, snapshotName, config)
+}
+
+func (c *Client) GetSubscription(ctx context.Context, name string) (*pubsub.Subscription, error) {
+	var result *pubsub.Subscription
+	err := c.call(ctx, "GetSubscription", idempotent, []any{&result}, name)
+	return result, err
+}
+
+func (c *Client) ListSubscriptions(ctx context.Context, topicName string) ([]*pubsub.Subscription, error) {
+	var result []*pubsub.Subscription
+	err := c.call(ctx, "ListSubscriptions", idempotent, []any{&result}, topicName)
+	return result, err
+}
+
+func (c *Client) ListAllSubscriptions(ctx context.Context) ([]*pubsub.Subscription, error) {
+	var result []*pubsub.Subscription
+	err := c.call(ctx, "ListAllSubscriptions", idempotent, []any{&result})
+	return result, err
+}
+
+func (c *Client) UpdateSubscription(ctx context.Context, name string, config *pubsub.SubscriptionConfig, mask []string) (*pubsub.Subscription, error) {
+	var result *pubsub.Subscription
+	err := c.call(ctx, "UpdateSubscription", idempotent, []any{&result}, name, config, mask)
+	return result, err
+}
+
+func (c *Client) DeleteSubscription(ctx context.Context, name string) error {
+	return c.call(ctx, "DeleteSubscription", once, nil, name)
+}
+
+func (c *Client) DetachSubscription(ctx context.Context, name string) error {
+	return c.call(ctx, "DetachSubscription", once, nil, name)
+}
+
+func (c *Client) Publish(ctx context.Context, topicName string, content string, attributes map[string]string) (int64, error) {
+	var result int64
+	err := c.call(ctx, "Publish", once, []any{&result}, topicName, content, attributes)
+	return result, err
+}
+
+func (c *Client) PublishMessage(ctx context.Context, topicName string, content string, attributes map[string]string) error {
+	return c.call(ctx, "PublishMessage", once, nil, topicName, content, attributes)
+}
+
+func (c *Client) GetMessages(ctx context.Context, subscriptionName string) ([]*pubsub.Message, error) {
+	var result []*pubsub.Message
+	err := c.call(ctx, "GetMessages", idempotent, []any{&result}, subscriptionName)
+	return result, err
+}
+
+func (c *Client) Pull(ctx context.Context, subscriptionName string, max int, ackDeadline time.Time) ([]*pubsub.Message, error) {
+	var result []*pubsub.Message
+	err := c.call(ctx, "Pull", once, []any{&result}, subscriptionName, max, ackDeadline)
+	return result, err
+}
+
+func (c *Client) PullMessages(ctx context.Context, subscriptionName string, ackDeadline time.Time) ([]*pubsub.Message, error) {
+	var result []*pubsub.Message
+	err := c.call(ctx, "PullMessages", once, []any{&result}, subscriptionName, ackDeadline)
+	return result, err
+}
+
+func (c *Client) AcknowledgeMessage(ctx context.Context, subscriptionName string, messageID int) error {
+	return c.call(ctx, "AcknowledgeMessage", idempotent, nil, subscriptionName, messageID)
+}
+
+func (c *Client) ModifyAckDeadline(ctx context.Context, subscriptionName string, messageID int, ackDeadline time.Time) error {
+	return c.call(ctx, "ModifyAckDeadline", idempotent, nil, subscriptionName, messageID, ackDeadline)
+}
+
+func (c *Client) DecodeMessage(ctx context.Context, message *pubsub.Message) (string, error) {
+	var result string
+	err := c.call(ctx, "DecodeMessage", idempotent, []any{&result}, message)
+	return result, err
+}
+
+func (c *Client) Seek(ctx context.Context, subscriptionName string, t time.Time) (*pubsub.SeekResult, error) {
+	var result *pubsub.SeekResult
+	err := c.call(ctx, "Seek", idempotent, []any{&result}, subscriptionName, t)
+	return result, err
+}
+
+func (c *Client) SeekToSnapshot(ctx context.Context, subscriptionName, snapshotName string) (*pubsub.SeekResult, error) {
+	var result *pubsub.SeekResult
+	err := c.call(ctx, "SeekToSnapshot", idempotent, []any{&result}, subscriptionName, snapshotName)
+	return result, err
+}
+
+func (c *Client) Backfill(ctx context.Context, subscriptionName string, since time.Time) (int, error) {
+	var result int
+	err := c.call(ctx, "Backfill", once, []any{&result}, subscriptionName, since)
+	return result, err
+}
+
+func (c *Client) Purge(ctx context.Context, subscriptionName string, opts pubsub.PurgeOptions) (int, error) {
+	var result int
+	err := c.call(ctx, "Purge", once, []any{&result}, subscriptionName, opts)
+	return result, err
+}
+
+func (c *Client) PurgeTopic(ctx context.Context, topicName string, opts pubsub.PurgeOptions) (int, error) {
+	var result int
+	err := c.call(ctx, "PurgeTopic", once, []any{&result}, topicName, opts)
+	return result, err
+}
+
+func (c *Client) CreateSnapshot(ctx context.Context, name, subscriptionName string, lifetime time.Duration) (*pubsub.Snapshot, error) {
+	var result *pubsub.Snapshot
+	err := c.call(ctx, "CreateSnapshot", once, []any{&result}, name, subscriptionName, lifetime)
+	return result, err
+}
+
+func (c *Client) GetSnapshot(ctx context.Context, name string) (*pubsub.Snapshot, error) {
+	var result *pubsub.Snapshot
+	err := c.call(ctx, "GetSnapshot", idempotent, []any{&result}, name)
+	return result, err
+}
+
+func (c *Client) ListSnapshots(ctx context.Context) ([]*pubsub.Snapshot, error) {
+	var result []*pubsub.Snapshot
+	err := c.call(ctx, "ListSnapshots", idempotent, []any{&result})
+	return result, err
+}
+
+func (c *Client) DeleteSnapshot(ctx context.Context, name string) error {
+	return c.call(ctx, "DeleteSnapshot", once, nil, name)
+}
+
+func (c *Client) CreateSchema(ctx context.Context, schema *pubsub.Schema) error {
+	return c.call(ctx, "CreateSchema", once, nil, schema)
+}
+
+func (c *Client) CommitSchema(ctx context.Context, name, definition, messageType string) (*pubsub.Schema, error) {
+	var result *pubsub.Schema
+	err := c.call(ctx, "CommitSchema", once, []any{&result}, name, definition, messageType)
+	return result, err
+}
+
+func (c *Client) GetSchema(ctx context.Context, name string) (*pubsub.Schema, error) {
+	var result *pubsub.Schema
+	err := c.call(ctx, "GetSchema", idempotent, []any{&result}, name)
+	return result, err
+}
+
+func (c *Client) GetSchemaRevision(ctx context.Context, name string, revision int) (*pubsub.Schema, error) {
+	var result *pubsub.Schema
+	err := c.call(ctx, "GetSchemaRevision", idempotent, []any{&result}, name, revision)
+	return result, err
+}
+
+func (c *Client) ListSchemaRevisions(ctx context.Context, name string) ([]*pubsub.Schema, error) {
+	var result []*pubsub.Schema
+	err := c.call(ctx, "ListSchemaRevisions", idempotent, []any{&result}, name)
+	return result, err
+}
+
+func (c *Client) ListSchemas(ctx context.Context) ([]*pubsub.Schema, error) {
+	var result []*pubsub.Schema
+	err := c.call(ctx, "ListSchemas", idempotent, []any{&result})
+	return result, err
+}
+
+func (c *Client) DeleteSchema(ctx context.Context, name string) error {
+	return c.call(ctx, "DeleteSchema", once, nil, name)
+}
+
+func (c *Client) GC(ctx context.Context, now time.Time) (int, error) {
+	var result int
+	err := c.call(ctx, "GC", idempotent, []any{&result}, now)
+	return result, err
+}
+
+func (c *Client) ExpireLeases(ctx context.Context, now time.Time) (int, error) {
+	var result int
+	err := c.call(ctx, "ExpireLeases", idempotent, []any{&result}, now)
+	return result, err
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"github.com/nigel-campbell/pubsub/pubsub"
 	"github.com/spf13/cobra"
 	"log"
 	"strconv"
@@ -12,24 +11,21 @@ import (
 
 // ackCmd represents the "ack" command
 var ackCmd = &cobra.Command{
-	Use:   "ack [SUBSCRIPTION_ID] [MESSAGE_ID]",
+	Use:   "ack [SUBSCRIPTION_NAME] [MESSAGE_ID]",
 	Short: "Acknowledge a message in a subscription",
 	Long:  "Marks a message as acknowledged in a specific subscription.",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(commandContext(), 5*time.Second)
 		defer cancel()
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Error creating Pub/Sub service: %v", err)
 		}
 		defer svc.Close()
 
-		subscriptionID, err := strconv.Atoi(args[0])
-		if err != nil {
-			log.Fatalf("Invalid subscription ID: %s", args[0])
-		}
+		subscriptionID := args[0]
 
 		messageID, err := strconv.Atoi(args[1])
 		if err != nil {
@@ -38,31 +34,28 @@ var ackCmd = &cobra.Command{
 
 		err = svc.AcknowledgeMessage(ctx, subscriptionID, messageID)
 		if err != nil {
-			log.Fatalf("Failed to acknowledge message %d in subscription %d: %v", messageID, subscriptionID, err)
+			log.Fatalf("Failed to acknowledge message %d in subscription %s: %v", messageID, subscriptionID, err)
 		}
-		fmt.Printf("Acknowledged message %d in subscription %d\n", messageID, subscriptionID)
+		fmt.Printf("Acknowledged message %d in subscription %s\n", messageID, subscriptionID)
 	},
 }
 
 var modAckCmd = &cobra.Command{
-	Use:   "modack [SUBSCRIPTION_ID] [MESSAGE_ID] [DEADLINE]",
+	Use:   "modack [SUBSCRIPTION_NAME] [MESSAGE_ID] [DEADLINE]",
 	Short: "Modify the ack deadline for a message",
 	Long:  "Modifies the ack deadline for a message in a specific subscription",
 	Args:  cobra.ExactArgs(3),
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(commandContext(), 5*time.Second)
 		defer cancel()
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Error creating Pub/Sub service: %v", err)
 		}
 		defer svc.Close()
 
-		subscriptionId, err := strconv.Atoi(args[0])
-		if err != nil {
-			log.Fatalf("Invalid subscription ID: %s", args[0])
-		}
+		subscriptionId := args[0]
 
 		messageID, err := strconv.Atoi(args[1])
 		if err != nil {
@@ -85,24 +78,21 @@ var modAckCmd = &cobra.Command{
 }
 
 var nackCmd = &cobra.Command{
-	Use:   "nack [SUBSCRIPTION_ID] [MESSAGE_ID]",
+	Use:   "nack [SUBSCRIPTION_NAME] [MESSAGE_ID]",
 	Short: "Modify the ack deadline for a message",
 	Long:  "Modifies the ack deadline for a message in a specific subscription",
 	Args:  cobra.ExactArgs(3),
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(commandContext(), 5*time.Second)
 		defer cancel()
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Error creating Pub/Sub service: %v", err)
 		}
 		defer svc.Close()
 
-		subscriptionId, err := strconv.Atoi(args[0])
-		if err != nil {
-			log.Fatalf("Invalid subscription ID: %s", args[0])
-		}
+		subscriptionId := args[0]
 
 		messageID, err := strconv.Atoi(args[1])
 		if err != nil {
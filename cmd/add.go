@@ -1,18 +1,42 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nigel-campbell/pubsub/pubsub"
 	"github.com/spf13/cobra"
 	"log"
-	"strconv"
+	"os"
+	"time"
 )
 
 // Define variables to store flags (like -d for config or payload)
 var configFile string
 var messagePayload string
+var messageAttributes map[string]string
+var topicLimits pubsub.Limits
+var topicSchema string
+var topicSchemaEncoding string
+var topicSchemaFirstRevision int
+var topicSchemaLastRevision int
+var topicCompression string
+var messageFile string
+var subscriptionSnapshot string
+var subscriptionBackfill bool
+var subscriptionBackfillFrom string
+
+// readConfigFile returns the contents of the file given with -d/--config, or nil when there is none.
+func readConfigFile() []byte {
+	if configFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		log.Fatalf("Error reading configuration: %v", err)
+	}
+	return b
+}
 
 var addCmd = &cobra.Command{
 	Use:   "add",
@@ -21,77 +45,145 @@ var addCmd = &cobra.Command{
 }
 
 var addTopicCmd = &cobra.Command{
-	Use:   "topic [TOPIC_ID]",
+	Use:   "topic [TOPIC_NAME]",
 	Short: "Add a new topic",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		topicID := args[0]
-		fmt.Printf("Adding topic: %s\n", topicID)
+		topicName := args[0]
+		fmt.Printf("Adding topic: %s\n", topicName)
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
-			fmt.Println("Error creating Pub/Sub service:", err)
-			return
+			log.Fatalf("Error creating Pub/Sub service: %s", err)
 		}
 		defer svc.Close()
 
-		err = svc.CreateTopic(context.Background(), topicID, []byte{})
+		compression, err := pubsub.ParseCompression(topicCompression)
+		if err != nil {
+			log.Fatalf("Invalid compression: %s", err)
+		}
+		encoding, err := pubsub.ParseEncoding(topicSchemaEncoding)
+		if err != nil {
+			log.Fatalf("Invalid schema encoding: %s", err)
+		}
+
+		config, err := pubsub.ParseTopicConfig(readConfigFile())
 		if err != nil {
-			fmt.Println("Error creating topic:", err)
-			return
+			log.Fatalf("Invalid topic configuration in %s:\n%v", configFile, err)
+		}
+		if topicLimits != (pubsub.Limits{}) {
+			config.Limits = &topicLimits
+		}
+		config.Compression = compression
+		if topicSchema != "" {
+			config.SchemaSettings = &pubsub.SchemaSettings{
+				Schema:        topicSchema,
+				Encoding:      encoding,
+				FirstRevision: topicSchemaFirstRevision,
+				LastRevision:  topicSchemaLastRevision,
+			}
 		}
 
+		// The topic is only created if its limits, compression and schema can be applied too.
+		if err := svc.CreateTopic(commandContext(), topicName, config); err != nil {
+			log.Fatalf("Error creating topic: %s", err)
+		}
 		fmt.Println("Topic created successfully")
 	},
 }
 
 var addSubscriptionCmd = &cobra.Command{
-	Use:   "subscription [TOPIC_ID] [SUBSCRIPTION_ID]",
+	Use:   "subscription [TOPIC_NAME] [SUBSCRIPTION_NAME]",
 	Short: "Add a new subscription to a topic",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		topicId, err := strconv.Atoi(args[0])
-		if err != nil {
-			log.Fatalf("Invalid topic ID: %s", args[0])
-		}
+		topicId := args[0]
 		subscriptionName := args[1]
-		// Implement the logic for adding a subscription using the topicID, subscriptionName, and configFile
-		fmt.Printf("Adding subscription: %s to topic: %d\n", subscriptionName, topicId)
+		fmt.Printf("Adding subscription: %s to topic: %s\n", subscriptionName, topicId)
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Error creating Pub/Sub service: %s", err)
 		}
 		defer svc.Close()
 
-		err = svc.CreateSubscription(context.Background(), topicId, subscriptionName, []byte{})
+		config, err := pubsub.ParseSubscriptionConfig(readConfigFile())
+		if err != nil {
+			log.Fatalf("Invalid subscription configuration in %s:\n%v", configFile, err)
+		}
+
+		var since time.Time
+		if subscriptionBackfillFrom != "" {
+			if since, err = time.Parse(time.RFC3339, subscriptionBackfillFrom); err != nil {
+				log.Fatalf("Invalid --backfill-from time: %s", err)
+			}
+		}
+
+		if subscriptionSnapshot != "" {
+			snapshot, err := svc.GetSnapshot(commandContext(), subscriptionSnapshot)
+			if err != nil {
+				log.Fatalf("Error retrieving snapshot: %s", err)
+			}
+			topic, err := svc.GetTopic(commandContext(), topicId)
+			if err != nil {
+				log.Fatalf("Error retrieving topic: %s", err)
+			}
+			if topic.ID != snapshot.TopicID {
+				log.Fatalf("Snapshot %s was taken on topic %s, not %s", snapshot.Name, snapshot.Topic, topic.Name)
+			}
+			err = svc.CreateSubscriptionFromSnapshot(commandContext(), subscriptionName, subscriptionSnapshot, config)
+		} else {
+			err = svc.CreateSubscription(commandContext(), topicId, subscriptionName, config)
+		}
 		if err != nil {
 			log.Fatalf("Error creating subscription: %s", err)
 		}
 		fmt.Println("Subscription created successfully")
+
+		if subscriptionBackfill || subscriptionBackfillFrom != "" {
+			n, err := svc.Backfill(commandContext(), subscriptionName, since)
+			if err != nil {
+				log.Fatalf("Error backfilling subscription: %s", err)
+			}
+			fmt.Printf("Backfilled %d messages\n", n)
+		}
 	},
 }
 
 // addMessageCmd represents the "add message" command
 var addMessageCmd = &cobra.Command{
-	Use:   "message [TOPIC_ID]",
+	Use:   "message [TOPIC_NAME]",
 	Short: "Add a message to a topic",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// Implement the logic for adding a message using the topicID and messagePayload
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Error creating Pub/Sub service: %s", err)
 		}
 		defer svc.Close()
 
-		topicID, err := strconv.Atoi(args[0])
-		if err != nil {
-			log.Fatalf("Error converting topic ID to integer: %v", err)
-		}
+		topicID := args[0]
 
-		fmt.Printf("Adding message to topic: %d with payload: %s\n", topicID, messagePayload)
-		err = svc.PublishMessage(context.Background(), topicID, messagePayload, []byte{})
+		if messageFile != "" {
+			b, err := os.ReadFile(messageFile)
+			if err != nil {
+				log.Fatalf("Error reading message payload: %v", err)
+			}
+			messagePayload = string(b)
+			fmt.Printf("Adding message to topic: %s with payload from: %s\n", topicID, messageFile)
+		} else {
+			fmt.Printf("Adding message to topic: %s with payload: %s\n", topicID, messagePayload)
+		}
+		err = svc.PublishMessage(commandContext(), topicID, messagePayload, messageAttributes)
+		var limitErr *pubsub.LimitError
+		if errors.As(err, &limitErr) {
+			log.Fatalf("Message rejected by topic %s: %s", topicID, limitErr)
+		}
+		var validationErr *pubsub.ValidationError
+		if errors.As(err, &validationErr) {
+			log.Fatalf("Message rejected by topic %s: %s", topicID, validationErr)
+		}
 		if err != nil {
 			log.Fatalf("Error adding message: %s", err)
 		}
@@ -103,11 +195,25 @@ func init() {
 	rootCmd.AddCommand(addCmd)
 
 	addCmd.AddCommand(addTopicCmd)
-	addTopicCmd.Flags().StringVarP(&configFile, "config", "d", "", "Path to topic configuration file")
+	addTopicCmd.Flags().StringVarP(&configFile, "config", "d", "", "Path to a YAML or JSON topic configuration file")
+	addTopicCmd.Flags().StringVar(&topicSchema, "schema", "", "Name of the schema that published messages must match")
+	addTopicCmd.Flags().StringVar(&topicSchemaEncoding, "schema-encoding", "json", "Encoding of messages validated against the schema (json or binary)")
+	addTopicCmd.Flags().IntVar(&topicSchemaFirstRevision, "schema-first-revision", 0, "Oldest schema revision messages may use (default the first)")
+	addTopicCmd.Flags().IntVar(&topicSchemaLastRevision, "schema-last-revision", 0, "Newest schema revision messages may use (default the latest)")
+	addTopicCmd.Flags().StringVar(&topicCompression, "compression", "none", "Compression for stored message payloads (none, gzip or flate)")
+	addTopicCmd.Flags().IntVar(&topicLimits.MaxMessageBytes, "max-message-bytes", 0, "Maximum message payload size in bytes (default 10000000)")
+	addTopicCmd.Flags().IntVar(&topicLimits.MaxAttributes, "max-attributes", 0, "Maximum number of attributes per message (default 100)")
+	addTopicCmd.Flags().IntVar(&topicLimits.MaxAttributeKeyBytes, "max-attribute-key-bytes", 0, "Maximum attribute key size in bytes (default 256)")
+	addTopicCmd.Flags().IntVar(&topicLimits.MaxAttributeValueBytes, "max-attribute-value-bytes", 0, "Maximum attribute value size in bytes (default 1024)")
 
 	addCmd.AddCommand(addSubscriptionCmd)
-	addSubscriptionCmd.Flags().StringVarP(&configFile, "config", "d", "", "Path to subscription configuration file")
+	addSubscriptionCmd.Flags().StringVarP(&configFile, "config", "d", "", "Path to a YAML or JSON subscription configuration file")
+	addSubscriptionCmd.Flags().StringVar(&subscriptionSnapshot, "snapshot", "", "Seed the subscription with the outstanding messages of a snapshot")
+	addSubscriptionCmd.Flags().BoolVar(&subscriptionBackfill, "backfill", false, "Deliver the messages the topic retains, from the beginning of its retention")
+	addSubscriptionCmd.Flags().StringVar(&subscriptionBackfillFrom, "backfill-from", "", "Deliver the messages the topic retains published since this time, in RFC 3339 format")
 
 	addCmd.AddCommand(addMessageCmd)
 	addMessageCmd.Flags().StringVarP(&messagePayload, "message", "d", "", "Message payload")
+	addMessageCmd.Flags().StringVarP(&messageFile, "file", "f", "", "Read the message payload from a file, e.g. a binary-encoded message")
+	addMessageCmd.Flags().StringToStringVarP(&messageAttributes, "attribute", "a", nil, "Message attributes as key=value pairs")
 }
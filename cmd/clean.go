@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/nigel-campbell/pubsub/pubsub"
 	"github.com/spf13/cobra"
+	"log"
 	"os"
 )
 
@@ -18,6 +19,9 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if rootDaemon != "" || os.Getenv(daemonEnv) != "" {
+			log.Fatalf("The daemon owns %s; stop it before cleaning", pubsub.DefaultFilename)
+		}
 		fmt.Printf("Removing %s\n", pubsub.DefaultFilename)
 		_ = os.Remove(pubsub.DefaultFilename)
 
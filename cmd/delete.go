@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+// deleteCmd represents the base "delete" command
+var deleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete topics or subscriptions",
+	Long:  "Use this command to delete a topic or a subscription along with the messages stored for it.",
+}
+
+var deleteTopicCmd = &cobra.Command{
+	Use:   "topic [TOPIC_NAME]",
+	Short: "Delete a topic and its messages",
+	Long: `Deletes a topic and every message published to it. Subscriptions to the topic are kept but detached, so they
+no longer receive messages and can be deleted separately.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		err = svc.DeleteTopic(ctx, args[0])
+		if err != nil {
+			log.Fatalf("Error deleting topic: %v", err)
+		}
+		fmt.Println("Topic deleted successfully")
+	},
+}
+
+var deleteSubscriptionCmd = &cobra.Command{
+	Use:   "subscription [SUBSCRIPTION_NAME]",
+	Short: "Delete a subscription and its messages",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		err = svc.DeleteSubscription(ctx, args[0])
+		if err != nil {
+			log.Fatalf("Error deleting subscription: %v", err)
+		}
+		fmt.Println("Subscription deleted successfully")
+	},
+}
+
+var detachCmd = &cobra.Command{
+	Use:   "detach [SUBSCRIPTION_NAME]",
+	Short: "Detach a subscription from its topic",
+	Long: `Stops a subscription from receiving messages and drops its backlog. The subscription is kept, but pulling from
+it fails until it is deleted.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		err = svc.DetachSubscription(ctx, args[0])
+		if err != nil {
+			log.Fatalf("Error detaching subscription: %v", err)
+		}
+		fmt.Println("Subscription detached successfully")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+	deleteCmd.AddCommand(deleteTopicCmd)
+	deleteCmd.AddCommand(deleteSubscriptionCmd)
+	rootCmd.AddCommand(detachCmd)
+}
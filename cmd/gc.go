@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete messages that are no longer retained",
+	Long: `Deletes acknowledged messages, unless their subscription retains them, and any message older than its
+subscription's retention window.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		deleted, err := svc.GC(ctx, time.Now())
+		if err != nil {
+			log.Fatalf("Error deleting expired messages: %v", err)
+		}
+		fmt.Printf("Deleted %d message(s)\n", deleted)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"github.com/nigel-campbell/pubsub/pubsub"
 	"github.com/spf13/cobra"
 )
 
@@ -21,7 +20,7 @@ Examples:
   pubsub init   # Sets up the database and required tables
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			fmt.Println("Error creating Pub/Sub service:", err)
 			return
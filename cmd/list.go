@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/nigel-campbell/pubsub/pubsub"
 	"log"
-	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -26,10 +25,10 @@ var listTopicsCmd = &cobra.Command{
 	Use:   "topics",
 	Short: "List all topics",
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
 		defer cancel()
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Failed to initialize service: %v", err)
 		}
@@ -41,69 +40,90 @@ var listTopicsCmd = &cobra.Command{
 
 		fmt.Println("Topics:")
 		for _, topic := range topics {
-			fmt.Printf("- ID: %d, Name: %s, Metadata: %s\n", topic.ID, topic.Name, topic.Metadata)
+			fmt.Printf("- ID: %d, Name: %s, Labels: %v, Retention: %s\n", topic.ID, topic.Name, topic.Config.Labels,
+				topic.Config.MessageRetentionDuration)
 		}
 	},
 }
 
 // listSubscriptionsCmd lists subscriptions for a given topic
 var listSubscriptionsCmd = &cobra.Command{
-	Use:   "subscriptions [TOPIC_ID]",
+	Use:   "subscriptions [TOPIC_NAME]",
 	Short: "List all subscriptions for a specific topic",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
 		defer cancel()
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Failed to initialize service: %v", err)
 		}
 
-		topicId, err := strconv.Atoi(args[0])
-		if err != nil {
-			log.Fatalf("Error converting topic ID to integer: %v", err)
-		}
+		topicId := args[0]
 
 		subscriptions, err := svc.ListSubscriptions(ctx, topicId)
 		if err != nil {
-			log.Fatalf("Error retrieving subscriptions for topic %d: %v", topicId, err)
+			log.Fatalf("Error retrieving subscriptions for topic %s: %v", topicId, err)
 		}
-		fmt.Printf("Subscriptions for topic %d:\n", topicId)
+		fmt.Printf("Subscriptions for topic %s:\n", topicId)
+		now := time.Now()
 		for _, sub := range subscriptions {
-			fmt.Printf("- ID: %d, SubscriberID: %s\n", sub.ID, sub.SubscriberID)
+			delivery := ""
+			if sub.Config.Forward != nil {
+				delivery = ", Forward: " + sub.Config.Forward.Topic
+			}
+			if sub.Config.PushConfig != nil {
+				delivery = ", Push: " + sub.Config.PushConfig.Endpoint
+			}
+			fmt.Printf("- ID: %d, SubscriberID: %s, Labels: %v, AckDeadline: %s, Filter: %q%s%s\n", sub.ID, sub.SubscriberID,
+				sub.Config.Labels, sub.Config.EffectiveAckDeadline(), sub.Config.Filter, delivery, expiration(sub, now))
 		}
 	},
 }
 
+// expiringSoon is how close to its expiration an inactive subscription must be for list to warn about it.
+const expiringSoon = 24 * time.Hour
+
+// expiration describes when a subscription expires, warning when it is about to.
+func expiration(sub *pubsub.Subscription, now time.Time) string {
+	expireTime := sub.ExpireTime()
+	switch {
+	case expireTime.IsZero():
+		return ""
+	case !now.Before(expireTime):
+		return ", EXPIRED (deleted by the next gc)"
+	case expireTime.Sub(now) < expiringSoon:
+		return fmt.Sprintf(", EXPIRING in %s", expireTime.Sub(now).Round(time.Minute))
+	}
+	return fmt.Sprintf(", Expires: %s", expireTime.Local().Format(time.RFC3339))
+}
+
 // listMessagesCmd lists messages for a given topic and subscription
 var listMessagesCmd = &cobra.Command{
-	Use:   "messages [SUBSCRIPTION_ID]",
+	Use:   "messages [SUBSCRIPTION_NAME]",
 	Short: "List all messages for a specific subscription",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
 		defer cancel()
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Failed to initialize service: %v", err)
 		}
 
-		subscriptionId, err := strconv.Atoi(args[0])
-		if err != nil {
-			log.Fatalf("Error converting topic ID to integer: %v", err)
-		}
+		subscriptionId := args[0]
 
 		messages, err := svc.GetMessages(ctx, subscriptionId)
 		if err != nil {
-			log.Fatalf("Error retrieving messages for subscription %d: %v", subscriptionId, err)
+			log.Fatalf("Error retrieving messages for subscription %s: %v", subscriptionId, err)
 		}
 
 		if len(messages) > 0 {
-			fmt.Printf("Messages for subscription %d:\n", subscriptionId)
+			fmt.Printf("Messages for subscription %s:\n", subscriptionId)
 			for _, msg := range messages {
-				fmt.Println(msg)
+				printMessage(ctx, svc, msg)
 			}
 		} else {
 			fmt.Println("No messages found for subscription", subscriptionId)
@@ -111,6 +131,17 @@ var listMessagesCmd = &cobra.Command{
 	},
 }
 
+// printMessage prints a message, rendering the payload as JSON when its topic has a schema.
+func printMessage(ctx context.Context, svc pubsub.API, msg *pubsub.Message) {
+	decoded, err := svc.DecodeMessage(ctx, msg)
+	if err != nil {
+		log.Printf("Unable to decode message %d: %v", msg.ID, err)
+	} else {
+		msg.Content = decoded
+	}
+	fmt.Println(msg)
+}
+
 func init() {
 	rootCmd.AddCommand(listCmd)
 	listCmd.AddCommand(listTopicsCmd)
@@ -2,49 +2,71 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/nigel-campbell/pubsub/pubsub"
 	"github.com/spf13/cobra"
 	"log"
-	"strconv"
 	"time"
 )
 
 var ackDeadlineDuration time.Duration
+var pullOutput string
 
 var pullCmd = &cobra.Command{
-	Use:   "pull [SUBSCRIPTION_ID]",
+	Use:   "pull [SUBSCRIPTION_NAME]",
 	Short: "Pull unacknowledged messages from a subscription",
 	Long: `Retrieve messages from a specified subscription that have not been acknowledged.
-You can also set an acknowledgment deadline using the flag`,
+You can also set an acknowledgment deadline using the flag.
+
+With -o cloudevents, each message is printed on its own line as a CloudEvent in the structured JSON format.
+Messages published as CloudEvents are printed as the event they carry, and other messages as
+google.cloud.pubsub.topic.v1.messagePublished events, with the message ID as id and its publish time as time.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(commandContext(), 5*time.Second)
 		defer cancel()
 
-		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		svc, err := openService()
 		if err != nil {
 			log.Fatalf("Error creating Pub/Sub service: %v", err)
 		}
 		defer svc.Close()
 
-		subscriptionID, err := strconv.Atoi(args[0])
+		subscriptionID := args[0]
+		if pullOutput != "text" && pullOutput != "cloudevents" {
+			log.Fatalf("Unknown output format %q, expected text or cloudevents", pullOutput)
+		}
+		// CloudEvents name the topic of the subscription as their source.
+		subscription, err := svc.GetSubscription(ctx, subscriptionID)
 		if err != nil {
-			log.Fatalf("Invalid subscription ID: %s", args[0])
+			log.Fatalf("Failed to get subscription %s: %v", subscriptionID, err)
 		}
 
-		ackDeadline := time.Now().Add(ackDeadlineDuration)
+		// Without --deadline the subscription's configured ack deadline applies.
+		var ackDeadline time.Time
+		if ackDeadlineDuration > 0 {
+			ackDeadline = time.Now().Add(ackDeadlineDuration)
+		}
 
 		messages, err := svc.PullMessages(ctx, subscriptionID, ackDeadline)
 		if err != nil {
-			log.Fatalf("Failed to pull messages for subscription %d: %v", subscriptionID, err)
+			log.Fatalf("Failed to pull messages for subscription %s: %v", subscriptionID, err)
 		}
 
-		if len(messages) == 0 {
+		if pullOutput == "cloudevents" {
+			for _, msg := range messages {
+				event, err := json.Marshal(pubsub.MessageCloudEvent(subscription, msg))
+				if err != nil {
+					log.Fatalf("Failed to encode message %d: %v", msg.ID, err)
+				}
+				fmt.Println(string(event))
+			}
+		} else if len(messages) == 0 {
 			fmt.Println("No messages to pull.")
 		} else {
-			fmt.Printf("Pulled messages for subscription %d:\n", subscriptionID)
+			fmt.Printf("Pulled messages for subscription %s:\n", subscriptionID)
 			for _, msg := range messages {
-				fmt.Println(msg)
+				printMessage(ctx, svc, msg)
 			}
 		}
 	},
@@ -52,5 +74,6 @@ You can also set an acknowledgment deadline using the flag`,
 
 func init() {
 	rootCmd.AddCommand(pullCmd)
-	pullCmd.Flags().DurationVarP(&ackDeadlineDuration, "deadline", "d", time.Second*10, "Set the acknowledgment deadline for pulled messages (e.g., 1m, 2h)")
+	pullCmd.Flags().DurationVarP(&ackDeadlineDuration, "deadline", "d", 0, "Set the acknowledgment deadline for pulled messages (e.g., 1m, 2h; default the subscription's)")
+	pullCmd.Flags().StringVarP(&pullOutput, "output", "o", "text", "Output format (text or cloudevents)")
 }
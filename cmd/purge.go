@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var purgeBefore string
+var purgeOlderThan time.Duration
+var purgeFilter string
+var purgeDelete bool
+var purgeDryRun bool
+var purgeYes bool
+
+// purgeCmd represents the base "purge" command
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Drop the outstanding messages of a subscription or topic",
+	Long: `Acknowledges every outstanding message, leased or not, so that it is never delivered. With --delete the messages
+are deleted instead, and cannot be replayed with seek.
+
+The purge can be limited to messages published before a time, with --before or --older-than, and to messages whose
+attributes match a filter, with --filter. The number of matching messages is shown and confirmed before anything
+changes; use --dry-run to only count them and --yes to skip the confirmation.
+
+Examples:
+  pubsub purge subscription billing --older-than 24h
+  pubsub purge topic orders --filter 'attributes:test' --delete --yes`,
+}
+
+var purgeSubscriptionCmd = &cobra.Command{
+	Use:   "subscription [SUBSCRIPTION_NAME]",
+	Short: "Drop the outstanding messages of a subscription",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPurge("subscription", args[0], pubsub.API.Purge)
+	},
+}
+
+var purgeTopicCmd = &cobra.Command{
+	Use:   "topic [TOPIC_NAME]",
+	Short: "Drop the outstanding messages of every subscription of a topic",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPurge("topic", args[0], pubsub.API.PurgeTopic)
+	},
+}
+
+// runPurge counts the messages selected by the flags, asks for confirmation unless told not to, and purges them.
+func runPurge(kind, name string, purge func(pubsub.API, context.Context, string, pubsub.PurgeOptions) (int, error)) {
+	ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+	defer cancel()
+
+	opts := pubsub.PurgeOptions{Filter: purgeFilter, Delete: purgeDelete}
+	switch {
+	case purgeBefore != "" && purgeOlderThan != 0:
+		log.Fatalf("Use only one of --before and --older-than")
+	case purgeBefore != "":
+		var err error
+		if opts.Before, err = time.Parse(time.RFC3339, purgeBefore); err != nil {
+			log.Fatalf("Invalid time, expected RFC 3339 such as 2024-05-01T09:00:00Z: %v", err)
+		}
+	case purgeOlderThan != 0:
+		opts.Before = time.Now().Add(-purgeOlderThan)
+	}
+
+	svc, err := openService()
+	if err != nil {
+		log.Fatalf("Error creating Pub/Sub service: %v", err)
+	}
+	defer svc.Close()
+
+	action := "acknowledge"
+	if purgeDelete {
+		action = "delete"
+	}
+	if purgeDryRun || !purgeYes {
+		dryRun := opts
+		dryRun.DryRun = true
+		n, err := purge(svc, ctx, name, dryRun)
+		if err != nil {
+			log.Fatalf("Error purging %s: %v", kind, err)
+		}
+		if purgeDryRun || n == 0 {
+			fmt.Printf("Would %s %d message(s) of %s %s\n", action, n, kind, name)
+			return
+		}
+		fmt.Printf("%s %d message(s) of %s %s? [y/N] ", strings.ToUpper(action[:1])+action[1:], n, kind, name)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Purge cancelled")
+			return
+		}
+	}
+
+	n, err := purge(svc, ctx, name, opts)
+	if err != nil {
+		log.Fatalf("Error purging %s: %v", kind, err)
+	}
+	fmt.Printf("Purged %s %s: %d message(s) %sd\n", kind, name, n, action)
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+	purgeCmd.AddCommand(purgeSubscriptionCmd)
+	purgeCmd.AddCommand(purgeTopicCmd)
+	purgeCmd.PersistentFlags().StringVar(&purgeBefore, "before", "", "Only purge messages published before this time, in RFC 3339 format")
+	purgeCmd.PersistentFlags().DurationVar(&purgeOlderThan, "older-than", 0, "Only purge messages published longer ago than this (e.g., 30m, 24h)")
+	purgeCmd.PersistentFlags().StringVar(&purgeFilter, "filter", "", "Only purge messages whose attributes match this filter")
+	purgeCmd.PersistentFlags().BoolVar(&purgeDelete, "delete", false, "Delete the messages instead of acknowledging them")
+	purgeCmd.PersistentFlags().BoolVar(&purgeDryRun, "dry-run", false, "Only count the messages that would be purged")
+	purgeCmd.PersistentFlags().BoolVarP(&purgeYes, "yes", "y", false, "Purge without asking for confirmation")
+}
@@ -1,11 +1,28 @@
 package cmd
 
 import (
+	"context"
 	"os"
 
+	"github.com/nigel-campbell/pubsub/client"
+	"github.com/nigel-campbell/pubsub/pubsub"
 	"github.com/spf13/cobra"
 )
 
+// defaultSocket sits next to the database, in the working directory.
+const defaultSocket = "pubsub.sock"
+
+// daemonEnv names the environment variable giving the socket of the daemon when --daemon is not.
+const daemonEnv = "PUBSUB_DAEMON"
+
+// projectEnv names the environment variable giving the project of commands when --project is not.
+const projectEnv = "PUBSUB_PROJECT"
+
+var (
+	rootDaemon  string
+	rootProject string
+)
+
 // pubsubCmd represents the root command for managing the Pub/Sub emulator
 var rootCmd = &cobra.Command{
 	Use:   "pubsub",
@@ -19,6 +36,36 @@ This tool allows you to:
 `,
 }
 
+// openService returns what commands work with: the database, or the daemon listening on the Unix socket given with
+// --daemon or $PUBSUB_DAEMON, which then owns the database so that commands never contend with it for locks.
+func openService() (pubsub.API, error) {
+	socket := rootDaemon
+	if socket == "" {
+		socket = os.Getenv(daemonEnv)
+	}
+	if socket == "" {
+		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		if err != nil {
+			return nil, err
+		}
+		return svc, nil
+	}
+	return client.New("unix:"+socket, nil)
+}
+
+// commandContext returns the context commands call the service with, scoped to the project given with --project or
+// $PUBSUB_PROJECT, if any.
+func commandContext() context.Context {
+	project := rootProject
+	if project == "" {
+		project = os.Getenv(projectEnv)
+	}
+	if project == "" {
+		project = pubsub.DefaultProject
+	}
+	return pubsub.WithProject(context.Background(), project)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -34,6 +81,9 @@ func init() {
 	// will be global for your application.
 
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.pubsub.yaml)")
+	rootCmd.PersistentFlags().StringVar(&rootDaemon, "daemon", "", "Send commands to the daemon listening on this Unix socket (pubsub serve --socket) instead of opening the database, also set by $"+daemonEnv)
+	rootCmd.PersistentFlags().Lookup("daemon").NoOptDefVal = defaultSocket
+	rootCmd.PersistentFlags().StringVar(&rootProject, "project", "", "Project that names belong to and listings are scoped to, also set by $"+projectEnv+" (default \""+pubsub.DefaultProject+"\")")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
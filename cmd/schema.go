@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+var schemaType string
+var schemaDefinition string
+var schemaDefinitionFile string
+var schemaMessageType string
+var schemaCompatibility string
+var schemaDiffCompatibility string
+var schemaRevision int
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Manage message schemas",
+	Long: `Create, inspect and delete the schemas that topics can be bound to.
+
+Messages published to a topic bound to a schema are rejected unless their payload validates against it.
+
+Examples:
+  pubsub schema create orders --type json --definition-file orders.schema.json
+  pubsub schema create events --type avro --definition-file event.avsc
+  pubsub schema create users --type protobuf --definition-file users.pb --message-type example.User
+  pubsub add topic orders --schema orders
+  pubsub add topic users --schema users --schema-encoding binary
+  pubsub schema diff orders --definition-file orders.v2.schema.json
+  pubsub schema commit orders --definition-file orders.v2.schema.json
+`,
+}
+
+// readSchemaDefinition returns the definition given with --definition or --definition-file.
+func readSchemaDefinition() string {
+	definition := schemaDefinition
+	if schemaDefinitionFile != "" {
+		b, err := os.ReadFile(schemaDefinitionFile)
+		if err != nil {
+			log.Fatalf("Error reading schema definition: %v", err)
+		}
+		definition = string(b)
+	}
+	if definition == "" {
+		log.Fatalf("A schema definition is required, use --definition or --definition-file")
+	}
+	return definition
+}
+
+var schemaCreateCmd = &cobra.Command{
+	Use:   "create [SCHEMA_NAME]",
+	Short: "Create a new schema",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		typ, err := pubsub.ParseSchemaType(schemaType)
+		if err != nil {
+			log.Fatalf("Invalid schema type: %v", err)
+		}
+
+		compatibility, err := pubsub.ParseCompatibility(schemaCompatibility)
+		if err != nil {
+			log.Fatalf("Invalid compatibility mode: %v", err)
+		}
+		definition := readSchemaDefinition()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		err = svc.CreateSchema(ctx, &pubsub.Schema{
+			Name:          args[0],
+			Type:          typ,
+			Definition:    definition,
+			MessageType:   schemaMessageType,
+			Compatibility: compatibility,
+		})
+		if err != nil {
+			log.Fatalf("Error creating schema: %v", err)
+		}
+		fmt.Println("Schema created successfully")
+	},
+}
+
+var schemaGetCmd = &cobra.Command{
+	Use:   "get [SCHEMA_NAME]",
+	Short: "Print a schema and its definition",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		schema, err := svc.GetSchemaRevision(ctx, args[0], schemaRevision)
+		if err != nil {
+			log.Fatalf("Error retrieving schema: %v", err)
+		}
+		fmt.Printf("Name: %s\nType: %s\nRevision: %d\nCompatibility: %s\nCreated: %s\n", schema.Name, schema.Type,
+			schema.Revision, schema.Compatibility, schema.CreatedAt)
+		if schema.Type == pubsub.SchemaTypeProtocolBuffer {
+			// Descriptor sets are binary and not worth printing.
+			fmt.Printf("Message type: %s\nDefinition: %d byte descriptor set\n", schema.MessageType, len(schema.Definition))
+			return
+		}
+		fmt.Printf("Definition:\n%s\n", schema.Definition)
+	},
+}
+
+var schemaListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all schemas",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		schemas, err := svc.ListSchemas(ctx)
+		if err != nil {
+			log.Fatalf("Error retrieving schemas: %v", err)
+		}
+
+		fmt.Println("Schemas:")
+		for _, schema := range schemas {
+			fmt.Printf("- Name: %s, Type: %s, Revision: %d, Compatibility: %s\n", schema.Name, schema.Type, schema.Revision, schema.Compatibility)
+		}
+	},
+}
+
+var schemaCommitCmd = &cobra.Command{
+	Use:   "commit [SCHEMA_NAME]",
+	Short: "Commit a new revision of a schema",
+	Long: `Adds a new revision to an existing schema. The revision is checked against every existing revision using the
+schema's compatibility mode and rejected if any change breaks it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		definition := readSchemaDefinition()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		schema, err := svc.CommitSchema(ctx, args[0], definition, schemaMessageType)
+		if err != nil {
+			log.Fatalf("Error committing schema: %v", err)
+		}
+		fmt.Printf("Committed revision %d of schema %s\n", schema.Revision, schema.Name)
+	},
+}
+
+var schemaRevisionsCmd = &cobra.Command{
+	Use:   "revisions [SCHEMA_NAME]",
+	Short: "List the revisions of a schema",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		revisions, err := svc.ListSchemaRevisions(ctx, args[0])
+		if err != nil {
+			log.Fatalf("Error retrieving schema revisions: %v", err)
+		}
+
+		fmt.Printf("Revisions of schema %s:\n", args[0])
+		for _, revision := range revisions {
+			fmt.Printf("- Revision: %d, Created: %s\n", revision.Revision, revision.CreatedAt)
+		}
+	},
+}
+
+var schemaDiffCmd = &cobra.Command{
+	Use:   "diff [SCHEMA_NAME]",
+	Short: "Explain how a candidate definition differs from a schema revision",
+	Long: `Compares a candidate definition with a revision of a schema (the latest by default) and lists every change that
+can make data written with one of them invalid under the other.
+
+The command exits with a non-zero status if any change breaks the compatibility mode, which defaults to the schema's
+own mode, so it can be used in CI to catch breaking changes before they are committed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		definition := readSchemaDefinition()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		schema, err := svc.GetSchemaRevision(ctx, args[0], schemaRevision)
+		if err != nil {
+			log.Fatalf("Error retrieving schema: %v", err)
+		}
+
+		compatibility := schema.Compatibility
+		if schemaDiffCompatibility != "" {
+			if compatibility, err = pubsub.ParseCompatibility(schemaDiffCompatibility); err != nil {
+				log.Fatalf("Invalid compatibility mode: %v", err)
+			}
+		}
+
+		candidate := *schema
+		candidate.Definition = definition
+		candidate.MessageType = schemaMessageType
+		if candidate.MessageType == "" {
+			candidate.MessageType = schema.MessageType
+		}
+		changes, err := pubsub.DiffSchemas(schema, &candidate)
+		if err != nil {
+			log.Fatalf("Error comparing schemas: %v", err)
+		}
+
+		if len(changes) == 0 {
+			fmt.Printf("No changes affecting compatibility with revision %d\n", schema.Revision)
+			return
+		}
+		fmt.Printf("Changes since revision %d:\n", schema.Revision)
+		for _, change := range changes {
+			fmt.Printf("- %s\n", change)
+		}
+
+		if incompatible := compatibility.Incompatible(changes); len(incompatible) > 0 {
+			fmt.Printf("%d change(s) break %s compatibility\n", len(incompatible), compatibility)
+			os.Exit(1)
+		}
+		fmt.Printf("Candidate is %s compatible with revision %d\n", compatibility, schema.Revision)
+	},
+}
+
+var schemaDeleteCmd = &cobra.Command{
+	Use:   "delete [SCHEMA_NAME]",
+	Short: "Delete a schema that is not bound to any topic",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		err = svc.DeleteSchema(ctx, args[0])
+		if err != nil {
+			log.Fatalf("Error deleting schema: %v", err)
+		}
+		fmt.Println("Schema deleted successfully")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+
+	schemaCmd.AddCommand(schemaCreateCmd)
+	schemaCreateCmd.Flags().StringVarP(&schemaType, "type", "t", "json", "Schema type (json, avro or protobuf)")
+	schemaCreateCmd.Flags().StringVar(&schemaDefinition, "definition", "", "Schema definition")
+	schemaCreateCmd.Flags().StringVarP(&schemaDefinitionFile, "definition-file", "f", "", "Path to a file containing the schema definition")
+	schemaCreateCmd.Flags().StringVar(&schemaMessageType, "message-type", "", "Fully-qualified protobuf message type within the descriptor set")
+	schemaCreateCmd.Flags().StringVar(&schemaCompatibility, "compatibility", "backward", "Checks new revisions must pass (none, backward, forward or full)")
+
+	schemaCmd.AddCommand(schemaCommitCmd)
+	schemaCommitCmd.Flags().StringVar(&schemaDefinition, "definition", "", "Schema definition")
+	schemaCommitCmd.Flags().StringVarP(&schemaDefinitionFile, "definition-file", "f", "", "Path to a file containing the schema definition")
+	schemaCommitCmd.Flags().StringVar(&schemaMessageType, "message-type", "", "Fully-qualified protobuf message type within the descriptor set")
+
+	schemaCmd.AddCommand(schemaDiffCmd)
+	schemaDiffCmd.Flags().StringVar(&schemaDefinition, "definition", "", "Candidate schema definition")
+	schemaDiffCmd.Flags().StringVarP(&schemaDefinitionFile, "definition-file", "f", "", "Path to a file containing the candidate schema definition")
+	schemaDiffCmd.Flags().StringVar(&schemaMessageType, "message-type", "", "Fully-qualified protobuf message type within the descriptor set")
+	schemaDiffCmd.Flags().IntVarP(&schemaRevision, "revision", "r", 0, "Revision to compare against (default latest)")
+	schemaDiffCmd.Flags().StringVar(&schemaDiffCompatibility, "compatibility", "", "Compatibility mode to check (default the schema's own)")
+
+	schemaCmd.AddCommand(schemaRevisionsCmd)
+	schemaCmd.AddCommand(schemaGetCmd)
+	schemaGetCmd.Flags().IntVarP(&schemaRevision, "revision", "r", 0, "Revision to print (default latest)")
+	schemaCmd.AddCommand(schemaListCmd)
+	schemaCmd.AddCommand(schemaDeleteCmd)
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+var seekTime string
+var seekAgo time.Duration
+var seekSnapshot string
+
+var seekCmd = &cobra.Command{
+	Use:   "seek [SUBSCRIPTION_NAME]",
+	Short: "Replay or skip a subscription's messages from a point in time or a snapshot",
+	Long: `Marks every message published at or after the given time as unacknowledged, clearing its lease, and every
+earlier message as acknowledged.
+
+With --snapshot, restores the state captured by a snapshot instead: messages that were outstanding in the snapshot or
+published after it become unacknowledged, and every other message is acknowledged.
+
+Acknowledged messages can only be replayed while the subscription retains them, so enable retainAckedMessages in its
+configuration before relying on seek.
+
+Examples:
+  pubsub seek billing --time 2024-05-01T09:00:00Z
+  pubsub seek billing --ago 1h
+  pubsub seek billing --snapshot before-deploy`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		var t time.Time
+		switch {
+		case seekSnapshot != "" && (seekTime != "" || seekAgo != 0), seekTime != "" && seekAgo != 0:
+			log.Fatalf("Use only one of --time, --ago and --snapshot")
+		case seekSnapshot != "":
+			// Seeked to below.
+		case seekTime != "":
+			var err error
+			if t, err = time.Parse(time.RFC3339, seekTime); err != nil {
+				log.Fatalf("Invalid time, expected RFC 3339 such as 2024-05-01T09:00:00Z: %v", err)
+			}
+		case seekAgo != 0:
+			t = time.Now().Add(-seekAgo)
+		default:
+			log.Fatalf("A time or snapshot is required, use --time, --ago or --snapshot")
+		}
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		var result *pubsub.SeekResult
+		target := seekSnapshot
+		if seekSnapshot != "" {
+			result, err = svc.SeekToSnapshot(ctx, args[0], seekSnapshot)
+		} else {
+			result, err = svc.Seek(ctx, args[0], t)
+			target = t.Format(time.RFC3339)
+		}
+		if err != nil {
+			log.Fatalf("Error seeking subscription: %v", err)
+		}
+		fmt.Printf("Seeked subscription %s to %s: %d message(s) redelivered, %d message(s) acknowledged\n",
+			args[0], target, result.Redelivered, result.Acknowledged)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(seekCmd)
+	seekCmd.Flags().StringVar(&seekTime, "time", "", "Time to seek to, in RFC 3339 format")
+	seekCmd.Flags().DurationVar(&seekAgo, "ago", 0, "Seek to this long ago (e.g., 30m, 2h)")
+	seekCmd.Flags().StringVar(&seekSnapshot, "snapshot", "", "Snapshot to seek to")
+}
@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"github.com/nigel-campbell/pubsub/server"
+	"github.com/spf13/cobra"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// defaultGRPCAddr is the address the Google Cloud SDK's emulator listens on.
+const defaultGRPCAddr = "localhost:8085"
+
+// defaultRESTAddr is the port after the gRPC one, so that both APIs can be served side by side.
+const defaultRESTAddr = "localhost:8086"
+
+// defaultRPCAddr follows the REST one.
+const defaultRPCAddr = "localhost:8087"
+
+// defaultMQTTAddr is the standard MQTT port.
+const defaultMQTTAddr = "localhost:1883"
+
+// defaultSTOMPAddr is the port of the STOMP brokers ActiveMQ and RabbitMQ.
+const defaultSTOMPAddr = "localhost:61613"
+
+// defaultPIDFile sits next to the database, in the working directory.
+const defaultPIDFile = "pubsub.pid"
+
+var serveGRPC string
+var serveREST string
+var serveRPC string
+var serveMQTT string
+var serveSTOMP string
+var serveAllowOrigins []string
+var serveSocket string
+var serveSocketMode string
+var servePIDFile string
+var serveGCInterval time.Duration
+var serveLeaseInterval time.Duration
+var servePushInterval time.Duration
+var serveShutdownTimeout time.Duration
+
+// pidFile is written once the servers listen, so that test harnesses can wait for it and connect to the addresses
+// actually bound, even when the port was chosen by the system with an address such as 127.0.0.1:0.
+type pidFile struct {
+	PID    int    `json:"pid"`
+	GRPC   string `json:"grpc,omitempty"`
+	REST   string `json:"rest,omitempty"`
+	RPC    string `json:"rpc,omitempty"`
+	MQTT   string `json:"mqtt,omitempty"`
+	STOMP  string `json:"stomp,omitempty"`
+	Socket string `json:"socket,omitempty"`
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the Pub/Sub emulator as a long-running server",
+	Long: `Runs a server that owns the database for its whole life and serves it to applications using the official Google
+Cloud Pub/Sub client libraries, or any HTTP client.
+
+With --grpc, the google.pubsub.v1 Publisher and Subscriber services are served over gRPC. Point the client libraries
+at the server by setting PUBSUB_EMULATOR_HOST to its address; any project ID can be used. gRPC is served on
+localhost:8085 when no listener is given.
+
+With --rest, topics and subscriptions are served as JSON over HTTP, in the shape of the Pub/Sub v1 REST API: message
+data is base64 encoded and custom methods follow the resource name, as in POST /v1/projects/PROJECT/topics/TOPIC:publish.
+Browsers may only stream subscriptions from pages of the same origin, or of an origin given with --allow-origin.
+
+With --rpc, every method of the pubsub Go package is served to the Go client package, so that Go services can share
+the server instead of each opening the database.
+
+With --socket, the same API is served on a Unix socket instead of a TCP port, with --socket-mode as its permissions,
+which decide who may connect. Other commands then send their work to the server when given --daemon, or when
+PUBSUB_DAEMON is set, instead of opening the database themselves.
+
+With --mqtt, MQTT 3.1.1 clients publish to and subscribe to topics, whose levels are separated by slashes in MQTT and
+by dots in Pub/Sub: the MQTT topic devices/42/temperature is the topic devices.42.temperature. Subscribing to a topic
+filter, wildcards included, creates a subscription on every matching topic for the length of the connection.
+
+With --stomp, STOMP 1.2 clients SEND to /topic/TOPIC destinations, with their headers as attributes, and SUBSCRIBE to
+/subscription/SUBSCRIPTION destinations, or to /topic/TOPIC destinations for a subscription of their own. Messages are
+acknowledged by ACK and NACK with the client and client-individual ack modes.
+
+While running, the server deletes messages that are no longer retained (as pubsub gc does), clears expired leases and
+pushes the messages of push subscriptions to their endpoints; an interval of 0 disables a task. Once listening, it
+writes its PID and the addresses it listens on to --pid-file as JSON. On SIGINT or SIGTERM it stops accepting
+requests, waits up to --shutdown-timeout for those in flight, and removes the PID file.
+
+Examples:
+  pubsub serve                         # gRPC on localhost:8085
+  pubsub serve --grpc=0.0.0.0:9000
+  pubsub serve --grpc --rest           # gRPC on localhost:8085 and REST on localhost:8086
+  pubsub serve --rpc                   # The Go client API on localhost:8087
+  pubsub serve --grpc --mqtt           # gRPC on localhost:8085 and MQTT on localhost:1883
+  pubsub serve --stomp                 # STOMP on localhost:61613
+  pubsub serve --socket                # The Go client API on ./pubsub.sock, for pubsub --daemon
+  pubsub serve --grpc=127.0.0.1:0 --pid-file /tmp/pubsub.json   # Any free port, read it from the PID file`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if serveGRPC == "" && serveREST == "" && serveRPC == "" && serveMQTT == "" && serveSTOMP == "" && serveSocket == "" {
+			serveGRPC = defaultGRPCAddr
+		}
+
+		svc, err := pubsub.NewService(pubsub.DefaultFilename)
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+		if err := svc.Init(context.Background()); err != nil {
+			log.Fatalf("Error initializing Pub/Sub service: %v", err)
+		}
+
+		errs := make(chan error, 6)
+		var stops []func(ctx context.Context)
+		info := pidFile{PID: os.Getpid()}
+		if serveGRPC != "" {
+			lis, err := net.Listen("tcp", serveGRPC)
+			if err != nil {
+				log.Fatalf("Error listening on %s: %v", serveGRPC, err)
+			}
+			srv := server.NewGRPC(svc)
+			stops = append(stops, func(ctx context.Context) {
+				// Streaming pulls only end when their clients close them, so the drain is cut short at the timeout.
+				stopped := make(chan struct{})
+				go func() {
+					srv.GracefulStop()
+					close(stopped)
+				}()
+				select {
+				case <-stopped:
+				case <-ctx.Done():
+					srv.Stop()
+				}
+			})
+			go func() {
+				if err := srv.Serve(lis); err != nil {
+					errs <- fmt.Errorf("gRPC: %w", err)
+				}
+			}()
+			info.GRPC = lis.Addr().String()
+			fmt.Printf("Serving google.pubsub.v1 over gRPC on %s\n", lis.Addr())
+			fmt.Printf("Connect client libraries with: export PUBSUB_EMULATOR_HOST=%s\n", lis.Addr())
+		}
+		listen := func(addr string) net.Listener {
+			lis, err := net.Listen("tcp", addr)
+			if err != nil {
+				log.Fatalf("Error listening on %s: %v", addr, err)
+			}
+			return lis
+		}
+		serveHTTP := func(name string, lis net.Listener, handler http.Handler) net.Addr {
+			srv := &http.Server{Handler: handler}
+			stops = append(stops, func(ctx context.Context) {
+				if err := srv.Shutdown(ctx); err != nil {
+					srv.Close()
+				}
+			})
+			go func() {
+				if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+					errs <- fmt.Errorf("%s: %w", name, err)
+				}
+			}()
+			return lis.Addr()
+		}
+		if serveREST != "" {
+			addr := serveHTTP("REST", listen(serveREST), server.NewREST(svc, serveAllowOrigins...))
+			info.REST = addr.String()
+			fmt.Printf("Serving google.pubsub.v1 over REST on http://%s/v1/\n", addr)
+		}
+		if serveRPC != "" {
+			addr := serveHTTP("RPC", listen(serveRPC), server.NewRPC(svc))
+			info.RPC = addr.String()
+			fmt.Printf("Serving the Go client API on %s\n", addr)
+		}
+		if serveSocket != "" {
+			mode, err := strconv.ParseUint(serveSocketMode, 8, 32)
+			if err != nil || mode > 0777 {
+				log.Fatalf("Invalid --socket-mode %q, expected octal permissions such as 0600", serveSocketMode)
+			}
+			lis, err := server.ListenUnix(serveSocket, os.FileMode(mode))
+			if err != nil {
+				log.Fatalf("Error listening on %s: %v", serveSocket, err)
+			}
+			addr := serveHTTP("Socket", lis, server.NewRPC(svc))
+			info.Socket = addr.String()
+			fmt.Printf("Serving the Go client API on unix:%s\n", addr)
+			fmt.Printf("Route commands through it with: export %s=%s\n", daemonEnv, addr)
+		}
+		// MQTT and STOMP sessions last as long as their connections, so they are closed at once.
+		serveTCP := func(name string, lis net.Listener, srv interface {
+			Serve(net.Listener) error
+			Close() error
+		}) net.Addr {
+			stops = append(stops, func(ctx context.Context) { srv.Close() })
+			go func() {
+				if err := srv.Serve(lis); !errors.Is(err, server.ErrServerClosed) {
+					errs <- fmt.Errorf("%s: %w", name, err)
+				}
+			}()
+			return lis.Addr()
+		}
+		if serveMQTT != "" {
+			addr := serveTCP("MQTT", listen(serveMQTT), server.NewMQTT(svc, nil))
+			info.MQTT = addr.String()
+			fmt.Printf("Serving MQTT 3.1.1 on %s\n", addr)
+		}
+		if serveSTOMP != "" {
+			addr := serveTCP("STOMP", listen(serveSTOMP), server.NewSTOMP(svc, nil))
+			info.STOMP = addr.String()
+			fmt.Printf("Serving STOMP 1.2 on %s\n", addr)
+		}
+
+		if servePIDFile != "" {
+			data, err := json.Marshal(info)
+			if err != nil {
+				log.Fatalf("Error encoding PID file: %v", err)
+			}
+			if err := os.WriteFile(servePIDFile, append(data, '\n'), 0644); err != nil {
+				log.Fatalf("Error writing PID file: %v", err)
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		background := make(chan struct{})
+		go func() {
+			server.RunBackground(ctx, svc, server.BackgroundOptions{
+				GCInterval:    serveGCInterval,
+				LeaseInterval: serveLeaseInterval,
+				PushInterval:  servePushInterval,
+			})
+			close(background)
+		}()
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		var serveErr error
+		select {
+		case <-signals:
+			fmt.Println("Shutting down")
+		case serveErr = <-errs:
+		}
+
+		shutdown, cancelShutdown := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancelShutdown()
+		for _, stop := range stops {
+			stop(shutdown)
+		}
+		cancel()
+		<-background
+		if servePIDFile != "" {
+			os.Remove(servePIDFile)
+		}
+		if serveErr != nil {
+			log.Fatalf("Error serving %v", serveErr)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveGRPC, "grpc", "", "Serve the google.pubsub.v1 gRPC API on this address")
+	serveCmd.Flags().Lookup("grpc").NoOptDefVal = defaultGRPCAddr
+	serveCmd.Flags().StringVar(&serveREST, "rest", "", "Serve the google.pubsub.v1 REST API on this address")
+	serveCmd.Flags().Lookup("rest").NoOptDefVal = defaultRESTAddr
+	serveCmd.Flags().StringSliceVar(&serveAllowOrigins, "allow-origin", nil, "Origins of web pages that may stream subscriptions over REST, such as http://localhost:3000, or * for any")
+	serveCmd.Flags().StringVar(&serveRPC, "rpc", "", "Serve the API of the Go client package on this address")
+	serveCmd.Flags().Lookup("rpc").NoOptDefVal = defaultRPCAddr
+	serveCmd.Flags().StringVar(&serveMQTT, "mqtt", "", "Serve MQTT 3.1.1 on this address")
+	serveCmd.Flags().Lookup("mqtt").NoOptDefVal = defaultMQTTAddr
+	serveCmd.Flags().StringVar(&serveSTOMP, "stomp", "", "Serve STOMP 1.2 on this address")
+	serveCmd.Flags().Lookup("stomp").NoOptDefVal = defaultSTOMPAddr
+	serveCmd.Flags().StringVar(&serveSocket, "socket", "", "Serve the API of the Go client package on this Unix socket")
+	serveCmd.Flags().Lookup("socket").NoOptDefVal = defaultSocket
+	serveCmd.Flags().StringVar(&serveSocketMode, "socket-mode", "0600", "Permissions of the Unix socket, in octal")
+	serveCmd.Flags().StringVar(&servePIDFile, "pid-file", defaultPIDFile, "Write the PID and listening addresses to this file, empty to disable")
+	serveCmd.Flags().DurationVar(&serveGCInterval, "gc-interval", time.Minute, "How often to delete messages that are no longer retained")
+	serveCmd.Flags().DurationVar(&serveLeaseInterval, "lease-interval", 10*time.Second, "How often to clear expired leases")
+	serveCmd.Flags().DurationVar(&servePushInterval, "push-interval", time.Second, "How often to push messages to push subscriptions")
+	serveCmd.Flags().DurationVar(&serveShutdownTimeout, "shutdown-timeout", 10*time.Second, "How long to wait for in-flight requests when shutting down")
+}
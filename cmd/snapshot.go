@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+var snapshotLifetime time.Duration
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage subscription snapshots",
+	Long: `Capture which messages of a subscription are outstanding so that it can be rolled back later.
+
+Examples:
+  pubsub snapshot create before-deploy billing --lifetime 24h
+  pubsub seek billing --snapshot before-deploy
+  pubsub add subscription orders replay --snapshot before-deploy
+`,
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create [SNAPSHOT_NAME] [SUBSCRIPTION_NAME]",
+	Short: "Snapshot the acknowledgement state of a subscription",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		snapshot, err := svc.CreateSnapshot(ctx, args[0], args[1], snapshotLifetime)
+		if err != nil {
+			log.Fatalf("Error creating snapshot: %v", err)
+		}
+		fmt.Printf("Created snapshot %s of subscription %s with %d outstanding message(s), expiring %s\n",
+			snapshot.Name, snapshot.Subscription, snapshot.Messages, snapshot.ExpireAt.Local().Format(time.RFC3339))
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List snapshots that have not expired",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		snapshots, err := svc.ListSnapshots(ctx)
+		if err != nil {
+			log.Fatalf("Error retrieving snapshots: %v", err)
+		}
+
+		fmt.Println("Snapshots:")
+		for _, snapshot := range snapshots {
+			fmt.Printf("- Name: %s, Topic: %s, Subscription: %s, Messages: %d, Created: %s, Expires: %s\n", snapshot.Name,
+				snapshot.Topic, snapshot.Subscription, snapshot.Messages, snapshot.CreatedAt.Local().Format(time.RFC3339),
+				snapshot.ExpireAt.Local().Format(time.RFC3339))
+		}
+	},
+}
+
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete [SNAPSHOT_NAME]",
+	Short: "Delete a snapshot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		if err := svc.DeleteSnapshot(ctx, args[0]); err != nil {
+			log.Fatalf("Error deleting snapshot: %v", err)
+		}
+		fmt.Println("Snapshot deleted successfully")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCreateCmd.Flags().DurationVar(&snapshotLifetime, "lifetime", pubsub.DefaultSnapshotLifetime, "How long the snapshot is kept")
+
+	snapshotCmd.AddCommand(snapshotListCmd)
+	snapshotCmd.AddCommand(snapshotDeleteCmd)
+}
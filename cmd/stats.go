@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [TOPIC_NAME]",
+	Short: "Show message storage statistics",
+	Long: `Shows how many messages are stored for a topic, or for every topic when none is given, along with the size of
+their payloads as published and as stored after compression.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		var topicIDs []string
+		if len(args) == 1 {
+			topicIDs = append(topicIDs, args[0])
+		} else {
+			topics, err := svc.ListTopics(ctx)
+			if err != nil {
+				log.Fatalf("Error retrieving topics: %v", err)
+			}
+			for _, topic := range topics {
+				topicIDs = append(topicIDs, topic.Name)
+			}
+		}
+
+		for _, topicID := range topicIDs {
+			stats, err := svc.Stats(ctx, topicID)
+			if err != nil {
+				log.Fatalf("Error retrieving stats for topic %s: %v", topicID, err)
+			}
+			fmt.Printf("- Topic: %s, Messages: %d, Unacknowledged: %d, Size: %d bytes, Stored: %d bytes\n",
+				topicID, stats.Messages, stats.Unacknowledged, stats.Size, stats.StoredSize)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+}
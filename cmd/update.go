@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var updateMask []string
+
+// updateCmd represents the base "update" command
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update the configuration of topics or subscriptions",
+	Long: `Use this command to change the configuration of an existing topic or subscription without recreating it.
+
+The new values are read from a YAML or JSON file given with -d/--config, in the same format used by "add". Only the
+fields named with --update-mask are changed, defaulting to the fields present in the file. Fields named in the mask
+but missing from the file are reset to their defaults.
+
+Examples:
+  pubsub update subscription billing -d billing.yaml
+  pubsub update subscription billing -d billing.yaml --update-mask ackDeadline,retryPolicy.maximumBackoff
+  pubsub update topic orders -d orders.yaml --update-mask labels
+`,
+}
+
+// readUpdate returns the configuration file given with -d/--config and the update mask to apply.
+func readUpdate() ([]byte, []string) {
+	if configFile == "" {
+		log.Fatalf("A configuration file is required, use -d/--config")
+	}
+	data := readConfigFile()
+	if len(updateMask) > 0 {
+		return data, updateMask
+	}
+	mask, err := pubsub.ConfigFields(data)
+	if err != nil {
+		log.Fatalf("Invalid configuration in %s: %v", configFile, err)
+	}
+	return data, mask
+}
+
+var updateTopicCmd = &cobra.Command{
+	Use:   "topic [TOPIC_NAME]",
+	Short: "Update the configuration of a topic",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		data, mask := readUpdate()
+		config, err := pubsub.ParseTopicConfig(data)
+		if err != nil {
+			log.Fatalf("Invalid topic configuration in %s:\n%v", configFile, err)
+		}
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		topic, err := svc.UpdateTopic(ctx, args[0], config, mask)
+		if err != nil {
+			log.Fatalf("Error updating topic:\n%v", err)
+		}
+		fmt.Printf("Updated topic %s: Labels: %v, Retention: %s\n", topic.Name, topic.Config.Labels,
+			topic.Config.MessageRetentionDuration)
+	},
+}
+
+var updateSubscriptionCmd = &cobra.Command{
+	Use:   "subscription [SUBSCRIPTION_NAME]",
+	Short: "Update the configuration of a subscription",
+	Long: `Updates the configuration of a subscription. Changes apply to future deliveries; messages that are already
+pulled keep their current ack deadline.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(commandContext(), dbTimeout)
+		defer cancel()
+
+		data, mask := readUpdate()
+		config, err := pubsub.ParseSubscriptionConfig(data)
+		if err != nil {
+			log.Fatalf("Invalid subscription configuration in %s:\n%v", configFile, err)
+		}
+
+		svc, err := openService()
+		if err != nil {
+			log.Fatalf("Error creating Pub/Sub service: %v", err)
+		}
+		defer svc.Close()
+
+		sub, err := svc.UpdateSubscription(ctx, args[0], config, mask)
+		if err != nil {
+			log.Fatalf("Error updating subscription:\n%v", err)
+		}
+		fmt.Printf("Updated subscription %s: Labels: %v, AckDeadline: %s, Filter: %q\n", sub.SubscriberID,
+			sub.Config.Labels, sub.Config.EffectiveAckDeadline(), sub.Config.Filter)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+
+	updateCmd.AddCommand(updateTopicCmd)
+	updateTopicCmd.Flags().StringVarP(&configFile, "config", "d", "", "Path to a YAML or JSON file with the new configuration")
+	updateTopicCmd.Flags().StringSliceVar(&updateMask, "update-mask", nil, "Comma-separated fields to update (default the fields in the file)")
+
+	updateCmd.AddCommand(updateSubscriptionCmd)
+	updateSubscriptionCmd.Flags().StringVarP(&configFile, "config", "d", "", "Path to a YAML or JSON file with the new configuration")
+	updateSubscriptionCmd.Flags().StringSliceVar(&updateMask, "update-mask", nil, "Comma-separated fields to update (default the fields in the file)")
+}
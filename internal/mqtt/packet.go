@@ -0,0 +1,473 @@
+// Package mqtt encodes and decodes the control packets of MQTT 3.1.1, for the MQTT listener of the pubsub serve
+// daemon and its tests.
+//
+// Every packet type is a struct implementing Packet. Read decodes the next packet from a stream, and Write encodes
+// one. Only what the protocol carries is checked here; session rules, such as CONNECT coming first, are left to the
+// caller.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Packet types, as found in the high nibble of the fixed header.
+const (
+	TypeConnect     = 1
+	TypeConnack     = 2
+	TypePublish     = 3
+	TypePuback      = 4
+	TypePubrec      = 5
+	TypePubrel      = 6
+	TypePubcomp     = 7
+	TypeSubscribe   = 8
+	TypeSuback      = 9
+	TypeUnsubscribe = 10
+	TypeUnsuback    = 11
+	TypePingreq     = 12
+	TypePingresp    = 13
+	TypeDisconnect  = 14
+)
+
+// CONNACK return codes.
+const (
+	Accepted                   = 0
+	RefusedProtocolVersion     = 1
+	RefusedIdentifierRejected  = 2
+	RefusedServerUnavailable   = 3
+	RefusedBadUsernamePassword = 4
+	RefusedNotAuthorized       = 5
+)
+
+// SubscribeFailure is the SUBACK return code of a topic filter that could not be subscribed.
+const SubscribeFailure = 0x80
+
+const (
+	// ProtocolName and ProtocolLevel identify MQTT 3.1.1 in CONNECT.
+	ProtocolName  = "MQTT"
+	ProtocolLevel = 4
+	// MaxPacketSize bounds the packets read, well below the 256 MB MQTT allows, as Pub/Sub messages are at most 10 MB.
+	MaxPacketSize = 16 << 20
+
+	maxRemainingLengthBytes     = 4
+	remainingLengthContinuation = 0x80
+)
+
+// ErrMalformed is matched by the errors of packets that do not follow the protocol.
+var ErrMalformed = errors.New("malformed MQTT packet")
+
+func malformed(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+}
+
+// Packet is an MQTT control packet.
+type Packet interface {
+	// encode returns the first byte of the fixed header and the rest of the packet.
+	encode() (byte, []byte)
+}
+
+// Connect opens a session.
+type Connect struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanSession  bool
+	KeepAlive     uint16 // Seconds
+	ClientID      string
+	// Will is published when the connection is lost without a DISCONNECT.
+	Will     *Publish
+	Username *string
+	Password []byte
+}
+
+// Connack answers a Connect.
+type Connack struct {
+	SessionPresent bool
+	ReturnCode     byte
+}
+
+// Publish carries a message in either direction. PacketID is only set for QoS 1 and 2.
+type Publish struct {
+	Dup      bool
+	QoS      byte
+	Retain   bool
+	Topic    string
+	PacketID uint16
+	Payload  []byte
+}
+
+// Puback acknowledges a QoS 1 Publish.
+type Puback struct{ PacketID uint16 }
+
+// Pubrec, Pubrel and Pubcomp are the exchange acknowledging a QoS 2 Publish.
+type Pubrec struct{ PacketID uint16 }
+type Pubrel struct{ PacketID uint16 }
+type Pubcomp struct{ PacketID uint16 }
+
+// Subscription is a topic filter and the maximum QoS it is subscribed with.
+type Subscription struct {
+	Filter string
+	QoS    byte
+}
+
+type Subscribe struct {
+	PacketID      uint16
+	Subscriptions []Subscription
+}
+
+// Suback holds a return code per filter of the Subscribe, the QoS granted or SubscribeFailure.
+type Suback struct {
+	PacketID    uint16
+	ReturnCodes []byte
+}
+
+type Unsubscribe struct {
+	PacketID uint16
+	Filters  []string
+}
+
+type Unsuback struct{ PacketID uint16 }
+
+type Pingreq struct{}
+type Pingresp struct{}
+type Disconnect struct{}
+
+func (p *Connect) encode() (byte, []byte) {
+	var flags byte
+	if p.CleanSession {
+		flags |= 0x02
+	}
+	if p.Will != nil {
+		flags |= 0x04 | p.Will.QoS<<3
+		if p.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if p.Password != nil {
+		flags |= 0x40
+	}
+	if p.Username != nil {
+		flags |= 0x80
+	}
+	body := appendString(nil, p.ProtocolName)
+	body = append(body, p.ProtocolLevel, flags)
+	body = binary.BigEndian.AppendUint16(body, p.KeepAlive)
+	body = appendString(body, p.ClientID)
+	if p.Will != nil {
+		body = appendString(body, p.Will.Topic)
+		body = appendBytes(body, p.Will.Payload)
+	}
+	if p.Username != nil {
+		body = appendString(body, *p.Username)
+	}
+	if p.Password != nil {
+		body = appendBytes(body, p.Password)
+	}
+	return TypeConnect << 4, body
+}
+
+func (p *Connack) encode() (byte, []byte) {
+	var flags byte
+	if p.SessionPresent {
+		flags = 1
+	}
+	return TypeConnack << 4, []byte{flags, p.ReturnCode}
+}
+
+func (p *Publish) encode() (byte, []byte) {
+	header := byte(TypePublish<<4) | p.QoS<<1
+	if p.Dup {
+		header |= 0x08
+	}
+	if p.Retain {
+		header |= 0x01
+	}
+	body := appendString(nil, p.Topic)
+	if p.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, p.PacketID)
+	}
+	return header, append(body, p.Payload...)
+}
+
+func (p *Puback) encode() (byte, []byte)  { return TypePuback << 4, packetID(p.PacketID) }
+func (p *Pubrec) encode() (byte, []byte)  { return TypePubrec << 4, packetID(p.PacketID) }
+func (p *Pubrel) encode() (byte, []byte)  { return TypePubrel<<4 | 0x02, packetID(p.PacketID) }
+func (p *Pubcomp) encode() (byte, []byte) { return TypePubcomp << 4, packetID(p.PacketID) }
+
+func (p *Subscribe) encode() (byte, []byte) {
+	body := packetID(p.PacketID)
+	for _, s := range p.Subscriptions {
+		body = append(appendString(body, s.Filter), s.QoS)
+	}
+	return TypeSubscribe<<4 | 0x02, body
+}
+
+func (p *Suback) encode() (byte, []byte) {
+	return TypeSuback << 4, append(packetID(p.PacketID), p.ReturnCodes...)
+}
+
+func (p *Unsubscribe) encode() (byte, []byte) {
+	body := packetID(p.PacketID)
+	for _, filter := range p.Filters {
+		body = appendString(body, filter)
+	}
+	return TypeUnsubscribe<<4 | 0x02, body
+}
+
+func (p *Unsuback) encode() (byte, []byte)   { return TypeUnsuback << 4, packetID(p.PacketID) }
+func (p *Pingreq) encode() (byte, []byte)    { return TypePingreq << 4, nil }
+func (p *Pingresp) encode() (byte, []byte)   { return TypePingresp << 4, nil }
+func (p *Disconnect) encode() (byte, []byte) { return TypeDisconnect << 4, nil }
+
+func packetID(id uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, id)
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// Write encodes p to w in a single write, so that writers sharing w only need to serialize calls to Write.
+func Write(w io.Writer, p Packet) error {
+	header, body := p.encode()
+	if len(body) > MaxPacketSize {
+		return fmt.Errorf("MQTT packet of %d bytes exceeds the maximum of %d", len(body), MaxPacketSize)
+	}
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= remainingLengthContinuation
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+// Read decodes the next packet from r. Errors reading r are returned as is, and packets that do not follow the
+// protocol are reported with an error matching ErrMalformed.
+func Read(r *bufio.Reader) (Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == maxRemainingLengthBytes {
+			return nil, malformed("remaining length exceeds %d bytes", maxRemainingLengthBytes)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		length += int(b&^remainingLengthContinuation) * multiplier
+		multiplier *= 128
+		if b&remainingLengthContinuation == 0 {
+			break
+		}
+	}
+	if length > MaxPacketSize {
+		return nil, malformed("packet of %d bytes exceeds the maximum of %d", length, MaxPacketSize)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return decode(header, &decoder{b: body})
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func decode(header byte, d *decoder) (Packet, error) {
+	typ, flags := header>>4, header&0x0f
+	// Only PUBLISH uses the flags, and PUBREL, SUBSCRIBE and UNSUBSCRIBE have them set to 0010.
+	switch typ {
+	case TypePubrel, TypeSubscribe, TypeUnsubscribe:
+		if flags != 0x02 {
+			return nil, malformed("invalid flags %04b for packet type %d", flags, typ)
+		}
+	case TypePublish:
+	default:
+		if flags != 0 {
+			return nil, malformed("invalid flags %04b for packet type %d", flags, typ)
+		}
+	}
+
+	var p Packet
+	switch typ {
+	case TypeConnect:
+		p = decodeConnect(d)
+	case TypeConnack:
+		p = &Connack{SessionPresent: d.byte()&0x01 != 0, ReturnCode: d.byte()}
+	case TypePublish:
+		publish := &Publish{Dup: flags&0x08 != 0, QoS: flags >> 1 & 0x03, Retain: flags&0x01 != 0}
+		if publish.QoS > 2 {
+			return nil, malformed("invalid QoS %d", publish.QoS)
+		}
+		publish.Topic = d.string()
+		if publish.QoS > 0 {
+			publish.PacketID = d.uint16()
+		}
+		publish.Payload = d.rest()
+		p = publish
+	case TypePuback:
+		p = &Puback{PacketID: d.uint16()}
+	case TypePubrec:
+		p = &Pubrec{PacketID: d.uint16()}
+	case TypePubrel:
+		p = &Pubrel{PacketID: d.uint16()}
+	case TypePubcomp:
+		p = &Pubcomp{PacketID: d.uint16()}
+	case TypeSubscribe:
+		subscribe := &Subscribe{PacketID: d.uint16()}
+		for d.err == nil && len(d.b) > 0 {
+			s := Subscription{Filter: d.string(), QoS: d.byte()}
+			if s.QoS > 2 {
+				return nil, malformed("invalid QoS %d", s.QoS)
+			}
+			subscribe.Subscriptions = append(subscribe.Subscriptions, s)
+		}
+		if d.err == nil && len(subscribe.Subscriptions) == 0 {
+			return nil, malformed("SUBSCRIBE without topic filters")
+		}
+		p = subscribe
+	case TypeSuback:
+		p = &Suback{PacketID: d.uint16(), ReturnCodes: d.rest()}
+	case TypeUnsubscribe:
+		unsubscribe := &Unsubscribe{PacketID: d.uint16()}
+		for d.err == nil && len(d.b) > 0 {
+			unsubscribe.Filters = append(unsubscribe.Filters, d.string())
+		}
+		if d.err == nil && len(unsubscribe.Filters) == 0 {
+			return nil, malformed("UNSUBSCRIBE without topic filters")
+		}
+		p = unsubscribe
+	case TypeUnsuback:
+		p = &Unsuback{PacketID: d.uint16()}
+	case TypePingreq:
+		p = &Pingreq{}
+	case TypePingresp:
+		p = &Pingresp{}
+	case TypeDisconnect:
+		p = &Disconnect{}
+	default:
+		return nil, malformed("invalid packet type %d", typ)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.b) > 0 {
+		return nil, malformed("%d unexpected bytes at the end of packet type %d", len(d.b), typ)
+	}
+	return p, nil
+}
+
+func decodeConnect(d *decoder) *Connect {
+	p := &Connect{ProtocolName: d.string(), ProtocolLevel: d.byte()}
+	flags := d.byte()
+	p.KeepAlive = d.uint16()
+	if d.err != nil {
+		return p
+	}
+	if flags&0x01 != 0 {
+		d.fail("reserved CONNECT flag is set")
+	}
+	p.CleanSession = flags&0x02 != 0
+	p.ClientID = d.string()
+	if flags&0x04 != 0 {
+		p.Will = &Publish{QoS: flags >> 3 & 0x03, Retain: flags&0x20 != 0, Topic: d.string(), Payload: d.bytes()}
+	}
+	if flags&0x80 != 0 {
+		username := d.string()
+		p.Username = &username
+	}
+	if flags&0x40 != 0 {
+		p.Password = d.bytes()
+	}
+	return p
+}
+
+// decoder reads the fields of a packet's body, recording the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = malformed(format, args...)
+	}
+	d.b = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) < 1 {
+		d.fail("packet too short")
+		return 0
+	}
+	b := d.b[0]
+	d.b = d.b[1:]
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	if len(d.b) < 2 {
+		d.fail("packet too short")
+		return 0
+	}
+	n := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if len(d.b) < n {
+		d.fail("packet too short")
+		return nil
+	}
+	b := d.b[:n:n]
+	d.b = d.b[n:]
+	return b
+}
+
+// string reads a UTF-8 encoded string, which must not contain the null character.
+func (d *decoder) string() string {
+	b := d.bytes()
+	if !utf8.Valid(b) {
+		d.fail("invalid UTF-8 string")
+		return ""
+	}
+	for _, c := range b {
+		if c == 0 {
+			d.fail("string contains the null character")
+			return ""
+		}
+	}
+	return string(b)
+}
+
+func (d *decoder) rest() []byte {
+	b := d.b
+	d.b = nil
+	return b
+}
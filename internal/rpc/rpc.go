@@ -0,0 +1,136 @@
+// Package rpc defines how the methods of pubsub.API are called over HTTP, between the pubsub serve daemon and the
+// client package.
+//
+// A method is called with POST {PathPrefix}{Method}, whose body is the JSON array of its arguments after the context.
+// The response is a Response holding the JSON of its results before the error, or the error.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// PathPrefix is the path methods are served under.
+const PathPrefix = "/rpc/"
+
+// ProjectHeader carries the project the call is scoped to, set on the context with pubsub.WithProject. Calls without
+// it are scoped to pubsub.DefaultProject.
+const ProjectHeader = "Pubsub-Project"
+
+// Response is the body of every response.
+type Response struct {
+	Results []json.RawMessage `json:"results,omitempty"`
+	Error   *Error            `json:"error,omitempty"`
+}
+
+// Error carries an error returned by a method, along with the typed errors and sentinel errors it matches, so that
+// the client can rebuild an error that errors.Is and errors.As treat as the original.
+type Error struct {
+	Message       string                     `json:"message"`
+	Reasons       []string                   `json:"reasons,omitempty"`
+	NotFound      *pubsub.NotFoundError      `json:"notFound,omitempty"`
+	AlreadyExists *pubsub.AlreadyExistsError `json:"alreadyExists,omitempty"`
+	Config        []*pubsub.ConfigError      `json:"config,omitempty"`
+	Limit         *pubsub.LimitError         `json:"limit,omitempty"`
+	Validation    *pubsub.ValidationError    `json:"validation,omitempty"`
+	Compatibility *pubsub.CompatibilityError `json:"compatibility,omitempty"`
+}
+
+// sentinels names the sentinel errors that survive a call.
+var sentinels = []struct {
+	reason string
+	err    error
+}{
+	{"NOT_FOUND", pubsub.ErrNotFound},
+	{"ALREADY_EXISTS", pubsub.ErrAlreadyExists},
+	{"INVALID_CONFIG", pubsub.ErrInvalidConfig},
+	{"LIMIT_EXCEEDED", pubsub.ErrLimitExceeded},
+	{"SCHEMA_VALIDATION", pubsub.ErrSchemaValidation},
+	{"INCOMPATIBLE_SCHEMA", pubsub.ErrIncompatibleSchema},
+	{"FORWARDING_CYCLE", pubsub.ErrForwardingCycle},
+	{"DETACHED", pubsub.ErrDetached},
+	{"SCHEMA_IN_USE", pubsub.ErrSchemaInUse},
+	{"CANCELED", context.Canceled},
+	{"DEADLINE_EXCEEDED", context.DeadlineExceeded},
+}
+
+// NewError describes err for the client.
+func NewError(err error) *Error {
+	e := &Error{Message: err.Error()}
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			e.Reasons = append(e.Reasons, s.reason)
+		}
+	}
+	errors.As(err, &e.NotFound)
+	errors.As(err, &e.AlreadyExists)
+	errors.As(err, &e.Limit)
+	errors.As(err, &e.Validation)
+	errors.As(err, &e.Compatibility)
+	e.Config = configErrors(err)
+	return e
+}
+
+// configErrors returns every *pubsub.ConfigError in the tree of err, as validation joins one per invalid field.
+func configErrors(err error) []*pubsub.ConfigError {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []*pubsub.ConfigError
+		for _, err := range joined.Unwrap() {
+			errs = append(errs, configErrors(err)...)
+		}
+		return errs
+	}
+	var configErr *pubsub.ConfigError
+	if errors.As(err, &configErr) {
+		return []*pubsub.ConfigError{configErr}
+	}
+	return nil
+}
+
+// Err rebuilds the error the method returned. Its message is the original message, and it wraps the typed errors
+// and sentinel errors the original matched.
+func (e *Error) Err() error {
+	var wrapped []error
+	if e.NotFound != nil {
+		wrapped = append(wrapped, e.NotFound)
+	}
+	if e.AlreadyExists != nil {
+		wrapped = append(wrapped, e.AlreadyExists)
+	}
+	for _, err := range e.Config {
+		wrapped = append(wrapped, err)
+	}
+	if e.Limit != nil {
+		wrapped = append(wrapped, e.Limit)
+	}
+	if e.Validation != nil {
+		wrapped = append(wrapped, e.Validation)
+	}
+	if e.Compatibility != nil {
+		wrapped = append(wrapped, e.Compatibility)
+	}
+	for _, reason := range e.Reasons {
+		for _, s := range sentinels {
+			if s.reason == reason {
+				wrapped = append(wrapped, s.err)
+			}
+		}
+	}
+	return &remoteError{message: e.Message, wrapped: wrapped}
+}
+
+type remoteError struct {
+	message string
+	wrapped []error
+}
+
+func (e *remoteError) Error() string {
+	return e.message
+}
+
+func (e *remoteError) Unwrap() []error {
+	return e.wrapped
+}
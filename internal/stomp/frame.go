@@ -0,0 +1,204 @@
+// Package stomp encodes and decodes the frames of STOMP 1.2, for the STOMP listener of the pubsub serve daemon and its
+// tests.
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Version is the protocol version spoken, as negotiated by the accept-version and version headers.
+const Version = "1.2"
+
+// MaxFrameSize bounds the frames read, as Pub/Sub messages are at most 10 MB.
+const MaxFrameSize = 16 << 20
+
+// ErrMalformed is matched by the errors of frames that do not follow the protocol.
+var ErrMalformed = errors.New("malformed STOMP frame")
+
+func malformed(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+}
+
+// Header is a frame header. Frames keep their headers in order, and the first of repeated headers is the one that
+// counts.
+type Header struct {
+	Key, Value string
+}
+
+// Frame is a STOMP frame, or a heart-beat when Command is empty.
+type Frame struct {
+	Command string
+	Headers []Header
+	Body    []byte
+}
+
+// Get returns the value of a header, or "" when the frame does not have it.
+func (f *Frame) Get(key string) string {
+	value, _ := f.Lookup(key)
+	return value
+}
+
+// Lookup returns the value of a header and whether the frame has it.
+func (f *Frame) Lookup(key string) (string, bool) {
+	for _, h := range f.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Add appends a header.
+func (f *Frame) Add(key, value string) {
+	f.Headers = append(f.Headers, Header{Key: key, Value: value})
+}
+
+// escapes reports whether the headers of a command are escaped, which all but CONNECT and CONNECTED are.
+func escapes(command string) bool {
+	return command != "CONNECT" && command != "CONNECTED"
+}
+
+var (
+	escaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	unescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+// Write encodes f to w in a single write, so that writers sharing w only need to serialize calls to Write. Frames
+// with a body get a content-length header, unless they have one already.
+func Write(w io.Writer, f *Frame) error {
+	if f.Command == "" {
+		_, err := w.Write([]byte{'\n'})
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString(f.Command)
+	b.WriteByte('\n')
+	for _, h := range f.Headers {
+		key, value := h.Key, h.Value
+		if escapes(f.Command) {
+			key, value = escaper.Replace(key), escaper.Replace(value)
+		}
+		b.WriteString(key)
+		b.WriteByte(':')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	if _, ok := f.Lookup("content-length"); !ok && len(f.Body) > 0 {
+		b.WriteString("content-length:" + strconv.Itoa(len(f.Body)) + "\n")
+	}
+	b.WriteByte('\n')
+	b.Write(f.Body)
+	b.WriteByte(0)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Read decodes the next frame from r, returning a frame without a command for a heart-beat. Errors reading r are
+// returned as is, and frames that do not follow the protocol are reported with an error matching ErrMalformed.
+func Read(r *bufio.Reader) (*Frame, error) {
+	size := 0
+	line := func() (string, error) {
+		s, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && size > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if size += len(s); size > MaxFrameSize {
+			return "", malformed("frame exceeds %d bytes", MaxFrameSize)
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r"), nil
+	}
+
+	command, err := line()
+	if err != nil {
+		return nil, err
+	}
+	f := &Frame{Command: command}
+	if command == "" {
+		return f, nil
+	}
+	for {
+		header, err := line()
+		if err != nil {
+			return nil, err
+		}
+		if header == "" {
+			break
+		}
+		key, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, malformed("header %q has no colon", header)
+		}
+		if escapes(command) {
+			if key, err = unescape(key); err != nil {
+				return nil, err
+			}
+			if value, err = unescape(value); err != nil {
+				return nil, err
+			}
+		}
+		f.Add(key, value)
+	}
+
+	if length, ok := f.Lookup("content-length"); ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return nil, malformed("invalid content-length %q", length)
+		}
+		if size+n > MaxFrameSize {
+			return nil, malformed("frame exceeds %d bytes", MaxFrameSize)
+		}
+		f.Body = make([]byte, n+1)
+		if _, err := io.ReadFull(r, f.Body); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if f.Body[n] != 0 {
+			return nil, malformed("body is not followed by a null octet")
+		}
+		f.Body = f.Body[:n]
+		return f, nil
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if b == 0 {
+			return f, nil
+		}
+		if size++; size > MaxFrameSize {
+			return nil, malformed("frame exceeds %d bytes", MaxFrameSize)
+		}
+		f.Body = append(f.Body, b)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// unescape undoes the escaping of header keys and values, where any escape sequence but \\, \r, \n and \c is an
+// error.
+func unescape(s string) (string, error) {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if i+1 == len(s) || !strings.ContainsRune(`\rnc`, rune(s[i+1])) {
+			return "", malformed("invalid escape sequence in header %q", s)
+		}
+		i++
+	}
+	return unescaper.Replace(s), nil
+}
@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+)
+
+// API is the method set of Service. It is implemented by *Service, which opens the database itself, and by the
+// client package, which calls a pubsub serve daemon, so that code written against API works with either.
+type API interface {
+	Init(ctx context.Context) error
+	Close() error
+
+	CreateTopic(ctx context.Context, name string, config *TopicConfig) error
+	GetTopic(ctx context.Context, name string) (*Topic, error)
+	ListTopics(ctx context.Context) ([]*Topic, error)
+	UpdateTopic(ctx context.Context, name string, config *TopicConfig, mask []string) (*Topic, error)
+	DeleteTopic(ctx context.Context, name string) error
+	SetTopicLimits(ctx context.Context, topicName string, limits Limits) error
+	SetTopicCompression(ctx context.Context, topicName string, compression Compression) error
+	SetTopicSchema(ctx context.Context, topicName string, settings *SchemaSettings) error
+	Stats(ctx context.Context, topicName string) (*TopicStats, error)
+
+	CreateSubscription(ctx context.Context, topicName, name string, config *SubscriptionConfig) error
+	CreateSubscriptionFromSnapshot(ctx context.Context, name, snapshotName string, config *SubscriptionConfig) error
+	GetSubscription(ctx context.Context, name string) (*Subscription, error)
+	ListSubscriptions(ctx context.Context, topicName string) ([]*Subscription, error)
+	ListAllSubscriptions(ctx context.Context) ([]*Subscription, error)
+	UpdateSubscription(ctx context.Context, name string, config *SubscriptionConfig, mask []string) (*Subscription, error)
+	DeleteSubscription(ctx context.Context, name string) error
+	DetachSubscription(ctx context.Context, name string) error
+
+	Publish(ctx context.Context, topicName string, content string, attributes map[string]string) (int64, error)
+	PublishMessage(ctx context.Context, topicName string, content string, attributes map[string]string) error
+	GetMessages(ctx context.Context, subscriptionName string) ([]*Message, error)
+	Pull(ctx context.Context, subscriptionName string, max int, ackDeadline time.Time) ([]*Message, error)
+	PullMessages(ctx context.Context, subscriptionName string, ackDeadline time.Time) ([]*Message, error)
+	AcknowledgeMessage(ctx context.Context, subscriptionName string, messageID int) error
+	ModifyAckDeadline(ctx context.Context, subscriptionName string, messageID int, ackDeadline time.Time) error
+	DecodeMessage(ctx context.Context, message *Message) (string, error)
+
+	Seek(ctx context.Context, subscriptionName string, t time.Time) (*SeekResult, error)
+	SeekToSnapshot(ctx context.Context, subscriptionName, snapshotName string) (*SeekResult, error)
+	Backfill(ctx context.Context, subscriptionName string, since time.Time) (int, error)
+	Purge(ctx context.Context, subscriptionName string, opts PurgeOptions) (int, error)
+	PurgeTopic(ctx context.Context, topicName string, opts PurgeOptions) (int, error)
+	CreateSnapshot(ctx context.Context, name, subscriptionName string, lifetime time.Duration) (*Snapshot, error)
+	GetSnapshot(ctx context.Context, name string) (*Snapshot, error)
+	ListSnapshots(ctx context.Context) ([]*Snapshot, error)
+	DeleteSnapshot(ctx context.Context, name string) error
+
+	CreateSchema(ctx context.Context, schema *Schema) error
+	CommitSchema(ctx context.Context, name, definition, messageType string) (*Schema, error)
+	GetSchema(ctx context.Context, name string) (*Schema, error)
+	GetSchemaRevision(ctx context.Context, name string, revision int) (*Schema, error)
+	ListSchemaRevisions(ctx context.Context, name string) ([]*Schema, error)
+	ListSchemas(ctx context.Context) ([]*Schema, error)
+	DeleteSchema(ctx context.Context, name string) error
+
+	GC(ctx context.Context, now time.Time) (int, error)
+	ExpireLeases(ctx context.Context, now time.Time) (int, error)
+}
+
+var _ API = (*Service)(nil)
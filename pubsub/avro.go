@@ -0,0 +1,374 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroSchema validates payloads against an Avro schema. JSON payloads use the Avro JSON encoding, in which union
+// values are wrapped in an object keyed by their branch type.
+type avroSchema struct {
+	codec *goavro.Codec
+}
+
+func compileAvroSchema(definition string) (*avroSchema, error) {
+	codec, err := goavro.NewCodec(definition)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %v", err)
+	}
+	return &avroSchema{codec: codec}, nil
+}
+
+func (s *avroSchema) decode(content string, encoding Encoding) (any, error) {
+	var (
+		native    any
+		remaining []byte
+		err       error
+	)
+	if encoding == EncodingBinary {
+		native, remaining, err = s.codec.NativeFromBinary([]byte(content))
+	} else {
+		native, remaining, err = s.codec.NativeFromTextual([]byte(content))
+	}
+	if err != nil {
+		return nil, &ValidationError{Reason: err.Error()}
+	}
+	if encoding == EncodingBinary && len(remaining) > 0 {
+		return nil, &ValidationError{Reason: fmt.Sprintf("%d unexpected trailing bytes", len(remaining))}
+	}
+	return native, nil
+}
+
+func (s *avroSchema) Validate(content string, encoding Encoding) error {
+	_, err := s.decode(content, encoding)
+	return err
+}
+
+func (s *avroSchema) JSON(content string, encoding Encoding) (string, error) {
+	native, err := s.decode(content, encoding)
+	if err != nil {
+		return "", err
+	}
+	b, err := s.codec.TextualFromNative(nil, native)
+	return string(b), err
+}
+
+// avroType is a parsed Avro schema, used to apply the Avro schema resolution rules when comparing revisions.
+type avroType struct {
+	kind           string // A primitive type name, or record, enum, array, map, fixed or union
+	name           string // Full name of named types
+	aliases        []string
+	fields         []avroField
+	symbols        []string
+	hasEnumDefault bool
+	items, values  *avroType
+	size           int
+	branches       []*avroType
+}
+
+type avroField struct {
+	name       string
+	aliases    []string
+	typ        *avroType
+	hasDefault bool
+}
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true, "float": true, "double": true, "bytes": true, "string": true,
+}
+
+func parseAvroType(definition string) (*avroType, error) {
+	var v any
+	if err := json.Unmarshal([]byte(definition), &v); err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %v", err)
+	}
+	return (&avroParser{named: map[string]*avroType{}}).parse(v, "")
+}
+
+type avroParser struct {
+	named map[string]*avroType
+}
+
+func (p *avroParser) fullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func (p *avroParser) parse(v any, namespace string) (*avroType, error) {
+	switch v := v.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroType{kind: v}, nil
+		}
+		if t, ok := p.named[p.fullName(v, namespace)]; ok {
+			return t, nil
+		}
+		if t, ok := p.named[v]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("invalid Avro schema: unknown type %q", v)
+	case []any:
+		union := &avroType{kind: "union"}
+		for _, branch := range v {
+			t, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			union.branches = append(union.branches, t)
+		}
+		return union, nil
+	case map[string]any:
+		kind, _ := v["type"].(string)
+		switch kind {
+		case "record", "error", "enum", "fixed":
+			name, _ := v["name"].(string)
+			if ns, ok := v["namespace"].(string); ok {
+				namespace = ns
+			}
+			t := &avroType{kind: kind, name: p.fullName(name, namespace)}
+			if kind == "error" {
+				t.kind = "record"
+			}
+			if i := strings.LastIndex(t.name, "."); i >= 0 {
+				namespace = t.name[:i]
+			}
+			t.aliases = stringList(v["aliases"])
+			p.named[t.name] = t
+
+			switch t.kind {
+			case "record":
+				fields, _ := v["fields"].([]any)
+				for _, raw := range fields {
+					field, _ := raw.(map[string]any)
+					ft, err := p.parse(field["type"], namespace)
+					if err != nil {
+						return nil, err
+					}
+					_, hasDefault := field["default"]
+					name, _ := field["name"].(string)
+					t.fields = append(t.fields, avroField{name: name, aliases: stringList(field["aliases"]), typ: ft, hasDefault: hasDefault})
+				}
+			case "enum":
+				t.symbols = stringList(v["symbols"])
+				_, t.hasEnumDefault = v["default"]
+			case "fixed":
+				size, _ := v["size"].(float64)
+				t.size = int(size)
+			}
+			return t, nil
+		case "array":
+			items, err := p.parse(v["items"], namespace)
+			return &avroType{kind: "array", items: items}, err
+		case "map":
+			values, err := p.parse(v["values"], namespace)
+			return &avroType{kind: "map", values: values}, err
+		default:
+			// Either a primitive with attributes such as a logical type, or a nested type definition.
+			return p.parse(v["type"], namespace)
+		}
+	}
+	return nil, fmt.Errorf("invalid Avro schema: unexpected %T", v)
+}
+
+func stringList(v any) []string {
+	list, _ := v.([]any)
+	var out []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (t *avroType) String() string {
+	switch {
+	case t.name != "":
+		return t.name
+	case t.kind == "array":
+		return "array<" + t.items.String() + ">"
+	case t.kind == "map":
+		return "map<" + t.values.String() + ">"
+	case t.kind == "union":
+		var names []string
+		for _, branch := range t.branches {
+			names = append(names, branch.String())
+		}
+		return "[" + strings.Join(names, ", ") + "]"
+	}
+	return t.kind
+}
+
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// diffAvro applies the Avro schema resolution rules in both directions: the newer revision reading data written with
+// the older one for backward compatibility, and the reverse for forward compatibility.
+func (d *differ) diffAvro(olderDefinition, newerDefinition string) error {
+	older, err := parseAvroType(olderDefinition)
+	if err != nil {
+		return err
+	}
+	newer, err := parseAvroType(newerDefinition)
+	if err != nil {
+		return err
+	}
+	(&avroDiff{differ: d, backward: true, visited: map[[2]*avroType]bool{}}).read(newer, older, "")
+	(&avroDiff{differ: d, backward: false, visited: map[[2]*avroType]bool{}}).read(older, newer, "")
+	return nil
+}
+
+type avroDiff struct {
+	*differ
+	backward bool // Whether the reader is the newer revision
+	visited  map[[2]*avroType]bool
+}
+
+// pick returns the description matching the direction, both phrased from the older revision to the newer one.
+func (d *avroDiff) pick(backward, forward string) string {
+	if d.backward {
+		return backward
+	}
+	return forward
+}
+
+func (d *avroDiff) report(path, description string) {
+	d.differ.report(path, description, d.backward, !d.backward)
+}
+
+// typeChanged reports an unresolvable change of type, naming the older type first.
+func (d *avroDiff) typeChanged(reader, writer *avroType, path string) {
+	older, newer := writer, reader
+	if !d.backward {
+		older, newer = reader, writer
+	}
+	d.report(path, fmt.Sprintf("type changed from %s to %s", older, newer))
+}
+
+// matches reports whether reader can read writer without descending into records, enums or containers.
+func avroMatches(reader, writer *avroType) bool {
+	if reader.kind != writer.kind {
+		switch writer.kind {
+		case "int":
+			return reader.kind == "long" || reader.kind == "float" || reader.kind == "double"
+		case "long":
+			return reader.kind == "float" || reader.kind == "double"
+		case "float":
+			return reader.kind == "double"
+		case "string":
+			return reader.kind == "bytes"
+		case "bytes":
+			return reader.kind == "string"
+		}
+		return false
+	}
+	switch reader.kind {
+	case "record", "enum", "fixed":
+		if shortName(reader.name) == shortName(writer.name) {
+			return true
+		}
+		for _, alias := range reader.aliases {
+			if shortName(alias) == shortName(writer.name) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (d *avroDiff) read(reader, writer *avroType, path string) {
+	if d.visited[[2]*avroType{reader, writer}] {
+		return
+	}
+	d.visited[[2]*avroType{reader, writer}] = true
+
+	if writer.kind == "union" {
+		for _, branch := range writer.branches {
+			if reader.kind != "union" {
+				if !avroMatches(reader, branch) {
+					d.report(path, d.pick(fmt.Sprintf("union branch %s removed", branch), fmt.Sprintf("union branch %s added", branch)))
+					continue
+				}
+				d.read(reader, branch, path)
+				continue
+			}
+			d.read(reader, branch, path)
+		}
+		return
+	}
+	if reader.kind == "union" {
+		for _, branch := range reader.branches {
+			if avroMatches(branch, writer) {
+				d.read(branch, writer, path)
+				return
+			}
+		}
+		d.report(path, d.pick(fmt.Sprintf("union branch %s removed", writer), fmt.Sprintf("union branch %s added", writer)))
+		return
+	}
+	if !avroMatches(reader, writer) {
+		if reader.kind == writer.kind && reader.name != "" {
+			older, newer := writer, reader
+			if !d.backward {
+				older, newer = reader, writer
+			}
+			d.report(path, fmt.Sprintf("%s renamed from %s to %s", reader.kind, older, newer))
+			return
+		}
+		d.typeChanged(reader, writer, path)
+		return
+	}
+
+	switch reader.kind {
+	case "record":
+		for _, field := range reader.fields {
+			child := path + "/" + field.name
+			wf, ok := writer.field(field)
+			if !ok {
+				if !field.hasDefault {
+					d.report(child, d.pick("field added without a default", "field removed, older revision has no default for it"))
+				}
+				continue
+			}
+			d.read(field.typ, wf.typ, child)
+		}
+	case "enum":
+		if reader.hasEnumDefault {
+			return
+		}
+		for _, symbol := range writer.symbols {
+			if !containsString(reader.symbols, symbol) {
+				d.report(path, d.pick(fmt.Sprintf("enum symbol %s removed", symbol), fmt.Sprintf("enum symbol %s added", symbol)))
+			}
+		}
+	case "fixed":
+		if reader.size != writer.size {
+			older, newer := writer.size, reader.size
+			if !d.backward {
+				older, newer = reader.size, writer.size
+			}
+			d.report(path, fmt.Sprintf("fixed size changed from %d to %d", older, newer))
+		}
+	case "array":
+		d.read(reader.items, writer.items, path+"/*")
+	case "map":
+		d.read(reader.values, writer.values, path+"/*")
+	}
+}
+
+// field finds the writer's field matching a reader field by name or by one of the reader field's aliases.
+func (t *avroType) field(reader avroField) (avroField, bool) {
+	for _, f := range t.fields {
+		if f.name == reader.name || containsString(reader.aliases, f.name) {
+			return f, true
+		}
+	}
+	return avroField{}, false
+}
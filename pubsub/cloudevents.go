@@ -0,0 +1,262 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CloudEvents (https://cloudevents.io) are stored the way the Google Pub/Sub protocol binding of CloudEvents 1.0 maps
+// them to messages: every context attribute and extension is a message attribute prefixed with "ce-", such as
+// "ce-id" and "ce-source", the datacontenttype is the "content-type" attribute and the data is the message content.
+const (
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsContentType is the media type of an event in the structured JSON format.
+	CloudEventsContentType = "application/cloudevents+json"
+	// MessagePublishedType is the type of the events rendered for messages that were not published as CloudEvents,
+	// whose data is the message in the Google Pub/Sub push format, as Eventarc delivers them.
+	MessagePublishedType = "google.cloud.pubsub.topic.v1.messagePublished"
+
+	cloudEventPrefix      = "ce-"
+	cloudEventContentType = "content-type"
+)
+
+// CloudEventsMode selects how CloudEvents are written to HTTP: in binary mode the attributes are ce-* headers and the
+// body is the data, in structured mode the body is the whole event as JSON.
+type CloudEventsMode string
+
+const (
+	CloudEventsBinary     CloudEventsMode = "binary"
+	CloudEventsStructured CloudEventsMode = "structured"
+)
+
+// cloudEventAttributeName matches the names the spec allows for context attributes and extensions.
+var cloudEventAttributeName = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+// validAttributeName reports whether name can be the name of an attribute in CloudEvent.Attributes, which excludes the
+// names the JSON format uses for the datacontenttype and the data.
+func validAttributeName(name string) bool {
+	return cloudEventAttributeName.MatchString(name) && name != "datacontenttype" && name != "data"
+}
+
+// CloudEvent is a CloudEvents 1.0 event.
+type CloudEvent struct {
+	// Attributes holds the context attributes and extensions other than datacontenttype, such as "specversion",
+	// "id", "source", "type" and "time", all as strings.
+	Attributes      map[string]string
+	DataContentType string
+	Data            string
+}
+
+// Validate checks that the event is a valid CloudEvents 1.0 event.
+func (e *CloudEvent) Validate() error {
+	if v := e.Attributes["specversion"]; v != CloudEventsSpecVersion {
+		return fmt.Errorf("unsupported CloudEvents specversion %q, expected %s", v, CloudEventsSpecVersion)
+	}
+	for _, name := range []string{"id", "source", "type"} {
+		if e.Attributes[name] == "" {
+			return fmt.Errorf("CloudEvent has no %s", name)
+		}
+	}
+	for name := range e.Attributes {
+		if !validAttributeName(name) {
+			return fmt.Errorf("invalid CloudEvent attribute name %q", name)
+		}
+	}
+	if t, ok := e.Attributes["time"]; ok {
+		if _, err := time.Parse(time.RFC3339Nano, t); err != nil {
+			return fmt.Errorf("invalid CloudEvent time %q, expected RFC 3339", t)
+		}
+	}
+	return nil
+}
+
+// Message returns the content and attributes of the message storing the event.
+func (e *CloudEvent) Message() (string, map[string]string) {
+	attributes := make(map[string]string, len(e.Attributes)+1)
+	for name, value := range e.Attributes {
+		attributes[cloudEventPrefix+name] = value
+	}
+	if e.DataContentType != "" {
+		attributes[cloudEventContentType] = e.DataContentType
+	}
+	return e.Data, attributes
+}
+
+// ParseCloudEvent reads an event in the structured JSON format and validates it. Attributes given as JSON numbers or
+// booleans are kept in their JSON form.
+func ParseCloudEvent(body []byte) (*CloudEvent, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("invalid CloudEvent JSON: %v", err)
+	}
+	e := &CloudEvent{Attributes: map[string]string{}}
+	for name, raw := range fields {
+		if name == "data" || name == "data_base64" {
+			continue
+		}
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("invalid CloudEvent attribute %q: %v", name, err)
+		}
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			if name == "datacontenttype" {
+				e.DataContentType = v
+			} else {
+				e.Attributes[name] = v
+			}
+		case float64, bool:
+			e.Attributes[name] = string(raw)
+		default:
+			return nil, fmt.Errorf("invalid CloudEvent attribute %q: must be a string, number or boolean", name)
+		}
+	}
+
+	data, hasData := fields["data"]
+	encoded, hasBase64 := fields["data_base64"]
+	switch {
+	case hasData && hasBase64:
+		return nil, fmt.Errorf("CloudEvent has both data and data_base64")
+	case hasBase64:
+		var s string
+		if err := json.Unmarshal(encoded, &s); err != nil {
+			return nil, fmt.Errorf("invalid CloudEvent data_base64: %v", err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CloudEvent data_base64: %v", err)
+		}
+		e.Data = string(decoded)
+	case hasData && !isJSONContentType(e.DataContentType):
+		// Data that is not JSON is carried as a JSON string.
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("CloudEvent data of type %s must be a string", e.DataContentType)
+		}
+		e.Data = s
+	case hasData && string(data) != "null":
+		e.Data = string(data)
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// MarshalJSON writes the event in the structured JSON format. Data is written as JSON when the datacontenttype is JSON
+// and the data is valid JSON, as a string when the datacontenttype is text/* and the data is valid UTF-8, and in
+// data_base64 otherwise.
+func (e *CloudEvent) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]any, len(e.Attributes)+2)
+	for name, value := range e.Attributes {
+		fields[name] = value
+	}
+	if e.DataContentType != "" {
+		fields["datacontenttype"] = e.DataContentType
+	}
+	switch {
+	case e.Data == "":
+	case isJSONContentType(e.DataContentType) && json.Valid([]byte(e.Data)):
+		fields["data"] = json.RawMessage(e.Data)
+	case isTextContentType(e.DataContentType) && utf8.ValidString(e.Data):
+		fields["data"] = e.Data
+	default:
+		fields["data_base64"] = base64.StdEncoding.EncodeToString([]byte(e.Data))
+	}
+	return json.Marshal(fields)
+}
+
+// isJSONContentType reports whether data of the content type is JSON. A missing datacontenttype implies JSON.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// isTextContentType reports whether data of the content type is text.
+func isTextContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.HasPrefix(mediaType, "text/")
+}
+
+// MessageCloudEvent renders a message delivered to a subscription as a CloudEvent. Messages published as CloudEvents
+// are rendered as the event they store, along with their other attributes whose names are valid extension names.
+// Other messages are rendered as a MessagePublishedType event. Either way, a missing id, source, type or time is
+// taken from the message ID, its topic and its publish time.
+func MessageCloudEvent(subscription *Subscription, message *Message) *CloudEvent {
+	id := strconv.FormatInt(message.PublishID, 10)
+	if message.PublishID == 0 {
+		id = strconv.Itoa(message.ID)
+	}
+	e := &CloudEvent{Attributes: map[string]string{}}
+	if _, ok := message.Attributes[cloudEventPrefix+"specversion"]; ok {
+		for key, value := range message.Attributes {
+			name, isEvent := strings.CutPrefix(key, cloudEventPrefix)
+			switch {
+			case key == cloudEventContentType:
+				e.DataContentType = value
+			case isEvent && validAttributeName(name):
+				e.Attributes[name] = value
+			case !isEvent && validAttributeName(key):
+				if _, taken := message.Attributes[cloudEventPrefix+key]; !taken {
+					e.Attributes[key] = value
+				}
+			}
+		}
+		e.Data = message.Content
+	} else {
+		data, _ := json.Marshal(messagePublishedData{
+			Message: messagePublished{
+				Data:        []byte(message.Content),
+				Attributes:  message.Attributes,
+				MessageID:   id,
+				PublishTime: message.PublishedAt,
+			},
+			Subscription: subscription.ResourceName(),
+		})
+		e.Attributes["type"] = MessagePublishedType
+		e.DataContentType = "application/json"
+		e.Data = string(data)
+	}
+
+	e.Attributes["specversion"] = CloudEventsSpecVersion
+	defaults := map[string]string{
+		"id":     id,
+		"source": "//pubsub.googleapis.com/" + subscription.TopicResourceName(),
+		"type":   MessagePublishedType,
+		"time":   message.PublishedAt.UTC().Format(time.RFC3339Nano),
+	}
+	if subscription.Topic == "" {
+		defaults["source"] = "//pubsub.googleapis.com/" + subscription.ResourceName()
+	}
+	for name, value := range defaults {
+		if e.Attributes[name] == "" {
+			e.Attributes[name] = value
+		}
+	}
+	return e
+}
+
+// messagePublishedData is the data of MessagePublishedType events.
+type messagePublishedData struct {
+	Message      messagePublished `json:"message"`
+	Subscription string           `json:"subscription"`
+}
+
+type messagePublished struct {
+	Data        []byte            `json:"data,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	MessageID   string            `json:"messageId"`
+	PublishTime time.Time         `json:"publishTime"`
+}
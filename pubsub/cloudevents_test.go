@@ -0,0 +1,100 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseCloudEvent(t *testing.T) {
+	event, err := ParseCloudEvent([]byte(`{"specversion": "1.0", "id": "1", "source": "/orders", "type": "order.created",
+		"sequence": 7, "data": {"total": 12}}`))
+	ok(t, err, "failed to parse event")
+	equals(t, `{"total": 12}`, event.Data, "JSON data should be kept as JSON")
+	equals(t, "7", event.Attributes["sequence"], "numbers should be kept in their JSON form")
+
+	content, attributes := event.Message()
+	equals(t, `{"total": 12}`, content, "data should be the content")
+	equals(t, "order.created", attributes["ce-type"], "attributes should be prefixed with ce-")
+
+	event, err = ParseCloudEvent([]byte(`{"specversion": "1.0", "id": "2", "source": "/orders", "type": "order.created",
+		"datacontenttype": "application/octet-stream", "data_base64": "AAEC"}`))
+	ok(t, err, "failed to parse event")
+	equals(t, "\x00\x01\x02", event.Data, "data_base64 should be decoded")
+	body, err := json.Marshal(event)
+	ok(t, err, "failed to encode event")
+	again, err := ParseCloudEvent(body)
+	ok(t, err, "failed to parse encoded event")
+	equals(t, event.Data, again.Data, "binary data should survive encoding")
+
+	// Only text is written as a data string; anything else that is not valid JSON goes to data_base64.
+	for _, tt := range []struct {
+		contentType, data, field string
+	}{
+		{"text/plain; charset=utf-8", "hello", "data"},
+		{"application/xml", "<order/>", "data_base64"},
+		{"application/json", "not json", "data_base64"},
+		{"application/json", `{"total": 12}`, "data"},
+	} {
+		body, err := json.Marshal(&CloudEvent{DataContentType: tt.contentType, Data: tt.data})
+		ok(t, err, "failed to encode event")
+		var fields map[string]any
+		ok(t, json.Unmarshal(body, &fields), "failed to decode event")
+		if _, found := fields[tt.field]; !found {
+			t.Errorf("expected %s data %q in %s, got %s", tt.contentType, tt.data, tt.field, body)
+		}
+	}
+
+	for _, body := range []string{
+		`{"specversion": "0.3", "id": "1", "source": "/", "type": "t"}`,
+		`{"specversion": "1.0", "source": "/", "type": "t"}`,
+		`{"specversion": "1.0", "id": "1", "source": "/", "type": "t", "Bad-Name": "x"}`,
+		`{"specversion": "1.0", "id": "1", "source": "/", "type": "t", "time": "yesterday"}`,
+		`{"specversion": "1.0", "id": "1", "source": "/", "type": "t", "datacontenttype": "text/plain", "data": {}}`,
+		`{"specversion": "1.0", "id": "1", "source": "/", "type": "t", "data": 1, "data_base64": "AA=="}`,
+	} {
+		if _, err := ParseCloudEvent([]byte(body)); err == nil {
+			t.Errorf("expected %s to be rejected", body)
+		}
+	}
+}
+
+func TestMessageCloudEvent(t *testing.T) {
+	published := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	subscription := &Subscription{Project: "demo", TopicProject: "demo", Topic: "orders", SubscriberID: "billing"}
+
+	event := MessageCloudEvent(subscription, &Message{
+		ID:          3,
+		PublishID:   42,
+		Content:     "hello",
+		Attributes:  map[string]string{"region": "eu"},
+		PublishedAt: published,
+	})
+	ok(t, event.Validate(), "rendered event should be valid")
+	equals(t, "42", event.Attributes["id"], "id should be the message ID")
+	equals(t, "2024-05-01T12:00:00Z", event.Attributes["time"], "time should be the publish time")
+	equals(t, "//pubsub.googleapis.com/projects/demo/topics/orders", event.Attributes["source"], "source should be the topic")
+	equals(t, MessagePublishedType, event.Attributes["type"], "type doesn't match")
+	var data messagePublishedData
+	ok(t, json.Unmarshal([]byte(event.Data), &data), "data should be JSON")
+	equals(t, "hello", string(data.Message.Data), "data should hold the message")
+	equals(t, "eu", data.Message.Attributes["region"], "data should hold the attributes")
+	equals(t, "projects/demo/subscriptions/billing", data.Subscription, "data should name the subscription")
+
+	// Messages published as CloudEvents are rendered as the event they store.
+	original := &CloudEvent{
+		Attributes:      map[string]string{"specversion": "1.0", "id": "e1", "source": "/orders", "type": "order.created"},
+		DataContentType: "text/plain",
+		Data:            "hello",
+	}
+	content, attributes := original.Message()
+	attributes["region"] = "eu"
+	event = MessageCloudEvent(subscription, &Message{PublishID: 43, Content: content, Attributes: attributes, PublishedAt: published})
+	ok(t, event.Validate(), "rendered event should be valid")
+	equals(t, "e1", event.Attributes["id"], "id should be kept")
+	equals(t, "order.created", event.Attributes["type"], "type should be kept")
+	equals(t, "eu", event.Attributes["region"], "other attributes should become extensions")
+	equals(t, "2024-05-01T12:00:00Z", event.Attributes["time"], "a missing time should be the publish time")
+	equals(t, "text/plain", event.DataContentType, "content type should be kept")
+	equals(t, "hello", event.Data, "data should be the content")
+}
@@ -0,0 +1,130 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrIncompatibleSchema is matched by every *CompatibilityError.
+var ErrIncompatibleSchema = errors.New("incompatible schema revision")
+
+// SchemaChange is a difference between two schema revisions that can make data written with one revision invalid
+// under the other.
+type SchemaChange struct {
+	Path        string // Location of the change within the payload, "/" for the root
+	Description string
+	// Backward is set when data written with the older revision may be invalid under the newer one.
+	Backward bool
+	// Forward is set when data written with the newer revision may be invalid under the older one.
+	Forward bool
+}
+
+func (c SchemaChange) String() string {
+	var breaks []string
+	if c.Backward {
+		breaks = append(breaks, "backward")
+	}
+	if c.Forward {
+		breaks = append(breaks, "forward")
+	}
+	return fmt.Sprintf("%s: %s (breaks %s compatibility)", c.Path, c.Description, strings.Join(breaks, " and "))
+}
+
+// Incompatible returns the changes that violate the compatibility mode.
+func (c Compatibility) Incompatible(changes []SchemaChange) []SchemaChange {
+	var incompatible []SchemaChange
+	for _, change := range changes {
+		backward := change.Backward && (c == CompatibilityBackward || c == CompatibilityFull)
+		forward := change.Forward && (c == CompatibilityForward || c == CompatibilityFull)
+		if backward || forward {
+			incompatible = append(incompatible, change)
+		}
+	}
+	return incompatible
+}
+
+// CompatibilityError is returned when a new schema revision is not compatible with an existing one.
+type CompatibilityError struct {
+	Schema        string
+	Revision      int // The existing revision the new one was checked against
+	Compatibility Compatibility
+	Changes       []SchemaChange
+}
+
+func (e *CompatibilityError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "new revision of schema %q is not %s compatible with revision %d:", e.Schema,
+		strings.ToLower(string(e.Compatibility)), e.Revision)
+	for _, change := range e.Changes {
+		fmt.Fprintf(&b, "\n  %s", change)
+	}
+	return b.String()
+}
+
+func (e *CompatibilityError) Is(target error) bool {
+	return target == ErrIncompatibleSchema
+}
+
+// DiffSchemas lists the changes between two revisions that affect compatibility in either direction.
+func DiffSchemas(older, newer *Schema) ([]SchemaChange, error) {
+	if older.Type != newer.Type {
+		return []SchemaChange{{Path: "/", Description: fmt.Sprintf("schema type changed from %s to %s", older.Type, newer.Type),
+			Backward: true, Forward: true}}, nil
+	}
+	o, err := compileSchema(older)
+	if err != nil {
+		return nil, err
+	}
+	n, err := compileSchema(newer)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &differ{}
+	switch o := o.(type) {
+	case *jsonSchema:
+		d.diffJSON(o, n.(*jsonSchema))
+	case *avroSchema:
+		if err := d.diffAvro(older.Definition, newer.Definition); err != nil {
+			return nil, err
+		}
+	case *protoSchema:
+		d.diffProto(o.message, n.(*protoSchema).message, "", map[string]bool{})
+	}
+	return d.changes(), nil
+}
+
+// differ accumulates changes, merging the directions of changes reported more than once for the same location.
+type differ struct {
+	found map[string]*SchemaChange
+	order []string
+}
+
+func (d *differ) report(path, description string, backward, forward bool) {
+	if path == "" {
+		path = "/"
+	}
+	key := path + "\x00" + description
+	if d.found == nil {
+		d.found = map[string]*SchemaChange{}
+	}
+	change, ok := d.found[key]
+	if !ok {
+		change = &SchemaChange{Path: path, Description: description}
+		d.found[key] = change
+		d.order = append(d.order, key)
+	}
+	change.Backward = change.Backward || backward
+	change.Forward = change.Forward || forward
+}
+
+func (d *differ) changes() []SchemaChange {
+	changes := make([]SchemaChange, 0, len(d.order))
+	for _, key := range d.order {
+		changes = append(changes, *d.found[key])
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	tests := []struct {
+		name     string
+		typ      SchemaType
+		older    string
+		newer    string
+		path     string
+		backward bool
+		forward  bool
+	}{
+		{"json new required property", SchemaTypeJSON,
+			`{"type": "object", "properties": {"a": {"type": "string"}}}`,
+			`{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}`,
+			"/", true, false},
+		{"json widened type", SchemaTypeJSON,
+			`{"type": "object", "properties": {"a": {"type": "integer"}}}`,
+			`{"type": "object", "properties": {"a": {"type": "number"}}}`,
+			"/a", false, true},
+		{"json closed model property added", SchemaTypeJSON,
+			`{"type": "object", "additionalProperties": false}`,
+			`{"type": "object", "additionalProperties": false, "properties": {"b": {"type": "string"}}}`,
+			"/b", false, true},
+		{"json enum value removed", SchemaTypeJSON,
+			`{"enum": ["a", "b"]}`,
+			`{"enum": ["a"]}`,
+			"/", true, false},
+		{"avro field without default", SchemaTypeAvro,
+			`{"type": "record", "name": "E", "fields": [{"name": "a", "type": "string"}]}`,
+			`{"type": "record", "name": "E", "fields": [{"name": "a", "type": "string"}, {"name": "b", "type": "int"}]}`,
+			"/b", true, false},
+		{"avro promotion", SchemaTypeAvro,
+			`{"type": "record", "name": "E", "fields": [{"name": "a", "type": "int"}]}`,
+			`{"type": "record", "name": "E", "fields": [{"name": "a", "type": "long"}]}`,
+			"/a", false, true},
+		{"avro union branch added", SchemaTypeAvro,
+			`{"type": "record", "name": "E", "fields": [{"name": "a", "type": ["null", "string"]}]}`,
+			`{"type": "record", "name": "E", "fields": [{"name": "a", "type": ["null", "string", "int"]}]}`,
+			"/a", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := DiffSchemas(&Schema{Type: tt.typ, Definition: tt.older}, &Schema{Type: tt.typ, Definition: tt.newer})
+			ok(t, err, "failed to diff schemas")
+			equals(t, 1, len(changes), "change count doesn't match expectation")
+			equals(t, tt.path, changes[0].Path, "change path doesn't match expectation")
+			equals(t, tt.backward, changes[0].Backward, "backward flag doesn't match expectation")
+			equals(t, tt.forward, changes[0].Forward, "forward flag doesn't match expectation")
+		})
+	}
+
+	t.Run("identical", func(t *testing.T) {
+		changes, err := DiffSchemas(&Schema{Type: SchemaTypeJSON, Definition: orderSchema}, &Schema{Type: SchemaTypeJSON, Definition: orderSchema})
+		ok(t, err, "failed to diff schemas")
+		equals(t, 0, len(changes), "identical schemas should have no changes")
+	})
+
+	t.Run("protobuf type change", func(t *testing.T) {
+		var set descriptorpb.FileDescriptorSet
+		ok(t, proto.Unmarshal([]byte(userDescriptorSet(t)), &set), "failed to unmarshal descriptor set")
+		set.File[0].MessageType[0].Field[1].Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+		b, err := proto.Marshal(&set)
+		ok(t, err, "failed to marshal descriptor set")
+
+		changes, err := DiffSchemas(&Schema{Type: SchemaTypeProtocolBuffer, Definition: userDescriptorSet(t)},
+			&Schema{Type: SchemaTypeProtocolBuffer, Definition: string(b)})
+		ok(t, err, "failed to diff schemas")
+		equals(t, 1, len(changes), "change count doesn't match expectation")
+		equals(t, "/age", changes[0].Path, "change path doesn't match expectation")
+		equals(t, true, changes[0].Backward && changes[0].Forward, "type change should break both directions")
+	})
+}
+
+func TestSchemaRevisions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	v1 := `{"type": "object", "properties": {"id": {"type": "integer"}}, "required": ["id"], "additionalProperties": false}`
+	v2 := `{"type": "object", "properties": {"id": {"type": "integer"}, "note": {"type": "string"}}, "required": ["id"], "additionalProperties": false}`
+	breaking := `{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`
+
+	ok(t, s.CreateSchema(ctx, &Schema{Name: "records", Type: SchemaTypeJSON, Definition: v1}), "failed to create schema")
+	schema, err := s.CommitSchema(ctx, "records", v2, "")
+	ok(t, err, "failed to commit compatible revision")
+	equals(t, 2, schema.Revision, "revision doesn't match expectation")
+	equals(t, CompatibilityBackward, schema.Compatibility, "compatibility doesn't match expectation")
+
+	_, err = s.CommitSchema(ctx, "records", breaking, "")
+	if !errors.Is(err, ErrIncompatibleSchema) {
+		t.Fatalf("expected ErrIncompatibleSchema, got %v", err)
+	}
+
+	revisions, err := s.ListSchemaRevisions(ctx, "records")
+	ok(t, err, "failed to list revisions")
+	equals(t, 2, len(revisions), "revision count doesn't match expectation")
+	equals(t, 2, revisions[0].Revision, "revisions should be newest first")
+
+	ok(t, s.CreateTopic(ctx, "records", nil), "failed to create topic")
+	topic, err := s.GetTopic(ctx, "records")
+	ok(t, err, "failed to get topic")
+	ok(t, s.SetTopicSchema(ctx, topic.Name, &SchemaSettings{Schema: "records"}), "failed to bind schema")
+	ok(t, s.PublishMessage(ctx, topic.Name, `{"id": 1, "note": "x"}`, nil), "message valid under revision 2 was rejected")
+
+	ok(t, s.SetTopicSchema(ctx, topic.Name, &SchemaSettings{Schema: "records", LastRevision: 1}), "failed to bind schema")
+	ok(t, s.PublishMessage(ctx, topic.Name, `{"id": 1}`, nil), "message valid under revision 1 was rejected")
+	if err := s.PublishMessage(ctx, topic.Name, `{"id": 1, "note": "x"}`, nil); !errors.Is(err, ErrSchemaValidation) {
+		t.Fatalf("expected revision 1 to reject the note property, got %v", err)
+	}
+
+	ok(t, s.SetTopicSchema(ctx, topic.Name, &SchemaSettings{Schema: "records", FirstRevision: 2}), "failed to bind schema")
+	err = s.PublishMessage(ctx, topic.Name, `{"id": 1, "note": 2}`, nil)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	equals(t, 2, validationErr.Revision, "revision doesn't match expectation")
+	equals(t, "/note", validationErr.Path, "path doesn't match expectation")
+
+	err = s.SetTopicSchema(ctx, topic.Name, &SchemaSettings{Schema: "records", FirstRevision: 3})
+	if err == nil {
+		t.Fatal("expected a range without revisions to be rejected")
+	}
+}
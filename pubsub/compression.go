@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compression is the algorithm used to store message payloads of a topic.
+type Compression string
+
+const (
+	CompressionNone  Compression = ""
+	CompressionGzip  Compression = "gzip"
+	CompressionFlate Compression = "flate"
+)
+
+// ParseCompression accepts a compression algorithm in any case. "none" and the empty string disable compression.
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(strings.ToLower(s)); c {
+	case "none", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip, CompressionFlate:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compression %q", s)
+}
+
+// compress returns the value to store in the content column. Uncompressed payloads stay text so the database remains
+// readable with the sqlite3 shell.
+func compress(c Compression, content string) (any, int, error) {
+	if c == CompressionNone {
+		return content, len(content), nil
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch c {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionFlate:
+		var err error
+		if w, err = flate.NewWriter(&buf, flate.DefaultCompression); err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, fmt.Errorf("unknown compression %q", c)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		return nil, 0, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), buf.Len(), nil
+}
+
+func decompress(c Compression, stored []byte) (string, error) {
+	var r io.ReadCloser
+	switch c {
+	case CompressionNone:
+		return string(stored), nil
+	case CompressionGzip:
+		var err error
+		if r, err = gzip.NewReader(bytes.NewReader(stored)); err != nil {
+			return "", fmt.Errorf("failed to decompress message: %v", err)
+		}
+	case CompressionFlate:
+		r = flate.NewReader(bytes.NewReader(stored))
+	default:
+		return "", fmt.Errorf("unknown compression %q", c)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress message: %v", err)
+	}
+	return string(b), nil
+}
+
+// SetTopicCompression changes how payloads published to a topic from now on are stored. Messages that were already
+// published keep the compression they were stored with.
+func (s *Service) SetTopicCompression(ctx context.Context, topicName string, compression Compression) error {
+	compression, err := ParseCompression(string(compression))
+	if err != nil {
+		return err
+	}
+	topic, err := s.GetTopic(ctx, topicName)
+	if err != nil {
+		return err
+	}
+	return setTopicCompression(ctx, s.db, topic, compression)
+}
+
+func setTopicCompression(ctx context.Context, q querier, topic *Topic, compression Compression) error {
+	_, err := q.ExecContext(ctx, "UPDATE Topics SET compression = ? WHERE id = ?", compression, topic.ID)
+	return err
+}
+
+// TopicStats summarises the messages stored for a topic across all of its subscriptions.
+type TopicStats struct {
+	TopicID        int
+	Messages       int
+	Unacknowledged int
+	// Size is the total size of the payloads as published, and StoredSize their total size after compression.
+	Size       int64
+	StoredSize int64
+}
+
+// Stats returns storage statistics for a topic.
+func (s *Service) Stats(ctx context.Context, topicName string) (*TopicStats, error) {
+	topic, err := s.GetTopic(ctx, topicName)
+	if err != nil {
+		return nil, err
+	}
+	stats := &TopicStats{TopicID: topic.ID}
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(acknowledged = 0), 0),
+        COALESCE(SUM(COALESCE(size, length(CAST(content AS BLOB)))), 0),
+        COALESCE(SUM(COALESCE(stored_size, length(CAST(content AS BLOB)))), 0)
+        FROM Messages WHERE topic_id = ?`, topic.ID).
+		Scan(&stats.Messages, &stats.Unacknowledged, &stats.Size, &stats.StoredSize)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
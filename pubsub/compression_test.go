@@ -0,0 +1,47 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	payload := strings.Repeat(`{"kind": "repetitive", "value": 42}`, 100)
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionFlate} {
+		t.Run(string(compression), func(t *testing.T) {
+			name := "compressed-" + string(compression)
+			ok(t, s.CreateTopic(ctx, name, nil), "failed to create topic")
+			topic, err := s.GetTopic(ctx, name)
+			ok(t, err, "failed to get topic")
+			ok(t, s.SetTopicCompression(ctx, topic.Name, compression), "failed to set compression")
+			for _, subscriber := range []string{"a", "b"} {
+				ok(t, s.CreateSubscription(ctx, topic.Name, name+subscriber, nil), "failed to create subscription")
+			}
+			subscription, err := s.GetSubscription(ctx, name+"a")
+			ok(t, err, "failed to get subscription")
+
+			ok(t, s.PublishMessage(ctx, topic.Name, payload, nil), "failed to publish message")
+
+			messages, err := s.GetMessages(ctx, subscription.SubscriberID)
+			ok(t, err, "failed to get messages")
+			equals(t, payload, messages[0].Content, "stored message doesn't match payload")
+			messages, err = s.PullMessages(ctx, subscription.SubscriberID, messages[0].AckDeadline.Time)
+			ok(t, err, "failed to pull messages")
+			equals(t, payload, messages[0].Content, "pulled message doesn't match payload")
+
+			stats, err := s.Stats(ctx, topic.Name)
+			ok(t, err, "failed to get stats")
+			equals(t, 2, stats.Messages, "message count doesn't match expectation")
+			equals(t, int64(2*len(payload)), stats.Size, "uncompressed size doesn't match expectation")
+			if compression == CompressionNone {
+				equals(t, stats.Size, stats.StoredSize, "uncompressed payloads should be stored as is")
+			} else if stats.StoredSize >= stats.Size/10 {
+				t.Fatalf("expected repetitive payload to compress well, stored %d of %d bytes", stats.StoredSize, stats.Size)
+			}
+		})
+	}
+}
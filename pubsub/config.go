@@ -0,0 +1,254 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidConfig is matched by every *ConfigError.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ConfigError reports a topic or subscription configuration field with an invalid value.
+type ConfigError struct {
+	Field  string // Field name as written in configuration files, e.g. "retryPolicy.minimumBackoff"
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// Defaults and bounds match Google Pub/Sub.
+const (
+	DefaultAckDeadline    = 10 * time.Second
+	MinAckDeadline        = 10 * time.Second
+	MaxAckDeadline        = 10 * time.Minute
+	DefaultRetention      = 7 * 24 * time.Hour
+	MinRetention          = 10 * time.Minute
+	MaxTopicRetention     = 31 * 24 * time.Hour
+	DefaultMinimumBackoff = 10 * time.Second
+	MaxBackoff            = 10 * time.Minute
+	MinExpirationTTL      = 24 * time.Hour
+	maxFilterBytes        = 256
+)
+
+// TopicConfig holds the settings of a topic that can be given in a configuration file.
+type TopicConfig struct {
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// MessageRetentionDuration is how long the topic retains published messages. Zero disables retention.
+	MessageRetentionDuration time.Duration `json:"messageRetentionDuration,omitempty" yaml:"messageRetentionDuration,omitempty"`
+
+	// Limits, Compression and SchemaSettings are applied by CreateTopic in the same transaction as it creates the
+	// topic, so that a topic is never left half configured. They are not read from configuration files, and are
+	// reported by the Topic fields of the same names and changed with SetTopicLimits, SetTopicCompression and
+	// SetTopicSchema afterwards.
+	Limits         *Limits         `json:"limits,omitempty" yaml:"-"`
+	Compression    Compression     `json:"compression,omitempty" yaml:"-"`
+	SchemaSettings *SchemaSettings `json:"schemaSettings,omitempty" yaml:"-"`
+}
+
+// SubscriptionConfig holds the settings of a subscription that can be given in a configuration file. Zero values
+// select the defaults.
+type SubscriptionConfig struct {
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// AckDeadline is the lease given to pulled messages when the caller does not choose one.
+	AckDeadline time.Duration `json:"ackDeadline,omitempty" yaml:"ackDeadline,omitempty"`
+	// MessageRetentionDuration is how long unacknowledged messages, and acknowledged ones when RetainAckedMessages is
+	// set, are kept.
+	MessageRetentionDuration time.Duration `json:"messageRetentionDuration,omitempty" yaml:"messageRetentionDuration,omitempty"`
+	RetainAckedMessages      bool          `json:"retainAckedMessages,omitempty" yaml:"retainAckedMessages,omitempty"`
+	// Filter selects the messages delivered to the subscription by their attributes, using the Google Pub/Sub filter
+	// syntax. Messages that do not match are never delivered.
+	Filter           string            `json:"filter,omitempty" yaml:"filter,omitempty"`
+	RetryPolicy      *RetryPolicy      `json:"retryPolicy,omitempty" yaml:"retryPolicy,omitempty"`
+	ExpirationPolicy *ExpirationPolicy `json:"expirationPolicy,omitempty" yaml:"expirationPolicy,omitempty"`
+	// Forward republishes deliveries to another topic instead of storing them, see ForwardConfig.
+	Forward *ForwardConfig `json:"forward,omitempty" yaml:"forward,omitempty"`
+	// PushConfig pushes messages to an HTTP endpoint, see PushConfig.
+	PushConfig *PushConfig `json:"pushConfig,omitempty" yaml:"pushConfig,omitempty"`
+}
+
+// RetryPolicy delays the redelivery of nacked messages with an exponential backoff based on their delivery attempt.
+type RetryPolicy struct {
+	MinimumBackoff time.Duration `json:"minimumBackoff,omitempty" yaml:"minimumBackoff,omitempty"`
+	MaximumBackoff time.Duration `json:"maximumBackoff,omitempty" yaml:"maximumBackoff,omitempty"`
+}
+
+// ExpirationPolicy deletes a subscription, along with its backlog, once it has not been pulled from for its TTL. A
+// subscription without a policy, or with a zero TTL, never expires.
+type ExpirationPolicy struct {
+	TTL time.Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+}
+
+// ParseTopicConfig decodes and validates a topic configuration written in YAML or JSON.
+func ParseTopicConfig(data []byte) (*TopicConfig, error) {
+	config := &TopicConfig{}
+	if err := decodeConfig(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ParseSubscriptionConfig decodes and validates a subscription configuration written in YAML or JSON.
+func ParseSubscriptionConfig(data []byte) (*SubscriptionConfig, error) {
+	config := &SubscriptionConfig{}
+	if err := decodeConfig(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// decodeConfig decodes YAML, which also covers JSON documents, rejecting unknown fields.
+func decodeConfig(data []byte, config any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid field, joining one *ConfigError per field.
+func (c *TopicConfig) Validate() error {
+	errs := validateLabels(c.Labels)
+	errs = append(errs, validateDuration("messageRetentionDuration", c.MessageRetentionDuration, MinRetention, MaxTopicRetention)...)
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid field, joining one *ConfigError per field.
+func (c *SubscriptionConfig) Validate() error {
+	errs := validateLabels(c.Labels)
+	errs = append(errs, validateDuration("ackDeadline", c.AckDeadline, MinAckDeadline, MaxAckDeadline)...)
+	errs = append(errs, validateDuration("messageRetentionDuration", c.MessageRetentionDuration, MinRetention, DefaultRetention)...)
+	if len(c.Filter) > maxFilterBytes {
+		errs = append(errs, &ConfigError{Field: "filter", Reason: fmt.Sprintf("must be at most %d bytes", maxFilterBytes)})
+	} else if _, err := compileFilter(c.Filter); err != nil {
+		errs = append(errs, &ConfigError{Field: "filter", Reason: err.Error()})
+	}
+	if p := c.RetryPolicy; p != nil {
+		errs = append(errs, validateDuration("retryPolicy.minimumBackoff", p.MinimumBackoff, 0, MaxBackoff)...)
+		errs = append(errs, validateDuration("retryPolicy.maximumBackoff", p.MaximumBackoff, 0, MaxBackoff)...)
+		if p.MaximumBackoff != 0 && p.MaximumBackoff < p.minimumBackoff() {
+			errs = append(errs, &ConfigError{Field: "retryPolicy.maximumBackoff", Reason: "must not be less than minimumBackoff"})
+		}
+	}
+	if c.Forward != nil {
+		errs = append(errs, c.Forward.validate()...)
+	}
+	if c.PushConfig != nil {
+		errs = append(errs, c.PushConfig.validate()...)
+		if c.Forward != nil {
+			errs = append(errs, &ConfigError{Field: "pushConfig", Reason: "forwarding subscriptions cannot push"})
+		}
+	}
+	if p := c.ExpirationPolicy; p != nil && p.TTL != 0 {
+		retention := c.MessageRetentionDuration
+		if retention == 0 {
+			retention = DefaultRetention
+		}
+		if p.TTL < MinExpirationTTL {
+			errs = append(errs, &ConfigError{Field: "expirationPolicy.ttl", Reason: fmt.Sprintf("must be at least %s", MinExpirationTTL)})
+		} else if p.TTL < retention {
+			errs = append(errs, &ConfigError{Field: "expirationPolicy.ttl", Reason: fmt.Sprintf("must not be less than the message retention duration (%s)", retention)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+func validateLabels(labels map[string]string) []error {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		field := "labels." + key
+		if !labelKeyPattern.MatchString(key) {
+			errs = append(errs, &ConfigError{Field: field, Reason: "keys must start with a lowercase letter and contain at most 63 lowercase letters, digits, underscores or dashes"})
+		} else if !labelValuePattern.MatchString(labels[key]) {
+			errs = append(errs, &ConfigError{Field: field, Reason: "values must contain at most 63 lowercase letters, digits, underscores or dashes"})
+		}
+	}
+	return errs
+}
+
+// validateDuration checks that a duration is either unset or within [min, max].
+func validateDuration(field string, d, min, max time.Duration) []error {
+	if d == 0 || (d >= min && d <= max) {
+		return nil
+	}
+	return []error{&ConfigError{Field: field, Reason: fmt.Sprintf("%s is not between %s and %s", d, min, max)}}
+}
+
+// EffectiveAckDeadline returns the configured ack deadline, or the default when none is set.
+func (c *SubscriptionConfig) EffectiveAckDeadline() time.Duration {
+	if c.AckDeadline == 0 {
+		return DefaultAckDeadline
+	}
+	return c.AckDeadline
+}
+
+func (p *RetryPolicy) minimumBackoff() time.Duration {
+	if p.MinimumBackoff == 0 {
+		return DefaultMinimumBackoff
+	}
+	return p.MinimumBackoff
+}
+
+// backoff returns how long to delay the redelivery of a message nacked after the given number of delivery attempts.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	max := p.MaximumBackoff
+	if max == 0 {
+		max = MaxBackoff
+	}
+	d := p.minimumBackoff()
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Configurations are stored as JSON in the metadata column of their table.
+func encodeConfig(config any) ([]byte, error) {
+	return json.Marshal(config)
+}
+
+// decodeStoredConfig decodes a stored configuration. Databases written before configurations were stored can hold
+// metadata that is not JSON, which is read as the default configuration.
+func decodeStoredConfig(b []byte, config any) error {
+	if len(b) == 0 || !json.Valid(b) {
+		return nil
+	}
+	if err := json.Unmarshal(b, config); err != nil {
+		return fmt.Errorf("invalid stored configuration: %v", err)
+	}
+	return nil
+}
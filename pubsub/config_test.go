@@ -0,0 +1,188 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	yamlConfig := `
+labels:
+  team: payments
+ackDeadline: 30s
+messageRetentionDuration: 24h
+retainAckedMessages: true
+filter: attributes.region = "eu" AND NOT attributes:test
+retryPolicy:
+  minimumBackoff: 5s
+  maximumBackoff: 1m
+`
+	config, err := ParseSubscriptionConfig([]byte(yamlConfig))
+	ok(t, err, "failed to parse YAML config")
+	equals(t, "payments", config.Labels["team"], "labels don't match")
+	equals(t, 30*time.Second, config.AckDeadline, "ack deadline doesn't match")
+	equals(t, 24*time.Hour, config.MessageRetentionDuration, "retention doesn't match")
+	equals(t, true, config.RetainAckedMessages, "retain acked messages doesn't match")
+	equals(t, time.Minute, config.RetryPolicy.MaximumBackoff, "maximum backoff doesn't match")
+
+	jsonConfig := `{"labels": {"team": "payments"}, "messageRetentionDuration": "48h"}`
+	topicConfig, err := ParseTopicConfig([]byte(jsonConfig))
+	ok(t, err, "failed to parse JSON config")
+	equals(t, 48*time.Hour, topicConfig.MessageRetentionDuration, "retention doesn't match")
+
+	empty, err := ParseSubscriptionConfig(nil)
+	ok(t, err, "failed to parse empty config")
+	equals(t, DefaultAckDeadline, empty.EffectiveAckDeadline(), "empty config should use the default ack deadline")
+
+	_, err = ParseSubscriptionConfig([]byte("ackDeadline: 1h\nlabels: {Team: x}\nfilter: attributes.a ==\n"))
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected a config error, got %v", err)
+	}
+	for _, field := range []string{"ackDeadline", "labels.Team", "filter"} {
+		if !hasConfigError(err, field) {
+			t.Errorf("expected an error for %s, got %v", field, err)
+		}
+	}
+
+	if _, err := ParseTopicConfig([]byte("retention: 1h")); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected unknown fields to be rejected, got %v", err)
+	}
+	if _, err := ParseTopicConfig([]byte("messageRetentionDuration: 3600")); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected durations without units to be rejected, got %v", err)
+	}
+}
+
+func hasConfigError(err error, field string) bool {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return false
+	}
+	for _, err := range joined.Unwrap() {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) && configErr.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFilter(t *testing.T) {
+	attributes := map[string]string{"region": "eu-west", "type": "order", "test": ""}
+	for _, tt := range []struct {
+		filter string
+		match  bool
+	}{
+		{``, true},
+		{`attributes.type = "order"`, true},
+		{`attributes.type != "order"`, false},
+		{`attributes:test`, true},
+		{`attributes:missing`, false},
+		{`hasPrefix(attributes.region, "eu-")`, true},
+		{`hasPrefix(attributes.missing, "")`, false},
+		{`attributes.type = "order" AND NOT attributes:test`, false},
+		{`attributes.type = "refund" OR -attributes:missing`, true},
+		{`(attributes.type = "refund" OR attributes.type = "order") AND attributes."region" = "eu-west"`, true},
+	} {
+		match, err := matchFilter(tt.filter, attributes)
+		ok(t, err, "failed to compile filter")
+		equals(t, tt.match, match, tt.filter)
+	}
+
+	for _, invalid := range []string{`attributes.type = order`, `attributes.type = "a" AND`, `(attributes:a`, `labels.a = "b"`, `attributes.a = "b" attributes.c = "d"`} {
+		if _, err := compileFilter(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestSubscriptionConfig(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", &TopicConfig{Labels: map[string]string{"team": "payments"}}), "failed to create topic")
+	topic, err := s.GetTopic(ctx, "orders")
+	ok(t, err, "failed to get topic")
+	equals(t, "payments", topic.Config.Labels["team"], "topic config should be stored")
+
+	err = s.CreateSubscription(ctx, "orders", "bad", &SubscriptionConfig{AckDeadline: time.Second})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected invalid config to be rejected, got %v", err)
+	}
+
+	config := &SubscriptionConfig{
+		AckDeadline: time.Minute,
+		Filter:      `attributes.region = "eu"`,
+		RetryPolicy: &RetryPolicy{MinimumBackoff: 20 * time.Second, MaximumBackoff: 30 * time.Second},
+	}
+	ok(t, s.CreateSubscription(ctx, "orders", "eu", config), "failed to create subscription")
+	subscription, err := s.GetSubscription(ctx, "eu")
+	ok(t, err, "failed to get subscription")
+	equals(t, time.Minute, subscription.Config.AckDeadline, "subscription config should be stored")
+
+	ok(t, s.PublishMessage(ctx, "orders", "us order", map[string]string{"region": "us"}), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "orders", "eu order", map[string]string{"region": "eu"}), "failed to publish message")
+
+	before := time.Now()
+	messages, err := s.PullMessages(ctx, "eu", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 1, len(messages), "filter should drop non-matching messages")
+	equals(t, "eu order", messages[0].Content, "wrong message delivered")
+
+	messages, err = s.GetMessages(ctx, "eu")
+	ok(t, err, "failed to get messages")
+	if lease := messages[0].AckDeadline.Time.Sub(before); lease < time.Minute || lease > time.Minute+5*time.Second {
+		t.Fatalf("expected the configured ack deadline to be used, got a lease of %s", lease)
+	}
+	equals(t, 1, messages[0].DeliveryAttempt, "pull should count delivery attempts")
+
+	// Nacking backs off by the minimum backoff, doubled for each further delivery attempt up to the maximum.
+	ok(t, s.ModifyAckDeadline(ctx, "eu", messages[0].ID, time.Now()), "failed to nack message")
+	messages, err = s.PullMessages(ctx, "eu", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 0, len(messages), "nacked message should not be redelivered before its backoff")
+
+	policy := config.RetryPolicy
+	equals(t, 20*time.Second, policy.backoff(1), "first backoff should be the minimum")
+	equals(t, 30*time.Second, policy.backoff(2), "backoff should be capped at the maximum")
+
+	// A stored filter that no longer compiles only keeps the message from its own subscription.
+	ok(t, s.CreateSubscription(ctx, "orders", "all", nil), "failed to create subscription")
+	corrupt := *config
+	corrupt.Filter = `attributes.region =`
+	metadata, err := encodeConfig(&corrupt)
+	ok(t, err, "failed to encode config")
+	_, err = s.db.ExecContext(ctx, "UPDATE Subscriptions SET metadata = ? WHERE id = ?", metadata, subscription.ID)
+	ok(t, err, "failed to corrupt filter")
+	ok(t, s.PublishMessage(ctx, "orders", "eu order", map[string]string{"region": "eu"}), "failed to publish message")
+	messages, err = s.GetMessages(ctx, "all")
+	ok(t, err, "failed to get messages")
+	equals(t, 1, len(messages), "other subscriptions should still receive the message")
+}
+
+func TestLegacyMetadata(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", nil), "failed to create subscription")
+	for _, table := range []string{"Topics", "Subscriptions"} {
+		_, err := s.db.ExecContext(ctx, "UPDATE "+table+" SET metadata = ?", []byte("metadata"))
+		ok(t, err, "failed to store legacy metadata")
+	}
+
+	topic, err := s.GetTopic(ctx, "orders")
+	ok(t, err, "failed to get topic")
+	equals(t, time.Duration(0), topic.Config.MessageRetentionDuration, "legacy metadata should read as the default config")
+	topics, err := s.ListTopics(ctx)
+	ok(t, err, "failed to list topics")
+	equals(t, 1, len(topics), "topic count doesn't match expectation")
+	subscription, err := s.GetSubscription(ctx, "billing")
+	ok(t, err, "failed to get subscription")
+	equals(t, time.Duration(0), subscription.Config.AckDeadline, "legacy metadata should read as the default config")
+	ok(t, s.PublishMessage(ctx, "orders", "order", nil), "failed to publish message")
+	_, err = s.GC(ctx, time.Now())
+	ok(t, err, "failed to collect garbage")
+}
@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDetached is returned when pulling from a subscription that has been detached from its topic.
+var ErrDetached = errors.New("subscription is detached")
+
+// DeleteTopic deletes a topic along with every message published to it, including retained ones, and its snapshots.
+// Its subscriptions are kept but detached, as are the subscriptions forwarding to it, so that they can still be
+// inspected and deleted by name.
+func (s *Service) DeleteTopic(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	topic, err := s.topicByName(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM Messages WHERE topic_id = ?", topic.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM TopicMessages WHERE topic_id = ?", topic.ID); err != nil {
+		return err
+	}
+	if err := deleteSnapshots(ctx, tx, "topic_id = ?", topic.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE Subscriptions SET topic_id = NULL, detached = 1 WHERE topic_id = ?", topic.ID); err != nil {
+		return err
+	}
+	if err := detachForwarding(ctx, tx, topic); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM Topics WHERE id = ?", topic.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// detachForwarding detaches the subscriptions forwarding to a topic that is being deleted, as they have nowhere left
+// to deliver to.
+func detachForwarding(ctx context.Context, q querier, topic *Topic) error {
+	rows, err := q.QueryContext(ctx, "SELECT "+subscriptionColumns+" FROM Subscriptions WHERE detached = 0")
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		forward := subscription.Config.Forward
+		if forward == nil {
+			continue
+		}
+		project, name, err := resolveName(WithProject(ctx, subscription.Project), forward.Topic, "topics")
+		if err == nil && project == topic.Project && name == topic.Name {
+			ids = append(ids, subscription.ID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := q.ExecContext(ctx, "UPDATE Subscriptions SET detached = 1 WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSubscription deletes a subscription and its messages.
+func (s *Service) DeleteSubscription(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	subscription, err := s.subscriptionByName(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := deleteSubscription(ctx, tx, subscription.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteSubscription deletes a subscription and its messages, returning the number of messages deleted.
+func deleteSubscription(ctx context.Context, q querier, id int) (int, error) {
+	res, err := q.ExecContext(ctx, "DELETE FROM Messages WHERE subscription_id = ?", id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := rowsAffected(res)
+	if err != nil {
+		return 0, err
+	}
+	_, err = q.ExecContext(ctx, "DELETE FROM Subscriptions WHERE id = ?", id)
+	return n, err
+}
+
+// DetachSubscription stops a subscription from receiving messages and drops its backlog. The subscription itself is
+// kept; pulling from it fails with ErrDetached.
+func (s *Service) DetachSubscription(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	subscription, err := s.subscriptionByName(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM Messages WHERE subscription_id = ?", subscription.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE Subscriptions SET detached = 1 WHERE id = ?", subscription.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,98 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	for _, name := range []string{"billing", "shipping", "audit"} {
+		ok(t, s.CreateSubscription(ctx, "orders", name, nil), "failed to create subscription")
+	}
+	ok(t, s.PublishMessage(ctx, "orders", "first", nil), "failed to publish message")
+
+	ok(t, s.DetachSubscription(ctx, "audit"), "failed to detach subscription")
+	audit, err := s.GetSubscription(ctx, "audit")
+	ok(t, err, "failed to get detached subscription")
+	equals(t, true, audit.Detached, "subscription should be detached")
+	if _, err := s.PullMessages(ctx, "audit", time.Now()); !errors.Is(err, ErrDetached) {
+		t.Fatalf("expected pulling from a detached subscription to fail, got %v", err)
+	}
+	ok(t, s.PublishMessage(ctx, "orders", "second", nil), "failed to publish message")
+	messages, err := s.GetMessages(ctx, "audit")
+	ok(t, err, "failed to get messages")
+	equals(t, 0, len(messages), "detached subscription should have no messages")
+	subscriptions, err := s.ListSubscriptions(ctx, "orders")
+	ok(t, err, "failed to list subscriptions")
+	equals(t, 2, len(subscriptions), "detached subscription should not be listed for the topic")
+
+	ok(t, s.DeleteSubscription(ctx, "shipping"), "failed to delete subscription")
+	if _, err := s.GetSubscription(ctx, "shipping"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted subscription to be gone, got %v", err)
+	}
+
+	ok(t, s.DeleteTopic(ctx, "orders"), "failed to delete topic")
+	if _, err := s.GetTopic(ctx, "orders"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted topic to be gone, got %v", err)
+	}
+	billing, err := s.GetSubscription(ctx, "billing")
+	ok(t, err, "subscription should outlive its topic")
+	equals(t, true, billing.Detached, "subscription of a deleted topic should be detached")
+	equals(t, 0, billing.TopicID, "subscription of a deleted topic should not reference it")
+	messages, err = s.GetMessages(ctx, "billing")
+	ok(t, err, "failed to get messages")
+	equals(t, 0, len(messages), "messages of a deleted topic should be deleted")
+
+	if err := s.DeleteTopic(ctx, "orders"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleting a missing topic to fail, got %v", err)
+	}
+}
+
+func TestMigrateSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), DefaultFilename)
+
+	// Tables as created before subscriptions could outlive their topic.
+	db, err := sql.Open("sqlite3", fname)
+	ok(t, err, "failed to open database")
+	for _, stmt := range []string{
+		`CREATE TABLE Topics (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE, metadata BLOB,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE Subscriptions (id INTEGER PRIMARY KEY AUTOINCREMENT, topic_id INTEGER NOT NULL,
+            subscriber_id TEXT NOT NULL, metadata BLOB, created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (topic_id) REFERENCES Topics(id))`,
+		`CREATE TABLE Messages (id INTEGER PRIMARY KEY AUTOINCREMENT, topic_id INTEGER NOT NULL,
+            subscription_id INTEGER, content TEXT NOT NULL, metadata BLOB, acknowledged BOOLEAN DEFAULT FALSE,
+            ack_deadline DATETIME, published_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (topic_id) REFERENCES Topics(id), FOREIGN KEY (subscription_id) REFERENCES Subscriptions(id))`,
+		"INSERT INTO Topics (name) VALUES ('orders')",
+		"INSERT INTO Subscriptions (topic_id, subscriber_id) VALUES (1, 'billing')",
+		"INSERT INTO Messages (topic_id, subscription_id, content) VALUES (1, 1, 'old')",
+	} {
+		_, err := db.ExecContext(ctx, stmt)
+		ok(t, err, "failed to create old tables")
+	}
+	ok(t, db.Close(), "failed to close database")
+
+	s, err := NewService(fname)
+	ok(t, err, "failed to create service")
+	defer s.Close()
+	ok(t, s.Init(ctx), "failed to initialize service")
+
+	messages, err := s.GetMessages(ctx, "billing")
+	ok(t, err, "failed to get messages")
+	equals(t, 1, len(messages), "messages should survive the migration")
+
+	ok(t, s.DeleteTopic(ctx, "orders"), "failed to delete topic")
+	billing, err := s.GetSubscription(ctx, "billing")
+	ok(t, err, "subscription should outlive its topic")
+	equals(t, true, billing.Detached, "subscription of a deleted topic should be detached")
+}
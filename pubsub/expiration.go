@@ -0,0 +1,27 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+)
+
+// ExpireTime returns when the subscription is deleted unless it is pulled from, or the zero time if it never expires.
+func (s *Subscription) ExpireTime() time.Time {
+	p := s.Config.ExpirationPolicy
+	if p == nil || p.TTL == 0 {
+		return time.Time{}
+	}
+	return s.LastActive.Add(p.TTL)
+}
+
+// touchSubscription records activity on a subscription, postponing its expiration.
+func touchSubscription(ctx context.Context, q querier, id int, now time.Time) error {
+	_, err := q.ExecContext(ctx, "UPDATE Subscriptions SET last_active_at = ? WHERE id = ?", dbTime(now), id)
+	return err
+}
+
+// expired reports whether the subscription has been inactive for longer than its TTL at now.
+func (s *Subscription) expired(now time.Time) bool {
+	expireTime := s.ExpireTime()
+	return !expireTime.IsZero() && !now.Before(expireTime)
+}
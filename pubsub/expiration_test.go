@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExpiration(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ttl := &ExpirationPolicy{TTL: 7 * 24 * time.Hour}
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "orders", "active", &SubscriptionConfig{ExpirationPolicy: ttl}), "failed to create subscription")
+	ok(t, s.CreateSubscription(ctx, "orders", "orphan", &SubscriptionConfig{ExpirationPolicy: ttl}), "failed to create subscription")
+	ok(t, s.CreateSubscription(ctx, "orders", "forever", nil), "failed to create subscription")
+
+	err := s.CreateSubscription(ctx, "orders", "short", &SubscriptionConfig{ExpirationPolicy: &ExpirationPolicy{TTL: time.Hour}})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected a TTL below the minimum to be rejected, got %v", err)
+	}
+	err = s.CreateSubscription(ctx, "orders", "short", &SubscriptionConfig{ExpirationPolicy: &ExpirationPolicy{TTL: 2 * 24 * time.Hour}})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected a TTL below the retention duration to be rejected, got %v", err)
+	}
+
+	// Make every subscription look idle for six days, then pull from one of them.
+	idle := time.Now().Add(-6 * 24 * time.Hour)
+	_, err = s.db.ExecContext(ctx, "UPDATE Subscriptions SET last_active_at = ?", dbTime(idle))
+	ok(t, err, "failed to age subscriptions")
+	_, err = s.PullMessages(ctx, "active", time.Time{})
+	ok(t, err, "failed to pull messages")
+	ok(t, s.PublishMessage(ctx, "orders", "order", nil), "failed to publish message")
+
+	orphan, err := s.GetSubscription(ctx, "orphan")
+	ok(t, err, "failed to get subscription")
+	if expireTime := orphan.ExpireTime(); expireTime.Sub(idle.Add(ttl.TTL)).Abs() > time.Second {
+		t.Fatalf("expected the orphan to expire a TTL after its last activity, got %s", expireTime)
+	}
+	forever, err := s.GetSubscription(ctx, "forever")
+	ok(t, err, "failed to get subscription")
+	equals(t, true, forever.ExpireTime().IsZero(), "subscriptions without a policy should never expire")
+
+	deleted, err := s.GC(ctx, time.Now().Add(2*24*time.Hour))
+	ok(t, err, "failed to collect garbage")
+	equals(t, 1, deleted, "the expired subscription's backlog should be deleted")
+	_, err = s.GetSubscription(ctx, "orphan")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the idle subscription to be deleted, got %v", err)
+	}
+	subscriptions, err := s.ListSubscriptions(ctx, "orders")
+	ok(t, err, "failed to list subscriptions")
+	equals(t, 2, len(subscriptions), "active subscriptions should be kept")
+}
+
+func TestExpiredSubscriptionReceivesNothing(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ttl := &ExpirationPolicy{TTL: 7 * 24 * time.Hour}
+	ok(t, s.CreateSubscription(ctx, "orders", "orphan", &SubscriptionConfig{ExpirationPolicy: ttl}), "failed to create subscription")
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", nil), "failed to create subscription")
+	_, err := s.db.ExecContext(ctx, "UPDATE Subscriptions SET last_active_at = ? WHERE subscriber_id = 'orphan'",
+		dbTime(time.Now().Add(-8*24*time.Hour)))
+	ok(t, err, "failed to age subscription")
+
+	ok(t, s.PublishMessage(ctx, "orders", "order", nil), "failed to publish message")
+	messages, err := s.GetMessages(ctx, "orphan")
+	ok(t, err, "the expired subscription should exist until GC runs")
+	equals(t, 0, len(messages), "expired subscriptions should not receive messages")
+	messages, err = s.GetMessages(ctx, "billing")
+	ok(t, err, "failed to get messages")
+	equals(t, 1, len(messages), "other subscriptions should receive the message")
+}
+
+func TestForwardingKeepsSubscriptionActive(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateTopic(ctx, "audit", nil), "failed to create topic")
+	config := &SubscriptionConfig{Forward: &ForwardConfig{Topic: "audit"}, ExpirationPolicy: &ExpirationPolicy{TTL: 7 * 24 * time.Hour}}
+	ok(t, s.CreateSubscription(ctx, "orders", "to-audit", config), "failed to create subscription")
+	_, err := s.db.ExecContext(ctx, "UPDATE Subscriptions SET last_active_at = ?", dbTime(time.Now().Add(-6*24*time.Hour)))
+	ok(t, err, "failed to age subscription")
+
+	ok(t, s.PublishMessage(ctx, "orders", "order", nil), "failed to publish message")
+	_, err = s.GC(ctx, time.Now().Add(2*24*time.Hour))
+	ok(t, err, "failed to collect garbage")
+	_, err = s.GetSubscription(ctx, "to-audit")
+	ok(t, err, "a subscription that forwards messages should not expire")
+}
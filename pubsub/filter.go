@@ -0,0 +1,296 @@
+package pubsub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// filter is a compiled subscription filter. The syntax is the one used by Google Pub/Sub, which matches messages on
+// their attributes:
+//
+//	attributes.KEY = "value"
+//	attributes.KEY != "value"
+//	attributes:KEY
+//	hasPrefix(attributes.KEY, "prefix")
+//
+// Conditions are combined with AND, OR, NOT (or a leading -) and parentheses.
+type filter interface {
+	match(attributes map[string]string) bool
+}
+
+// compileFilter parses a filter expression. The empty expression compiles to nil, which matches every message.
+func compileFilter(expr string) (filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", t, t.pos)
+	}
+	return f, nil
+}
+
+// matchFilter reports whether a message with the given attributes passes a filter expression.
+func matchFilter(expr string, attributes map[string]string) (bool, error) {
+	f, err := compileFilter(expr)
+	if err != nil || f == nil {
+		return err == nil, err
+	}
+	return f.match(attributes), nil
+}
+
+type (
+	andFilter    struct{ left, right filter }
+	orFilter     struct{ left, right filter }
+	notFilter    struct{ f filter }
+	hasFilter    struct{ key string }
+	equalsFilter struct{ key, value string }
+	prefixFilter struct{ key, prefix string }
+)
+
+func (f andFilter) match(a map[string]string) bool { return f.left.match(a) && f.right.match(a) }
+func (f orFilter) match(a map[string]string) bool  { return f.left.match(a) || f.right.match(a) }
+func (f notFilter) match(a map[string]string) bool { return !f.f.match(a) }
+
+func (f hasFilter) match(a map[string]string) bool {
+	_, ok := a[f.key]
+	return ok
+}
+
+func (f equalsFilter) match(a map[string]string) bool {
+	v, ok := a[f.key]
+	return ok && v == f.value
+}
+
+func (f prefixFilter) match(a map[string]string) bool {
+	v, ok := a[f.key]
+	return ok && strings.HasPrefix(v, f.prefix)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenPunct // ( ) , . : = != -
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of filter"
+	case tokenString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func tokenizeFilter(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '!':
+			if !strings.HasPrefix(expr[i:], "!=") {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{tokenPunct, "!=", i})
+			i += 2
+		case strings.ContainsRune("(),.:=-", c):
+			tokens = append(tokens, token{tokenPunct, string(c), i})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %v", i, err)
+			}
+			tokens = append(tokens, token{tokenString, s, i})
+			i = end + 1
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			end := i
+			for end < len(expr) && (expr[end] == '_' || unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{tokenIdent, expr[i:end], i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && t.text == word
+}
+
+func (p *filterParser) expect(punct string) error {
+	if t := p.next(); t.kind != tokenPunct || t.text != punct {
+		return fmt.Errorf("expected %q at offset %d, found %s", punct, t.pos, t)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filter, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenIdent && t.text == "NOT", t.kind == tokenPunct && t.text == "-":
+		p.next()
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{f}, nil
+	case t.kind == tokenPunct && t.text == "(":
+		p.next()
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case t.kind == tokenIdent && t.text == "hasPrefix":
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		key, err := p.parseAttribute(".")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		prefix, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return prefixFilter{key, prefix}, p.expect(")")
+	case t.kind == tokenIdent && t.text == "attributes":
+		if p.tokens[p.pos+1].text == ":" {
+			key, err := p.parseAttribute(":")
+			if err != nil {
+				return nil, err
+			}
+			return hasFilter{key}, nil
+		}
+		key, err := p.parseAttribute(".")
+		if err != nil {
+			return nil, err
+		}
+		op := p.next()
+		if op.kind != tokenPunct || (op.text != "=" && op.text != "!=") {
+			return nil, fmt.Errorf("expected \"=\" or \"!=\" at offset %d, found %s", op.pos, op)
+		}
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		if op.text == "!=" {
+			return notFilter{equalsFilter{key, value}}, nil
+		}
+		return equalsFilter{key, value}, nil
+	}
+	return nil, fmt.Errorf("expected a condition at offset %d, found %s", t.pos, t)
+}
+
+// parseAttribute parses "attributes" followed by the separator and an attribute key, which may be quoted.
+func (p *filterParser) parseAttribute(separator string) (string, error) {
+	if t := p.next(); t.kind != tokenIdent || t.text != "attributes" {
+		return "", fmt.Errorf("expected \"attributes\" at offset %d, found %s", t.pos, t)
+	}
+	if err := p.expect(separator); err != nil {
+		return "", err
+	}
+	t := p.next()
+	if t.kind != tokenIdent && t.kind != tokenString {
+		return "", fmt.Errorf("expected an attribute key at offset %d, found %s", t.pos, t)
+	}
+	return t.text, nil
+}
+
+func (p *filterParser) parseString() (string, error) {
+	t := p.next()
+	if t.kind != tokenString {
+		return "", fmt.Errorf("expected a string at offset %d, found %s", t.pos, t)
+	}
+	return t.text, nil
+}
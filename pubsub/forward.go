@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ErrForwardingCycle is returned when forwarding subscriptions would republish a message to a topic it has already
+// been published to.
+var ErrForwardingCycle = errors.New("forwarding cycle")
+
+// ForwardConfig makes a subscription forward its deliveries: instead of being stored for pulling, every message that
+// passes the subscription's filter is republished to the target topic, in the same transaction as the original
+// publish. Attributes are transformed on the way, dropping first, then renaming, then adding.
+type ForwardConfig struct {
+	// Topic is the name of the topic messages are republished to.
+	Topic            string            `json:"topic" yaml:"topic"`
+	AddAttributes    map[string]string `json:"addAttributes,omitempty" yaml:"addAttributes,omitempty"`
+	RenameAttributes map[string]string `json:"renameAttributes,omitempty" yaml:"renameAttributes,omitempty"` // Old key to new key
+	DropAttributes   []string          `json:"dropAttributes,omitempty" yaml:"dropAttributes,omitempty"`
+}
+
+func (f *ForwardConfig) validate() []error {
+	var errs []error
+	if f.Topic == "" {
+		errs = append(errs, &ConfigError{Field: "forward.topic", Reason: "a target topic is required"})
+	}
+	if _, ok := f.AddAttributes[""]; ok {
+		errs = append(errs, &ConfigError{Field: "forward.addAttributes", Reason: "keys must not be empty"})
+	}
+	for _, old := range slices.Sorted(maps.Keys(f.RenameAttributes)) {
+		if old == "" || f.RenameAttributes[old] == "" {
+			errs = append(errs, &ConfigError{Field: "forward.renameAttributes." + old, Reason: "keys must not be empty"})
+		}
+	}
+	return errs
+}
+
+// transform returns a copy of the attributes with the configured drops, renames and additions applied.
+func (f *ForwardConfig) transform(attributes map[string]string) map[string]string {
+	out := make(map[string]string, len(attributes)+len(f.AddAttributes))
+	for key, value := range attributes {
+		out[key] = value
+	}
+	for _, key := range f.DropAttributes {
+		delete(out, key)
+	}
+	renamed := make(map[string]string, len(f.RenameAttributes))
+	for old, key := range f.RenameAttributes {
+		if value, ok := out[old]; ok {
+			renamed[key] = value
+			delete(out, old)
+		}
+	}
+	for key, value := range renamed {
+		out[key] = value
+	}
+	for key, value := range f.AddAttributes {
+		out[key] = value
+	}
+	return out
+}
+
+// checkForward verifies that the target of a forwarding subscription on the given topic exists and does not forward,
+// directly or through other forwarding subscriptions, back to the topic. Plain target names name topics of the project
+// of ctx, or of the forwarding subscription for the subscriptions met along the way.
+func (s *Service) checkForward(ctx context.Context, q querier, topic *Topic, config *SubscriptionConfig) error {
+	if config.Forward == nil {
+		return nil
+	}
+	target, err := s.topicByName(ctx, q, config.Forward.Topic)
+	if err != nil {
+		return err
+	}
+
+	seen := map[int]bool{}
+	for queue := []int{target.ID}; len(queue) > 0; queue = queue[1:] {
+		id := queue[0]
+		if id == topic.ID {
+			return fmt.Errorf("forwarding from topic %q to %q: %w", topic.Name, target.Name, ErrForwardingCycle)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		rows, err := q.QueryContext(ctx, "SELECT "+subscriptionColumns+" FROM Subscriptions WHERE topic_id = ? AND detached = 0", id)
+		if err != nil {
+			return err
+		}
+		var next []*Subscription
+		for rows.Next() {
+			subscription, err := scanSubscription(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if subscription.Config.Forward != nil {
+				next = append(next, subscription)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, subscription := range next {
+			t, err := s.topicByName(WithProject(ctx, subscription.Project), q, subscription.Config.Forward.Topic)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			queue = append(queue, t.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestForward(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateTopic(ctx, "eu-orders", nil), "failed to create topic")
+	ok(t, s.CreateTopic(ctx, "archive", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "eu-orders", "eu-billing", nil), "failed to create subscription")
+	ok(t, s.CreateSubscription(ctx, "archive", "archive-reader", nil), "failed to create subscription")
+
+	ok(t, s.CreateSubscription(ctx, "orders", "to-eu", &SubscriptionConfig{
+		Filter: `attributes.region = "eu"`,
+		Forward: &ForwardConfig{
+			Topic:            "eu-orders",
+			AddAttributes:    map[string]string{"source": "orders"},
+			RenameAttributes: map[string]string{"region": "origin"},
+			DropAttributes:   []string{"internal"},
+		},
+	}), "failed to create forwarding subscription")
+	ok(t, s.CreateSubscription(ctx, "eu-orders", "to-archive", &SubscriptionConfig{Forward: &ForwardConfig{Topic: "archive"}}), "failed to create forwarding subscription")
+
+	ok(t, s.PublishMessage(ctx, "orders", "us order", map[string]string{"region": "us"}), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "orders", "eu order", map[string]string{"region": "eu", "internal": "x", "id": "1"}), "failed to publish message")
+
+	messages, err := s.PullMessages(ctx, "eu-billing", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 1, len(messages), "only messages passing the filter should be forwarded")
+	equals(t, "eu order", messages[0].Content, "wrong message forwarded")
+	if want := map[string]string{"origin": "eu", "id": "1", "source": "orders"}; !maps.Equal(want, messages[0].Attributes) {
+		t.Fatalf("attributes should be transformed: expected %v, got %v", want, messages[0].Attributes)
+	}
+
+	messages, err = s.PullMessages(ctx, "archive-reader", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 1, len(messages), "forwarding should chain through topics")
+	messages, err = s.GetMessages(ctx, "to-eu")
+	ok(t, err, "failed to get messages")
+	equals(t, 0, len(messages), "forwarding subscriptions should not store messages")
+
+	// Cycles are rejected whether they are closed by creating or updating a subscription.
+	err = s.CreateSubscription(ctx, "archive", "loop", &SubscriptionConfig{Forward: &ForwardConfig{Topic: "orders"}})
+	if !errors.Is(err, ErrForwardingCycle) {
+		t.Fatalf("expected a forwarding cycle to be rejected, got %v", err)
+	}
+	err = s.CreateSubscription(ctx, "orders", "self", &SubscriptionConfig{Forward: &ForwardConfig{Topic: "orders"}})
+	if !errors.Is(err, ErrForwardingCycle) {
+		t.Fatalf("expected forwarding to the same topic to be rejected, got %v", err)
+	}
+	_, err = s.UpdateSubscription(ctx, "archive-reader", &SubscriptionConfig{Forward: &ForwardConfig{Topic: "eu-orders"}}, []string{"forward"})
+	if !errors.Is(err, ErrForwardingCycle) {
+		t.Fatalf("expected an update closing a cycle to be rejected, got %v", err)
+	}
+	err = s.CreateSubscription(ctx, "orders", "missing", &SubscriptionConfig{Forward: &ForwardConfig{Topic: "missing"}})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected forwarding to a missing topic to be rejected, got %v", err)
+	}
+
+	// A message the target rejects is not published anywhere.
+	ok(t, s.SetTopicLimits(ctx, "archive", Limits{MaxAttributes: 1}), "failed to set limits")
+	err = s.PublishMessage(ctx, "orders", "eu order", map[string]string{"region": "eu", "id": "2"})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected the forwarded message to exceed the archive's limits, got %v", err)
+	}
+	messages, err = s.PullMessages(ctx, "eu-billing", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 0, len(messages), "a failed forward should roll back the whole publish")
+}
+
+func TestForwardToDeletedTopic(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	for _, name := range []string{"a", "b"} {
+		ok(t, s.CreateTopic(ctx, name, nil), "failed to create topic")
+	}
+	ok(t, s.CreateSubscription(ctx, "a", "reader", nil), "failed to create subscription")
+	ok(t, s.CreateSubscription(ctx, "a", "fwd", &SubscriptionConfig{Forward: &ForwardConfig{Topic: "b"}}), "failed to create forwarding subscription")
+
+	ok(t, s.DeleteTopic(ctx, "b"), "failed to delete topic")
+	fwd, err := s.GetSubscription(ctx, "fwd")
+	ok(t, err, "failed to get subscription")
+	equals(t, true, fwd.Detached, "subscriptions forwarding to a deleted topic should be detached")
+	reader, err := s.GetSubscription(ctx, "reader")
+	ok(t, err, "failed to get subscription")
+	equals(t, false, reader.Detached, "other subscriptions of the source topic should stay attached")
+	ok(t, s.PublishMessage(ctx, "a", "after delete", nil), "publishing to the source topic should still succeed")
+
+	// Forwarding subscriptions left attached to a missing target, as databases from before detaching them were, skip it.
+	ok(t, s.CreateTopic(ctx, "c", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "a", "to-c", &SubscriptionConfig{Forward: &ForwardConfig{Topic: "c"}}), "failed to create forwarding subscription")
+	_, err = s.db.ExecContext(ctx, "DELETE FROM Topics WHERE name = 'c'")
+	ok(t, err, "failed to delete topic row")
+	ok(t, s.PublishMessage(ctx, "a", "missing target", nil), "a missing forward target should not fail the publish")
+
+	messages, err := s.GetMessages(ctx, "reader")
+	ok(t, err, "failed to get messages")
+	equals(t, 2, len(messages), "subscribers of the source topic should receive every message")
+}
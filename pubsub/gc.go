@@ -0,0 +1,104 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+)
+
+// GC deletes the messages that subscriptions no longer retain: acknowledged messages, unless the subscription
+// retains them, and any message older than the subscription's retention window. Messages retained by topics are
+// deleted once they are older than the topic's retention. Expired snapshots are deleted too. It returns the number of
+// messages deleted. Subscriptions that have not been pulled from for longer than their expiration TTL are deleted
+// with their backlog, which is included in the count.
+func (s *Service) GC(ctx context.Context, now time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+subscriptionColumns+" FROM Subscriptions")
+	if err != nil {
+		return 0, err
+	}
+	var subscriptions []*Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := deleteSnapshots(ctx, tx, "expire_at <= ?", dbTime(now)); err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for _, subscription := range subscriptions {
+		if subscription.expired(now) {
+			n, err := deleteSubscription(ctx, tx, subscription.ID)
+			if err != nil {
+				return 0, err
+			}
+			deleted += n
+			continue
+		}
+		retention := subscription.Config.MessageRetentionDuration
+		if retention == 0 {
+			retention = DefaultRetention
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM Messages WHERE subscription_id = ?
+            AND (published_at < ? OR (acknowledged = 1 AND NOT ?))`,
+			subscription.ID, dbTime(now.Add(-retention)), subscription.Config.RetainAckedMessages)
+		if err != nil {
+			return 0, err
+		}
+		n, err := rowsAffected(res)
+		if err != nil {
+			return 0, err
+		}
+		deleted += n
+	}
+
+	topics, err := tx.QueryContext(ctx, "SELECT "+topicColumns+" FROM Topics")
+	if err != nil {
+		return 0, err
+	}
+	var retained []*Topic
+	for topics.Next() {
+		topic, err := scanTopic(topics)
+		if err != nil {
+			topics.Close()
+			return 0, err
+		}
+		retained = append(retained, topic)
+	}
+	topics.Close()
+	if err := topics.Err(); err != nil {
+		return 0, err
+	}
+
+	// Topics that no longer retain messages drop their whole history.
+	for _, topic := range retained {
+		cutoff := now
+		if retention := topic.Config.MessageRetentionDuration; retention > 0 {
+			cutoff = now.Add(-retention)
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM TopicMessages WHERE topic_id = ? AND published_at < ?", topic.ID, dbTime(cutoff))
+		if err != nil {
+			return 0, err
+		}
+		n, err := rowsAffected(res)
+		if err != nil {
+			return 0, err
+		}
+		deleted += n
+	}
+	return deleted, tx.Commit()
+}
@@ -0,0 +1,859 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema is a compiled JSON Schema. It supports the validation keywords of draft 2020-12 that are commonly used to
+// describe message payloads: type, enum, const, the numeric, string, array and object constraints, the allOf, anyOf,
+// oneOf and not combinators, and local $ref pointers into the same document. Annotation keywords such as format and
+// description are accepted and ignored.
+type jsonSchema struct {
+	always *bool // Set for the boolean schemas true and false
+
+	types                []string
+	enum                 []any
+	constant             any
+	hasConst             bool
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	multipleOf           *float64
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	items                *jsonSchema
+	minItems, maxItems   *int
+	uniqueItems          bool
+	properties           map[string]*jsonSchema
+	required             []string
+	additionalProperties *jsonSchema
+	minProperties        *int
+	maxProperties        *int
+	allOf, anyOf, oneOf  []*jsonSchema
+	not                  *jsonSchema
+	ref                  string
+	doc                  *jsonSchemaDoc
+}
+
+// jsonSchemaDoc holds the raw document so that $ref pointers can be resolved lazily.
+type jsonSchemaDoc struct {
+	root     any
+	compiled map[string]*jsonSchema
+}
+
+func compileJSONSchema(definition string) (*jsonSchema, error) {
+	var root any
+	if err := json.Unmarshal([]byte(definition), &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %v", err)
+	}
+	doc := &jsonSchemaDoc{root: root, compiled: map[string]*jsonSchema{}}
+	schema, err := doc.compile(root, "#")
+	if err != nil {
+		return nil, err
+	}
+	// Resolve every reference up front so that broken pointers are reported when the schema is created rather than
+	// when a message happens to reach them.
+	for pointer := range doc.refs() {
+		if _, err := doc.resolve(pointer); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+func (d *jsonSchemaDoc) refs() map[string]bool {
+	refs := map[string]bool{}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				refs[ref] = true
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(d.root)
+	return refs
+}
+
+func (d *jsonSchemaDoc) resolve(pointer string) (*jsonSchema, error) {
+	if compiled, ok := d.compiled[pointer]; ok {
+		return compiled, nil
+	}
+	if !strings.HasPrefix(pointer, "#") {
+		return nil, fmt.Errorf("invalid JSON schema: only local references are supported, got %q", pointer)
+	}
+	v := d.root
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "#"), "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[token]
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				v = nil
+			} else {
+				v = node[i]
+			}
+		default:
+			v = nil
+		}
+		if v == nil {
+			return nil, fmt.Errorf("invalid JSON schema: unresolvable reference %q", pointer)
+		}
+	}
+	return d.compile(v, pointer)
+}
+
+func (d *jsonSchemaDoc) compile(v any, pointer string) (*jsonSchema, error) {
+	if compiled, ok := d.compiled[pointer]; ok {
+		return compiled, nil
+	}
+	s := &jsonSchema{doc: d}
+	d.compiled[pointer] = s
+
+	if b, ok := v.(bool); ok {
+		s.always = &b
+		return s, nil
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid JSON schema at %s: expected an object or boolean", pointer)
+	}
+
+	var err error
+	fail := func(keyword, reason string) error {
+		return fmt.Errorf("invalid JSON schema at %s/%s: %s", pointer, keyword, reason)
+	}
+	child := func(keyword string) (*jsonSchema, error) {
+		raw, ok := obj[keyword]
+		if !ok {
+			return nil, nil
+		}
+		return d.compile(raw, pointer+"/"+keyword)
+	}
+	children := func(keyword string) ([]*jsonSchema, error) {
+		raw, ok := obj[keyword]
+		if !ok {
+			return nil, nil
+		}
+		list, ok := raw.([]any)
+		if !ok || len(list) == 0 {
+			return nil, fail(keyword, "expected a non-empty array")
+		}
+		var schemas []*jsonSchema
+		for i, item := range list {
+			schema, err := d.compile(item, fmt.Sprintf("%s/%s/%d", pointer, keyword, i))
+			if err != nil {
+				return nil, err
+			}
+			schemas = append(schemas, schema)
+		}
+		return schemas, nil
+	}
+	number := func(keyword string) (*float64, error) {
+		raw, ok := obj[keyword]
+		if !ok {
+			return nil, nil
+		}
+		f, ok := raw.(float64)
+		if !ok {
+			return nil, fail(keyword, "expected a number")
+		}
+		return &f, nil
+	}
+	count := func(keyword string) (*int, error) {
+		f, err := number(keyword)
+		if err != nil || f == nil {
+			return nil, err
+		}
+		if *f < 0 || *f != math.Trunc(*f) {
+			return nil, fail(keyword, "expected a non-negative integer")
+		}
+		n := int(*f)
+		return &n, nil
+	}
+
+	if raw, ok := obj["$ref"]; ok {
+		if s.ref, ok = raw.(string); !ok {
+			return nil, fail("$ref", "expected a string")
+		}
+	}
+	switch raw := obj["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{raw}
+	case []any:
+		for _, t := range raw {
+			name, ok := t.(string)
+			if !ok {
+				return nil, fail("type", "expected a string or array of strings")
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fail("type", "expected a string or array of strings")
+	}
+	for _, t := range s.types {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return nil, fail("type", fmt.Sprintf("unknown type %q", t))
+		}
+	}
+	if raw, ok := obj["enum"]; ok {
+		if s.enum, ok = raw.([]any); !ok {
+			return nil, fail("enum", "expected an array")
+		}
+	}
+	s.constant, s.hasConst = obj["const"]
+
+	if s.minimum, err = number("minimum"); err != nil {
+		return nil, err
+	}
+	if s.maximum, err = number("maximum"); err != nil {
+		return nil, err
+	}
+	if s.exclusiveMinimum, err = number("exclusiveMinimum"); err != nil {
+		return nil, err
+	}
+	if s.exclusiveMaximum, err = number("exclusiveMaximum"); err != nil {
+		return nil, err
+	}
+	if s.multipleOf, err = number("multipleOf"); err != nil {
+		return nil, err
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return nil, fail("multipleOf", "expected a positive number")
+	}
+	if s.minLength, err = count("minLength"); err != nil {
+		return nil, err
+	}
+	if s.maxLength, err = count("maxLength"); err != nil {
+		return nil, err
+	}
+	if raw, ok := obj["pattern"]; ok {
+		expr, ok := raw.(string)
+		if !ok {
+			return nil, fail("pattern", "expected a string")
+		}
+		if s.pattern, err = regexp.Compile(expr); err != nil {
+			return nil, fail("pattern", err.Error())
+		}
+	}
+
+	if s.items, err = child("items"); err != nil {
+		return nil, err
+	}
+	if s.minItems, err = count("minItems"); err != nil {
+		return nil, err
+	}
+	if s.maxItems, err = count("maxItems"); err != nil {
+		return nil, err
+	}
+	if raw, ok := obj["uniqueItems"]; ok {
+		if s.uniqueItems, ok = raw.(bool); !ok {
+			return nil, fail("uniqueItems", "expected a boolean")
+		}
+	}
+
+	if raw, ok := obj["properties"]; ok {
+		props, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fail("properties", "expected an object")
+		}
+		s.properties = map[string]*jsonSchema{}
+		for name, prop := range props {
+			token := strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+			if s.properties[name], err = d.compile(prop, pointer+"/properties/"+token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if raw, ok := obj["required"]; ok {
+		list, ok := raw.([]any)
+		if !ok {
+			return nil, fail("required", "expected an array of strings")
+		}
+		for _, r := range list {
+			name, ok := r.(string)
+			if !ok {
+				return nil, fail("required", "expected an array of strings")
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	if s.additionalProperties, err = child("additionalProperties"); err != nil {
+		return nil, err
+	}
+	if s.minProperties, err = count("minProperties"); err != nil {
+		return nil, err
+	}
+	if s.maxProperties, err = count("maxProperties"); err != nil {
+		return nil, err
+	}
+
+	if s.allOf, err = children("allOf"); err != nil {
+		return nil, err
+	}
+	if s.anyOf, err = children("anyOf"); err != nil {
+		return nil, err
+	}
+	if s.oneOf, err = children("oneOf"); err != nil {
+		return nil, err
+	}
+	if s.not, err = child("not"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// JSON validates the payload and returns it unchanged, since JSON schemas only support the JSON encoding.
+func (s *jsonSchema) JSON(content string, encoding Encoding) (string, error) {
+	if err := s.Validate(content, encoding); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// Validate checks that content is a JSON document accepted by the schema.
+func (s *jsonSchema) Validate(content string, encoding Encoding) error {
+	var v any
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return &ValidationError{Reason: fmt.Sprintf("payload is not valid JSON: %v", err)}
+	}
+	return s.validate(v, "", 0)
+}
+
+// maxJSONSchemaDepth bounds how deeply validate nests, as a schema that refers to itself, directly or through $ref
+// chains that do not descend into the value, would otherwise recurse until the stack overflows.
+const maxJSONSchemaDepth = 1000
+
+var jsonSchemaDepthReason = fmt.Sprintf("schema nests deeper than %d levels", maxJSONSchemaDepth)
+
+// tooDeep reports whether err is the error validate gives up with at maxJSONSchemaDepth. It must not be taken for the
+// value failing a subschema of anyOf, oneOf or not, which would accept the value.
+func tooDeep(err error) bool {
+	var validationErr *ValidationError
+	return errors.As(err, &validationErr) && validationErr.Reason == jsonSchemaDepthReason
+}
+
+func (s *jsonSchema) validate(v any, path string, depth int) error {
+	invalid := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+	if depth > maxJSONSchemaDepth {
+		return &ValidationError{Path: path, Reason: jsonSchemaDepthReason}
+	}
+	depth++
+
+	if s.always != nil {
+		if !*s.always {
+			return invalid("no value is allowed here")
+		}
+		return nil
+	}
+	if s.ref != "" {
+		target, err := s.doc.resolve(s.ref)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		if err := target.validate(v, path, depth); err != nil {
+			return err
+		}
+	}
+
+	if len(s.types) > 0 {
+		matched := false
+		for _, t := range s.types {
+			if jsonTypeMatches(t, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return invalid("expected %s, got %s", strings.Join(s.types, " or "), jsonTypeOf(v))
+		}
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return invalid("value is not one of the allowed values")
+		}
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, v) {
+		return invalid("value does not match the constant %v", s.constant)
+	}
+
+	switch v := v.(type) {
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return invalid("%v is less than the minimum of %v", v, *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return invalid("%v is greater than the maximum of %v", v, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			return invalid("%v must be greater than %v", v, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			return invalid("%v must be less than %v", v, *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil {
+			if q := v / *s.multipleOf; q != math.Trunc(q) {
+				return invalid("%v is not a multiple of %v", v, *s.multipleOf)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			return invalid("string is shorter than %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return invalid("string is longer than %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return invalid("string does not match pattern %q", s.pattern.String())
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return invalid("array has fewer than %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return invalid("array has more than %d items", *s.maxItems)
+		}
+		if s.uniqueItems {
+			for i := range v {
+				for j := i + 1; j < len(v); j++ {
+					if reflect.DeepEqual(v[i], v[j]) {
+						return invalid("array items %d and %d are equal", i, j)
+					}
+				}
+			}
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(item, path+"/"+strconv.Itoa(i), depth); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		if s.minProperties != nil && len(v) < *s.minProperties {
+			return invalid("object has fewer than %d properties", *s.minProperties)
+		}
+		if s.maxProperties != nil && len(v) > *s.maxProperties {
+			return invalid("object has more than %d properties", *s.maxProperties)
+		}
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return invalid("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childPath := path + "/" + strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+			if prop, ok := s.properties[name]; ok {
+				if err := prop.validate(v[name], childPath, depth); err != nil {
+					return err
+				}
+			} else if s.additionalProperties != nil {
+				if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+					return invalid("property %q is not allowed", name)
+				}
+				if err := s.additionalProperties.validate(v[name], childPath, depth); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path, depth); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil {
+		matched := false
+		for _, sub := range s.anyOf {
+			err := sub.validate(v, path, depth)
+			if tooDeep(err) {
+				return err
+			}
+			if err == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return invalid("value does not match any of the allowed schemas")
+		}
+	}
+	if s.oneOf != nil {
+		matches := 0
+		for _, sub := range s.oneOf {
+			err := sub.validate(v, path, depth)
+			if tooDeep(err) {
+				return err
+			}
+			if err == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return invalid("value matches %d schemas, expected exactly one", matches)
+		}
+	}
+	if s.not != nil {
+		err := s.not.validate(v, path, depth)
+		if tooDeep(err) {
+			return err
+		}
+		if err == nil {
+			return invalid("value matches a schema it must not match")
+		}
+	}
+	return nil
+}
+
+func jsonTypeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func jsonTypeMatches(t string, v any) bool {
+	actual := jsonTypeOf(v)
+	return t == actual || (t == "number" && actual == "integer")
+}
+
+// diffJSON compares two JSON schemas keyword by keyword. Missing subschemas are treated as the empty schema, which
+// accepts anything, so adding a constraint is reported as breaking backward compatibility and removing one as
+// breaking forward compatibility.
+func (d *differ) diffJSON(older, newer *jsonSchema) {
+	(&jsonDiff{differ: d, visited: map[[2]*jsonSchema]bool{}}).compare(older, newer, "", false)
+}
+
+type jsonDiff struct {
+	*differ
+	visited map[[2]*jsonSchema]bool
+}
+
+func (d *jsonDiff) compare(older, newer *jsonSchema, path string, flip bool) {
+	older, newer = older.deref(), newer.deref()
+	if d.visited[[2]*jsonSchema{older, newer}] {
+		return
+	}
+	d.visited[[2]*jsonSchema{older, newer}] = true
+
+	// report records a change whose description is phrased from the older revision to the newer one. Inside "not"
+	// the roles are reversed, since narrowing the negated schema widens the schema that contains it.
+	report := func(description string, backward, forward bool) {
+		if flip {
+			backward, forward = forward, backward
+		}
+		d.report(path, description, backward, forward)
+	}
+
+	if older.rejectsAll() || newer.rejectsAll() {
+		if older.rejectsAll() && !newer.rejectsAll() {
+			report("values are now allowed", false, true)
+		} else if newer.rejectsAll() && !older.rejectsAll() {
+			report("values are no longer allowed", true, false)
+		}
+		return
+	}
+
+	switch {
+	case len(older.types) == 0 && len(newer.types) > 0:
+		report(fmt.Sprintf("type restricted to %s", strings.Join(newer.types, " or ")), true, false)
+	case len(older.types) > 0 && len(newer.types) == 0:
+		report(fmt.Sprintf("type restriction to %s removed", strings.Join(older.types, " or ")), false, true)
+	default:
+		for _, t := range older.types {
+			if !typesAccept(newer.types, t) {
+				report(fmt.Sprintf("type %s no longer allowed", t), true, false)
+			}
+		}
+		for _, t := range newer.types {
+			if !typesAccept(older.types, t) {
+				report(fmt.Sprintf("type %s now allowed", t), false, true)
+			}
+		}
+	}
+
+	switch {
+	case older.enum == nil && newer.enum != nil:
+		report("values restricted to an enum", true, false)
+	case older.enum != nil && newer.enum == nil:
+		report("enum restriction removed", false, true)
+	case older.enum != nil:
+		for _, v := range older.enum {
+			if !containsValue(newer.enum, v) {
+				report(fmt.Sprintf("enum value %s removed", jsonText(v)), true, false)
+			}
+		}
+		for _, v := range newer.enum {
+			if !containsValue(older.enum, v) {
+				report(fmt.Sprintf("enum value %s added", jsonText(v)), false, true)
+			}
+		}
+	}
+	switch {
+	case !older.hasConst && newer.hasConst:
+		report(fmt.Sprintf("value restricted to %s", jsonText(newer.constant)), true, false)
+	case older.hasConst && !newer.hasConst:
+		report(fmt.Sprintf("constant %s removed", jsonText(older.constant)), false, true)
+	case older.hasConst && !reflect.DeepEqual(older.constant, newer.constant):
+		report(fmt.Sprintf("constant changed from %s to %s", jsonText(older.constant), jsonText(newer.constant)), true, true)
+	}
+
+	lower := func(keyword string, o, n *float64) {
+		switch {
+		case o == nil && n != nil:
+			report(fmt.Sprintf("%s of %v added", keyword, *n), true, false)
+		case o != nil && n == nil:
+			report(fmt.Sprintf("%s of %v removed", keyword, *o), false, true)
+		case o != nil && *n > *o:
+			report(fmt.Sprintf("%s raised from %v to %v", keyword, *o, *n), true, false)
+		case o != nil && *n < *o:
+			report(fmt.Sprintf("%s lowered from %v to %v", keyword, *o, *n), false, true)
+		}
+	}
+	upper := func(keyword string, o, n *float64) {
+		switch {
+		case o == nil && n != nil:
+			report(fmt.Sprintf("%s of %v added", keyword, *n), true, false)
+		case o != nil && n == nil:
+			report(fmt.Sprintf("%s of %v removed", keyword, *o), false, true)
+		case o != nil && *n < *o:
+			report(fmt.Sprintf("%s lowered from %v to %v", keyword, *o, *n), true, false)
+		case o != nil && *n > *o:
+			report(fmt.Sprintf("%s raised from %v to %v", keyword, *o, *n), false, true)
+		}
+	}
+	lower("minimum", older.minimum, newer.minimum)
+	lower("exclusiveMinimum", older.exclusiveMinimum, newer.exclusiveMinimum)
+	upper("maximum", older.maximum, newer.maximum)
+	upper("exclusiveMaximum", older.exclusiveMaximum, newer.exclusiveMaximum)
+	lower("minLength", intBound(older.minLength), intBound(newer.minLength))
+	upper("maxLength", intBound(older.maxLength), intBound(newer.maxLength))
+	lower("minItems", intBound(older.minItems), intBound(newer.minItems))
+	upper("maxItems", intBound(older.maxItems), intBound(newer.maxItems))
+	lower("minProperties", intBound(older.minProperties), intBound(newer.minProperties))
+	upper("maxProperties", intBound(older.maxProperties), intBound(newer.maxProperties))
+
+	// multipleOf and pattern are not ordered, so any change may reject values in both directions.
+	switch o, n := older.multipleOf, newer.multipleOf; {
+	case o == nil && n != nil:
+		report(fmt.Sprintf("multipleOf %v added", *n), true, false)
+	case o != nil && n == nil:
+		report(fmt.Sprintf("multipleOf %v removed", *o), false, true)
+	case o != nil && *o != *n:
+		report(fmt.Sprintf("multipleOf changed from %v to %v", *o, *n), true, true)
+	}
+	switch o, n := older.pattern, newer.pattern; {
+	case o == nil && n != nil:
+		report(fmt.Sprintf("pattern %q added", n), true, false)
+	case o != nil && n == nil:
+		report(fmt.Sprintf("pattern %q removed", o), false, true)
+	case o != nil && o.String() != n.String():
+		report(fmt.Sprintf("pattern changed from %q to %q", o, n), true, true)
+	}
+	if !older.uniqueItems && newer.uniqueItems {
+		report("array items must now be unique", true, false)
+	} else if older.uniqueItems && !newer.uniqueItems {
+		report("array items no longer need to be unique", false, true)
+	}
+
+	if older.items != nil || newer.items != nil {
+		d.compare(older.items.orEmpty(), newer.items.orEmpty(), path+"/*", flip)
+	}
+
+	for _, name := range sortedKeys(older.properties, newer.properties) {
+		o, inOld := older.properties[name]
+		n, inNew := newer.properties[name]
+		child := path + "/" + strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+		switch {
+		case !inOld && older.additionalProperties.deref().rejectsAll():
+			d.report(child, "property added, older revision does not allow additional properties", flip, !flip)
+		case !inNew && newer.additionalProperties.deref().rejectsAll():
+			d.report(child, "property removed, newer revision does not allow additional properties", !flip, flip)
+		default:
+			if !inOld {
+				o = older.additionalProperties.orEmpty()
+			}
+			if !inNew {
+				n = newer.additionalProperties.orEmpty()
+			}
+			d.compare(o, n, child, flip)
+		}
+	}
+	if older.additionalProperties != nil || newer.additionalProperties != nil {
+		d.compare(older.additionalProperties.orEmpty(), newer.additionalProperties.orEmpty(), path+"/*", flip)
+	}
+	for _, name := range newer.required {
+		if !containsString(older.required, name) {
+			report(fmt.Sprintf("property %q is now required", name), true, false)
+		}
+	}
+	for _, name := range older.required {
+		if !containsString(newer.required, name) {
+			report(fmt.Sprintf("property %q is no longer required", name), false, true)
+		}
+	}
+
+	combinators := []struct {
+		keyword string
+		o, n    []*jsonSchema
+		// Whether an extra branch narrows the schema (allOf) or widens it (anyOf, oneOf).
+		narrows bool
+	}{
+		{"allOf", older.allOf, newer.allOf, true},
+		{"anyOf", older.anyOf, newer.anyOf, false},
+		{"oneOf", older.oneOf, newer.oneOf, false},
+	}
+	for _, c := range combinators {
+		for i := 0; i < len(c.o) && i < len(c.n); i++ {
+			d.compare(c.o[i], c.n[i], path, flip)
+		}
+		if len(c.n) > len(c.o) {
+			report(fmt.Sprintf("%d %s branch(es) added", len(c.n)-len(c.o), c.keyword), c.narrows, !c.narrows)
+		} else if len(c.o) > len(c.n) {
+			report(fmt.Sprintf("%d %s branch(es) removed", len(c.o)-len(c.n), c.keyword), !c.narrows, c.narrows)
+		}
+	}
+	switch {
+	case older.not == nil && newer.not != nil:
+		report("not constraint added", true, false)
+	case older.not != nil && newer.not == nil:
+		report("not constraint removed", false, true)
+	case older.not != nil:
+		d.compare(older.not, newer.not, path, !flip)
+	}
+}
+
+// deref follows a $ref, ignoring any sibling keywords.
+func (s *jsonSchema) deref() *jsonSchema {
+	for i := 0; s != nil && s.ref != "" && i < 32; i++ {
+		target, err := s.doc.resolve(s.ref)
+		if err != nil {
+			return s
+		}
+		s = target
+	}
+	return s
+}
+
+func (s *jsonSchema) orEmpty() *jsonSchema {
+	if s == nil {
+		return &jsonSchema{}
+	}
+	return s
+}
+
+func (s *jsonSchema) rejectsAll() bool {
+	return s != nil && s.always != nil && !*s.always
+}
+
+func intBound(n *int) *float64 {
+	if n == nil {
+		return nil
+	}
+	f := float64(*n)
+	return &f
+}
+
+func typesAccept(types []string, t string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, allowed := range types {
+		if allowed == t || (allowed == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsValue(values []any, v any) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, v string) bool {
+	for _, candidate := range values {
+		if candidate == v {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonText(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func sortedKeys(maps ...map[string]*jsonSchema) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,98 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded is matched by every *LimitError, so callers can use errors.Is without inspecting the details.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Limits bounds the size of messages published to a topic. A zero field falls back to the matching field of
+// DefaultLimits.
+type Limits struct {
+	MaxMessageBytes        int
+	MaxAttributes          int
+	MaxAttributeKeyBytes   int
+	MaxAttributeValueBytes int
+}
+
+// DefaultLimits mirrors the quotas enforced by Google Pub/Sub.
+var DefaultLimits = Limits{
+	MaxMessageBytes:        10_000_000,
+	MaxAttributes:          100,
+	MaxAttributeKeyBytes:   256,
+	MaxAttributeValueBytes: 1024,
+}
+
+// LimitError describes a message that was rejected because it exceeds one of its topic's limits.
+type LimitError struct {
+	Limit  string // Name of the violated limit, e.g. "message size"
+	Key    string // Attribute key, for attribute key and value limits
+	Max    int
+	Actual int
+}
+
+func (e *LimitError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("%s for attribute %q is %d, exceeds limit of %d", e.Limit, e.Key, e.Actual, e.Max)
+	}
+	return fmt.Sprintf("%s is %d, exceeds limit of %d", e.Limit, e.Actual, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxMessageBytes <= 0 {
+		l.MaxMessageBytes = DefaultLimits.MaxMessageBytes
+	}
+	if l.MaxAttributes <= 0 {
+		l.MaxAttributes = DefaultLimits.MaxAttributes
+	}
+	if l.MaxAttributeKeyBytes <= 0 {
+		l.MaxAttributeKeyBytes = DefaultLimits.MaxAttributeKeyBytes
+	}
+	if l.MaxAttributeValueBytes <= 0 {
+		l.MaxAttributeValueBytes = DefaultLimits.MaxAttributeValueBytes
+	}
+	return l
+}
+
+// Check returns a *LimitError if the message content or attributes exceed the limits.
+func (l Limits) Check(content string, attributes map[string]string) error {
+	l = l.withDefaults()
+	if len(content) > l.MaxMessageBytes {
+		return &LimitError{Limit: "message size", Max: l.MaxMessageBytes, Actual: len(content)}
+	}
+	if len(attributes) > l.MaxAttributes {
+		return &LimitError{Limit: "attribute count", Max: l.MaxAttributes, Actual: len(attributes)}
+	}
+	for k, v := range attributes {
+		if len(k) > l.MaxAttributeKeyBytes {
+			return &LimitError{Limit: "attribute key size", Key: k, Max: l.MaxAttributeKeyBytes, Actual: len(k)}
+		}
+		if len(v) > l.MaxAttributeValueBytes {
+			return &LimitError{Limit: "attribute value size", Key: k, Max: l.MaxAttributeValueBytes, Actual: len(v)}
+		}
+	}
+	return nil
+}
+
+// SetTopicLimits replaces the limits enforced when publishing to a topic. Zero fields restore the defaults.
+func (s *Service) SetTopicLimits(ctx context.Context, topicName string, limits Limits) error {
+	topic, err := s.GetTopic(ctx, topicName)
+	if err != nil {
+		return err
+	}
+	return setTopicLimits(ctx, s.db, topic, limits)
+}
+
+func setTopicLimits(ctx context.Context, q querier, topic *Topic, limits Limits) error {
+	_, err := q.ExecContext(ctx, `UPDATE Topics SET max_message_bytes = ?, max_attributes = ?,
+        max_attribute_key_bytes = ?, max_attribute_value_bytes = ? WHERE id = ?`,
+		limits.MaxMessageBytes, limits.MaxAttributes, limits.MaxAttributeKeyBytes, limits.MaxAttributeValueBytes, topic.ID)
+	return err
+}
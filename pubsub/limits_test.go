@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	err := s.CreateTopic(ctx, "limited", nil)
+	ok(t, err, "failed to create topic")
+	topic, err := s.GetTopic(ctx, "limited")
+	ok(t, err, "failed to get topic")
+	equals(t, Limits{}, topic.Limits, "new topic should use default limits")
+
+	err = s.SetTopicLimits(ctx, topic.Name, Limits{MaxMessageBytes: 8, MaxAttributes: 1})
+	ok(t, err, "failed to set topic limits")
+
+	err = s.PublishMessage(ctx, topic.Name, "12345678", map[string]string{"k": "v"})
+	ok(t, err, "message within limits should be accepted")
+
+	tests := []struct {
+		name       string
+		content    string
+		attributes map[string]string
+		limit      string
+	}{
+		{"content", "123456789", nil, "message size"},
+		{"attribute count", "", map[string]string{"a": "1", "b": "2"}, "attribute count"},
+		{"attribute key", "", map[string]string{strings.Repeat("k", 257): "v"}, "attribute key size"},
+		{"attribute value", "", map[string]string{"k": strings.Repeat("v", 1025)}, "attribute value size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.PublishMessage(ctx, topic.Name, tt.content, tt.attributes)
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("expected ErrLimitExceeded, got %v", err)
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("expected *LimitError, got %T", err)
+			}
+			equals(t, tt.limit, limitErr.Limit, "violated limit doesn't match expectation")
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrNotFound is matched by every *NotFoundError.
+var ErrNotFound = errors.New("not found")
+
+// NotFoundError is returned when a topic, subscription or other resource does not exist.
+type NotFoundError struct {
+	Resource string // e.g. "topic" or "subscription"
+	Name     string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %q not found", e.Resource, e.Name)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ErrAlreadyExists is matched by every *AlreadyExistsError.
+var ErrAlreadyExists = errors.New("already exists")
+
+// AlreadyExistsError is returned when creating a topic, subscription or other resource whose name is taken.
+type AlreadyExistsError struct {
+	Resource string
+	Name     string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("%s %q already exists", e.Resource, e.Name)
+}
+
+func (e *AlreadyExistsError) Is(target error) bool {
+	return target == ErrAlreadyExists
+}
+
+// alreadyExists turns the unique constraint violation raised by inserting a taken name into an *AlreadyExistsError.
+func alreadyExists(err error, resource, name string) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return &AlreadyExistsError{Resource: resource, Name: name}
+	}
+	return err
+}
+
+// DefaultProject is the project of the resources named without one, by calls whose context has no project.
+const DefaultProject = "default"
+
+type projectKey struct{}
+
+// WithProject returns a context scoping the calls it is passed to to a project, as teams sharing a database do to
+// keep their resources apart. Plain names then name resources of the project, and ListTopics, ListSnapshots and
+// ListSchemas only return its resources. Resource paths such as "projects/other/topics/orders" name their own project
+// regardless. Without a project, calls are scoped to DefaultProject.
+func WithProject(ctx context.Context, project string) context.Context {
+	return context.WithValue(ctx, projectKey{}, project)
+}
+
+// ProjectFromContext returns the project set by WithProject, and whether there is one.
+func ProjectFromContext(ctx context.Context) (string, bool) {
+	project, ok := ctx.Value(projectKey{}).(string)
+	return project, ok && project != ""
+}
+
+// contextProject returns the project calls made with ctx are scoped to.
+func contextProject(ctx context.Context) string {
+	if project, ok := ProjectFromContext(ctx); ok {
+		return project
+	}
+	return DefaultProject
+}
+
+// ResourceName returns the full resource path of a resource, e.g. "projects/my-project/topics/orders".
+func ResourceName(project, collection, name string) string {
+	return "projects/" + project + "/" + collection + "/" + name
+}
+
+// resolveName returns the project and the last segment of a resource name. Both plain names such as "orders", which
+// belong to the project of ctx, and full resource paths such as "projects/my-project/topics/orders" are accepted;
+// collection is the expected collection segment.
+func resolveName(ctx context.Context, name, collection string) (string, string, error) {
+	if !strings.Contains(name, "/") {
+		if name == "" {
+			return "", "", fmt.Errorf("empty %s name", strings.TrimSuffix(collection, "s"))
+		}
+		return contextProject(ctx), name, nil
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != collection || parts[3] == "" {
+		return "", "", fmt.Errorf("invalid resource name %q, expected projects/{project}/%s/{name}", name, collection)
+	}
+	return parts[1], parts[3], nil
+}
+
+func (s *Service) topicByName(ctx context.Context, q querier, name string) (*Topic, error) {
+	project, id, err := resolveName(ctx, name, "topics")
+	if err != nil {
+		return nil, err
+	}
+	topic, err := scanTopic(q.QueryRowContext(ctx, "SELECT "+topicColumns+" FROM Topics WHERE project = ? AND name = ?", project, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &NotFoundError{Resource: "topic", Name: name}
+	}
+	return topic, err
+}
+
+func (s *Service) subscriptionByName(ctx context.Context, q querier, name string) (*Subscription, error) {
+	project, id, err := resolveName(ctx, name, "subscriptions")
+	if err != nil {
+		return nil, err
+	}
+	subscription, err := scanSubscription(q.QueryRowContext(ctx, "SELECT "+subscriptionColumns+" FROM Subscriptions WHERE project = ? AND subscriber_id = ?", project, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &NotFoundError{Resource: "subscription", Name: name}
+	}
+	return subscription, err
+}
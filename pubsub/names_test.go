@@ -0,0 +1,149 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNames(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "projects/demo/topics/orders", nil), "failed to create topic")
+	topic, err := s.GetTopic(WithProject(ctx, "demo"), "orders")
+	ok(t, err, "failed to get topic by name")
+	equals(t, "orders", topic.Name, "topic created by path should be stored by name")
+	equals(t, "demo", topic.Project, "topic created by path should belong to its project")
+	equals(t, "projects/demo/topics/orders", topic.ResourceName(), "resource name doesn't match")
+	if _, err := s.GetTopic(ctx, "orders"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("plain names should name topics of the default project, got %v", err)
+	}
+
+	ok(t, s.CreateSubscription(ctx, "projects/demo/topics/orders", "projects/demo/subscriptions/billing", nil), "failed to create subscription")
+	subscription, err := s.GetSubscription(ctx, "projects/demo/subscriptions/billing")
+	ok(t, err, "failed to get subscription by path")
+	equals(t, topic.ID, subscription.TopicID, "subscription should belong to the topic")
+
+	ok(t, s.CreateTopic(WithProject(ctx, "demo"), "refunds", nil), "failed to create topic")
+	if err := s.CreateSubscription(WithProject(ctx, "demo"), "refunds", "billing", nil); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("subscription names should be unique across the topics of a project, got %v", err)
+	}
+	if err := s.CreateTopic(ctx, "projects/demo/topics/refunds", nil); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected creating a topic twice to fail with ErrAlreadyExists, got %v", err)
+	}
+	equals(t, "orders", subscription.Topic, "subscription should name its topic")
+	ctx = WithProject(ctx, "demo")
+
+	ok(t, s.PublishMessage(ctx, "projects/demo/topics/orders", "x", nil), "failed to publish by path")
+	messages, err := s.GetMessages(ctx, "billing")
+	ok(t, err, "failed to get messages")
+	equals(t, 1, len(messages), "message published by path should be delivered")
+
+	for _, tt := range []struct {
+		desc string
+		err  error
+	}{
+		{"missing topic", s.PublishMessage(ctx, "shipments", "x", nil)},
+		{"missing subscription", s.AcknowledgeMessage(ctx, "projects/demo/subscriptions/shipping", messages[0].ID)},
+		{"subscription on missing topic", s.CreateSubscription(ctx, "shipments", "shipping", nil)},
+	} {
+		var notFound *NotFoundError
+		if !errors.As(tt.err, &notFound) || !errors.Is(tt.err, ErrNotFound) {
+			t.Fatalf("%s: expected a not found error, got %v", tt.desc, tt.err)
+		}
+	}
+
+	if _, err := s.GetTopic(ctx, "projects/demo/subscriptions/orders"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected an invalid resource name error, got %v", err)
+	}
+}
+
+func TestProjects(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	teamA, teamB := WithProject(ctx, "team-a"), WithProject(ctx, "team-b")
+
+	// Teams sharing a database use the same names without colliding.
+	for _, ctx := range []context.Context{teamA, teamB} {
+		ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+		ok(t, s.CreateSubscription(ctx, "orders", "billing", nil), "failed to create subscription")
+		_, err := s.CreateSnapshot(ctx, "before-deploy", "billing", time.Hour)
+		ok(t, err, "failed to create snapshot")
+	}
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic in the default project")
+
+	ok(t, s.PublishMessage(teamA, "orders", "for a", nil), "failed to publish message")
+	messages, err := s.GetMessages(teamB, "billing")
+	ok(t, err, "failed to get messages")
+	equals(t, 0, len(messages), "messages should stay in their project")
+	messages, err = s.GetMessages(ctx, "projects/team-a/subscriptions/billing")
+	ok(t, err, "failed to get messages by path")
+	equals(t, 1, len(messages), "message should be delivered in its project")
+
+	topics, err := s.ListTopics(teamA)
+	ok(t, err, "failed to list topics")
+	equals(t, 1, len(topics), "listing should be scoped to the project")
+	equals(t, "team-a", topics[0].Project, "listed topic should belong to the project")
+	snapshots, err := s.ListSnapshots(teamB)
+	ok(t, err, "failed to list snapshots")
+	equals(t, 1, len(snapshots), "listing should be scoped to the project")
+	equals(t, "projects/team-b/topics/orders", snapshots[0].TopicResourceName(), "snapshot topic doesn't match")
+
+	ok(t, s.DeleteTopic(teamA, "orders"), "failed to delete topic")
+	_, err = s.GetTopic(teamB, "orders")
+	ok(t, err, "deleting a topic should leave other projects alone")
+	billing, err := s.GetSubscription(teamB, "billing")
+	ok(t, err, "failed to get subscription")
+	equals(t, false, billing.Detached, "subscriptions of other projects should stay attached")
+
+	// Forward targets are resolved in the project of the forwarding subscription.
+	ok(t, s.CreateTopic(teamB, "audit", nil), "failed to create topic")
+	forward := &SubscriptionConfig{Forward: &ForwardConfig{Topic: "audit"}}
+	ok(t, s.CreateSubscription(teamB, "orders", "to-audit", forward), "failed to create forwarding subscription")
+	if err := s.CreateSubscription(ctx, "orders", "to-audit", forward); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("forward target should be looked up in the project, got %v", err)
+	}
+}
+
+func TestMigrateProjects(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), DefaultFilename)
+
+	// Tables as created when names were unique across the database.
+	db, err := sql.Open("sqlite3", fname)
+	ok(t, err, "failed to open database")
+	for _, stmt := range []string{
+		`CREATE TABLE Topics (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE, metadata BLOB,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE Subscriptions (id INTEGER PRIMARY KEY AUTOINCREMENT, topic_id INTEGER,
+            subscriber_id TEXT NOT NULL, metadata BLOB, created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (topic_id) REFERENCES Topics(id))`,
+		"CREATE UNIQUE INDEX subscriptions_subscriber_id ON Subscriptions (subscriber_id)",
+		"INSERT INTO Topics (id, name) VALUES (7, 'orders')",
+		"INSERT INTO Subscriptions (topic_id, subscriber_id) VALUES (7, 'billing')",
+	} {
+		_, err := db.ExecContext(ctx, stmt)
+		ok(t, err, "failed to create old tables")
+	}
+	ok(t, db.Close(), "failed to close database")
+
+	s, err := NewService(fname)
+	ok(t, err, "failed to create service")
+	defer s.Close()
+	ok(t, s.Init(ctx), "failed to initialize service")
+
+	topic, err := s.GetTopic(ctx, "projects/default/topics/orders")
+	ok(t, err, "existing topics should move to the default project")
+	equals(t, 7, topic.ID, "topic should keep its ID")
+	billing, err := s.GetSubscription(ctx, "billing")
+	ok(t, err, "existing subscriptions should move to the default project")
+	equals(t, topic.ID, billing.TopicID, "subscription should keep its topic")
+
+	demo := WithProject(ctx, "demo")
+	ok(t, s.CreateTopic(demo, "orders", nil), "names should be unique per project after the migration")
+	ok(t, s.CreateSubscription(demo, "orders", "billing", nil), "names should be unique per project after the migration")
+}
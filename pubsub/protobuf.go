@@ -0,0 +1,168 @@
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoSchema validates payloads against a message type from a compiled FileDescriptorSet, as produced by
+// `protoc --include_imports --descriptor_set_out`.
+type protoSchema struct {
+	message protoreflect.MessageDescriptor
+}
+
+func compileProtoSchema(definition, messageType string) (*protoSchema, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal([]byte(definition), &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+
+	if messageType == "" {
+		var candidates []protoreflect.MessageDescriptor
+		for _, file := range set.GetFile() {
+			desc, err := files.FindFileByPath(file.GetName())
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < desc.Messages().Len(); i++ {
+				candidates = append(candidates, desc.Messages().Get(i))
+			}
+		}
+		if len(candidates) != 1 {
+			return nil, fmt.Errorf("descriptor set declares %d messages, a message type is required", len(candidates))
+		}
+		return &protoSchema{message: candidates[0]}, nil
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(messageType))
+	if err != nil {
+		return nil, fmt.Errorf("message type %q not found in descriptor set", messageType)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a message type", messageType)
+	}
+	return &protoSchema{message: message}, nil
+}
+
+func (s *protoSchema) decode(content string, encoding Encoding) (*dynamicpb.Message, error) {
+	message := dynamicpb.NewMessage(s.message)
+	var err error
+	if encoding == EncodingBinary {
+		err = proto.Unmarshal([]byte(content), message)
+	} else {
+		err = protojson.Unmarshal([]byte(content), message)
+	}
+	if err != nil {
+		return nil, &ValidationError{Reason: fmt.Sprintf("payload is not a valid %s: %v", s.message.FullName(), err)}
+	}
+	if err := proto.CheckInitialized(message); err != nil {
+		return nil, &ValidationError{Reason: err.Error()}
+	}
+	return message, nil
+}
+
+func (s *protoSchema) Validate(content string, encoding Encoding) error {
+	_, err := s.decode(content, encoding)
+	return err
+}
+
+func (s *protoSchema) JSON(content string, encoding Encoding) (string, error) {
+	message, err := s.decode(content, encoding)
+	if err != nil {
+		return "", err
+	}
+	b, err := protojson.Marshal(message)
+	return string(b), err
+}
+
+// protoKindGroups lists the scalar kinds that can be exchanged without changing either the wire or the JSON encoding.
+var protoKindGroups = map[protoreflect.Kind]int{
+	protoreflect.Int32Kind: 1, protoreflect.Uint32Kind: 1, protoreflect.Int64Kind: 1, protoreflect.Uint64Kind: 1,
+	protoreflect.Sint32Kind: 2, protoreflect.Sint64Kind: 2,
+	protoreflect.Fixed32Kind: 3, protoreflect.Sfixed32Kind: 3,
+	protoreflect.Fixed64Kind: 4, protoreflect.Sfixed64Kind: 4,
+}
+
+// diffProto compares two message types field by field, matching fields by number. Since payloads may use the JSON
+// encoding, which rejects unknown fields and identifies fields by name, removing, adding or renaming a field is
+// reported as well as changes that break the binary encoding.
+func (d *differ) diffProto(older, newer protoreflect.MessageDescriptor, path string, visited map[string]bool) {
+	key := string(older.FullName()) + "\x00" + string(newer.FullName()) + "\x00" + path
+	if visited[key] {
+		return
+	}
+	visited[key] = true
+
+	numbers := map[protoreflect.FieldNumber]bool{}
+	for _, fields := range []protoreflect.FieldDescriptors{older.Fields(), newer.Fields()} {
+		for i := 0; i < fields.Len(); i++ {
+			numbers[fields.Get(i).Number()] = true
+		}
+	}
+	sorted := make([]protoreflect.FieldNumber, 0, len(numbers))
+	for n := range numbers {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for _, number := range sorted {
+		o, n := older.Fields().ByNumber(number), newer.Fields().ByNumber(number)
+		switch {
+		case n == nil:
+			d.report(path+"/"+string(o.Name()), fmt.Sprintf("field %d removed", number), true, o.Cardinality() == protoreflect.Required)
+			continue
+		case o == nil:
+			d.report(path+"/"+string(n.Name()), fmt.Sprintf("field %d added", number), n.Cardinality() == protoreflect.Required, true)
+			continue
+		}
+
+		child := path + "/" + string(n.Name())
+		if o.Name() != n.Name() {
+			d.report(child, fmt.Sprintf("field %d renamed from %s to %s", number, o.Name(), n.Name()), true, true)
+		}
+		if o.IsList() != n.IsList() || o.IsMap() != n.IsMap() {
+			d.report(child, fmt.Sprintf("field %d changed between singular, repeated and map", number), true, true)
+			continue
+		}
+		if o.Cardinality() != protoreflect.Required && n.Cardinality() == protoreflect.Required {
+			d.report(child, fmt.Sprintf("field %d is now required", number), true, false)
+		} else if o.Cardinality() == protoreflect.Required && n.Cardinality() != protoreflect.Required {
+			d.report(child, fmt.Sprintf("field %d is no longer required", number), false, true)
+		}
+
+		if o.Kind() != n.Kind() {
+			if group, ok := protoKindGroups[o.Kind()]; !ok || group != protoKindGroups[n.Kind()] {
+				d.report(child, fmt.Sprintf("type of field %d changed from %s to %s", number, o.Kind(), n.Kind()), true, true)
+				continue
+			}
+		}
+		switch o.Kind() {
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			d.diffProto(o.Message(), n.Message(), child, visited)
+		case protoreflect.EnumKind:
+			ov, nv := o.Enum().Values(), n.Enum().Values()
+			for i := 0; i < ov.Len(); i++ {
+				if v := ov.Get(i); nv.ByNumber(v.Number()) == nil || nv.ByName(v.Name()) == nil {
+					d.report(child, fmt.Sprintf("enum value %s removed", v.Name()), true, false)
+				}
+			}
+			for i := 0; i < nv.Len(); i++ {
+				if v := nv.Get(i); ov.ByNumber(v.Number()) == nil || ov.ByName(v.Name()) == nil {
+					d.report(child, fmt.Sprintf("enum value %s added", v.Name()), false, true)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PurgeOptions selects which outstanding messages a purge drops and how.
+type PurgeOptions struct {
+	// Before limits the purge to messages published before this time. The zero time purges regardless of age.
+	Before time.Time
+	// Filter limits the purge to messages whose attributes match, using the subscription filter syntax.
+	Filter string
+	// Delete deletes the messages instead of acknowledging them, so they cannot be replayed with Seek.
+	Delete bool
+	// DryRun counts the messages that would be purged without changing them.
+	DryRun bool
+}
+
+// Purge drops the outstanding messages of a subscription, leased or not, by acknowledging or deleting them. It returns
+// the number of messages purged, or that would be purged on a dry run.
+func (s *Service) Purge(ctx context.Context, subscriptionName string, opts PurgeOptions) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := s.subscriptionByName(ctx, tx, subscriptionName)
+	if err != nil {
+		return 0, err
+	}
+	n, err := purge(ctx, tx, "subscription_id = ?", subscription.ID, opts)
+	if err != nil || opts.DryRun {
+		return n, err
+	}
+	return n, tx.Commit()
+}
+
+// PurgeTopic purges the outstanding messages of every subscription of a topic, see Purge.
+func (s *Service) PurgeTopic(ctx context.Context, topicName string, opts PurgeOptions) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	topic, err := s.topicByName(ctx, tx, topicName)
+	if err != nil {
+		return 0, err
+	}
+	n, err := purge(ctx, tx, "topic_id = ?", topic.ID, opts)
+	if err != nil || opts.DryRun {
+		return n, err
+	}
+	return n, tx.Commit()
+}
+
+// purge acknowledges or deletes the outstanding messages matching where and the options.
+func purge(ctx context.Context, tx *sql.Tx, where string, id int, opts PurgeOptions) (int, error) {
+	f, err := compileFilter(opts.Filter)
+	if err != nil {
+		return 0, &ConfigError{Field: "filter", Reason: err.Error()}
+	}
+
+	query := "SELECT id, metadata FROM Messages WHERE " + where + " AND acknowledged = 0"
+	args := []any{id}
+	if !opts.Before.IsZero() {
+		query += " AND published_at < ?"
+		args = append(args, dbTime(opts.Before))
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	var ids []any
+	for rows.Next() {
+		var messageID int
+		var metadata []byte
+		if err := rows.Scan(&messageID, &metadata); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if f != nil {
+			attributes, err := decodeAttributes(metadata)
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if !f.match(attributes) {
+				continue
+			}
+		}
+		ids = append(ids, messageID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if opts.DryRun {
+		return len(ids), nil
+	}
+
+	stmt := "UPDATE Messages SET acknowledged = 1, ack_deadline = NULL"
+	if opts.Delete {
+		stmt = "DELETE FROM Messages"
+	}
+	// Stay well below SQLite's limit on the number of bound parameters.
+	const batch = 500
+	for start := 0; start < len(ids); start += batch {
+		end := min(start+batch, len(ids))
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", end-start), ", ")
+		if _, err := tx.ExecContext(ctx, stmt+" WHERE id IN ("+placeholders+")", ids[start:end]...); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,62 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", &SubscriptionConfig{RetainAckedMessages: true}), "failed to create subscription")
+	ok(t, s.CreateSubscription(ctx, "orders", "audit", nil), "failed to create subscription")
+
+	ok(t, s.PublishMessage(ctx, "orders", "old test", map[string]string{"test": "true"}), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "orders", "old", nil), "failed to publish message")
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	ok(t, s.PublishMessage(ctx, "orders", "new test", map[string]string{"test": "true"}), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "orders", "new", nil), "failed to publish message")
+
+	n, err := s.Purge(ctx, "billing", PurgeOptions{Before: cutoff, DryRun: true})
+	ok(t, err, "failed to purge")
+	equals(t, 2, n, "dry run should count messages before the cutoff")
+	messages, err := s.PullMessages(ctx, "billing", time.Now().Add(time.Hour))
+	ok(t, err, "failed to pull messages")
+	equals(t, 4, len(messages), "dry run should not change messages")
+
+	// Leased messages are outstanding too.
+	n, err = s.Purge(ctx, "billing", PurgeOptions{Filter: "attributes:test"})
+	ok(t, err, "failed to purge")
+	equals(t, 2, n, "purge should be limited to matching messages")
+	n, err = s.Purge(ctx, "billing", PurgeOptions{Before: cutoff})
+	ok(t, err, "failed to purge")
+	equals(t, 1, n, "purge should be limited to messages before the cutoff")
+
+	// Acknowledged messages are retained and can be replayed, unlike deleted ones.
+	result, err := s.Seek(ctx, "billing", time.Time{})
+	ok(t, err, "failed to seek")
+	equals(t, 4, result.Redelivered, "purged messages should be acknowledged")
+	n, err = s.Purge(ctx, "billing", PurgeOptions{Delete: true})
+	ok(t, err, "failed to purge")
+	equals(t, 4, n, "every outstanding message should be purged")
+	messages, err = s.GetMessages(ctx, "billing")
+	ok(t, err, "failed to get messages")
+	equals(t, 0, len(messages), "purged messages should be deleted")
+
+	n, err = s.PurgeTopic(ctx, "orders", PurgeOptions{})
+	ok(t, err, "failed to purge topic")
+	equals(t, 4, n, "topic purge should cover every subscription")
+	messages, err = s.PullMessages(ctx, "audit", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 0, len(messages), "purged messages should not be delivered")
+
+	if _, err := s.Purge(ctx, "audit", PurgeOptions{Filter: "attributes.test ="}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected an invalid filter to be rejected, got %v", err)
+	}
+}
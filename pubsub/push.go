@@ -0,0 +1,29 @@
+package pubsub
+
+import "net/url"
+
+// PushConfig makes a subscription push its messages to an HTTP endpoint instead of waiting for them to be pulled.
+// Pushing is done by a long-running server (pubsub serve), which POSTs each message to the endpoint in the Google
+// Pub/Sub push format, or as a CloudEvent, and acknowledges it when the endpoint answers with a success status.
+type PushConfig struct {
+	// Endpoint is the absolute http or https URL messages are pushed to.
+	Endpoint string `json:"pushEndpoint" yaml:"pushEndpoint"`
+	// CloudEvents pushes messages as CloudEvents in the binary or structured mode instead, see MessageCloudEvent.
+	CloudEvents CloudEventsMode `json:"cloudEvents,omitempty" yaml:"cloudEvents,omitempty"`
+}
+
+func (p *PushConfig) validate() []error {
+	if p.Endpoint == "" {
+		return []error{&ConfigError{Field: "pushConfig.pushEndpoint", Reason: "an endpoint is required"}}
+	}
+	u, err := url.Parse(p.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return []error{&ConfigError{Field: "pushConfig.pushEndpoint", Reason: "must be an absolute http or https URL"}}
+	}
+	switch p.CloudEvents {
+	case "", CloudEventsBinary, CloudEventsStructured:
+	default:
+		return []error{&ConfigError{Field: "pushConfig.cloudEvents", Reason: "must be binary or structured"}}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPushConfig(t *testing.T) {
+	config, err := ParseSubscriptionConfig([]byte("pushConfig:\n  pushEndpoint: https://example.com/push\n"))
+	ok(t, err, "failed to parse push config")
+	equals(t, "https://example.com/push", config.PushConfig.Endpoint, "push endpoint doesn't match")
+
+	for _, yaml := range []string{
+		"pushConfig: {}",
+		"pushConfig: {pushEndpoint: /push}",
+		"pushConfig: {pushEndpoint: 'ftp://example.com'}",
+	} {
+		if _, err := ParseSubscriptionConfig([]byte(yaml)); !hasConfigError(err, "pushConfig.pushEndpoint") {
+			t.Errorf("expected %q to be rejected, got %v", yaml, err)
+		}
+	}
+	_, err = ParseSubscriptionConfig([]byte("pushConfig: {pushEndpoint: 'http://localhost:8080', cloudEvents: json}"))
+	if !hasConfigError(err, "pushConfig.cloudEvents") {
+		t.Errorf("expected an unknown CloudEvents mode to be rejected, got %v", err)
+	}
+	_, err = ParseSubscriptionConfig([]byte("pushConfig: {pushEndpoint: 'http://localhost:8080'}\nforward: {topic: audit}"))
+	if !hasConfigError(err, "pushConfig") {
+		t.Errorf("expected forwarding subscriptions to be unable to push, got %v", err)
+	}
+
+	ctx := context.Background()
+	s := newTestService(t)
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", nil), "failed to create subscription")
+	subscription, err := s.UpdateSubscription(ctx, "billing", config, []string{"pushConfig"})
+	ok(t, err, "failed to update subscription")
+	equals(t, "https://example.com/push", subscription.Config.PushConfig.Endpoint, "update should set the push endpoint")
+
+	// Each push field can be updated alone, keeping the other.
+	update := &SubscriptionConfig{PushConfig: &PushConfig{CloudEvents: CloudEventsStructured}}
+	subscription, err = s.UpdateSubscription(ctx, "billing", update, []string{"pushConfig.cloudEvents"})
+	ok(t, err, "failed to update subscription")
+	equals(t, CloudEventsStructured, subscription.Config.PushConfig.CloudEvents, "update should set the CloudEvents mode")
+	equals(t, "https://example.com/push", subscription.Config.PushConfig.Endpoint, "update should keep the push endpoint")
+	update = &SubscriptionConfig{PushConfig: &PushConfig{Endpoint: "https://example.com/v2/push"}}
+	subscription, err = s.UpdateSubscription(ctx, "billing", update, []string{"pushConfig.pushEndpoint"})
+	ok(t, err, "failed to update subscription")
+	equals(t, "https://example.com/v2/push", subscription.Config.PushConfig.Endpoint, "update should set the push endpoint")
+	equals(t, CloudEventsStructured, subscription.Config.PushConfig.CloudEvents, "update should keep the CloudEvents mode")
+
+	subscription, err = s.UpdateSubscription(ctx, "billing", &SubscriptionConfig{}, []string{"pushConfig"})
+	ok(t, err, "failed to update subscription")
+	if subscription.Config.PushConfig != nil {
+		t.Fatalf("expected an empty push config to turn the subscription back into a pull subscription")
+	}
+}
@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Topics with a MessageRetentionDuration keep a copy of every published message in TopicMessages, independently of
+// their subscriptions. The copy's id is the message's publish ID, which every subscription row of the message records
+// in publish_id, so that backfilling never delivers the same message twice.
+
+// retainMessage stores a published message in the topic's history when the topic retains messages.
+func retainMessage(ctx context.Context, tx *sql.Tx, topic *Topic, publishID int64, stored any, size, storedSize int, metadata []byte, publishedAt string) error {
+	if topic.Config.MessageRetentionDuration == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO TopicMessages (id, topic_id, content, compression, size, stored_size, metadata, published_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		publishID, topic.ID, stored, topic.Compression, size, storedSize, metadata, publishedAt)
+	return err
+}
+
+// Backfill delivers the messages retained by a subscription's topic that were published at or after since, and that
+// the subscription does not already have. A zero since backfills from the beginning of the topic's retention. Messages
+// that do not pass the subscription's filter are skipped, and forwarding subscriptions are never backfilled. It returns
+// the number of messages added.
+func (s *Service) Backfill(ctx context.Context, subscriptionName string, since time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := s.subscriptionByName(ctx, tx, subscriptionName)
+	if err != nil {
+		return 0, err
+	}
+	if subscription.Detached {
+		return 0, fmt.Errorf("cannot backfill subscription %q: %w", subscriptionName, ErrDetached)
+	}
+	n, err := backfill(ctx, tx, subscription, since, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// backfill copies the retained messages of the subscription's topic published at or after since, and still within
+// the topic's retention at now, into the subscription.
+func backfill(ctx context.Context, tx *sql.Tx, subscription *Subscription, since, now time.Time) (int, error) {
+	topic, err := scanTopic(tx.QueryRowContext(ctx, "SELECT "+topicColumns+" FROM Topics WHERE id = ?", subscription.TopicID))
+	if err != nil {
+		return 0, err
+	}
+	retention := topic.Config.MessageRetentionDuration
+	if retention == 0 || subscription.Config.Forward != nil {
+		return 0, nil
+	}
+	if start := now.Add(-retention); since.Before(start) {
+		since = start
+	}
+	f, err := compileFilter(subscription.Config.Filter)
+	if err != nil {
+		return 0, err
+	}
+
+	type retained struct {
+		id          int64
+		content     []byte
+		compression Compression
+		size        sql.NullInt64
+		storedSize  sql.NullInt64
+		metadata    []byte
+		publishedAt time.Time
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, content, compression, size, stored_size, metadata, published_at FROM TopicMessages tm
+        WHERE topic_id = ? AND published_at >= ?
+        AND NOT EXISTS (SELECT 1 FROM Messages m WHERE m.subscription_id = ? AND m.publish_id = tm.id)
+        ORDER BY id`,
+		topic.ID, dbTime(since), subscription.ID)
+	if err != nil {
+		return 0, err
+	}
+	var messages []retained
+	for rows.Next() {
+		var m retained
+		if err := rows.Scan(&m.id, &m.content, &m.compression, &m.size, &m.storedSize, &m.metadata, &m.publishedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var added int
+	for _, m := range messages {
+		if f != nil {
+			attributes, err := decodeAttributes(m.metadata)
+			if err != nil {
+				return 0, err
+			}
+			if !f.match(attributes) {
+				continue
+			}
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO Messages (topic_id, subscription_id, publish_id, content, compression, size, stored_size, metadata, published_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			topic.ID, subscription.ID, m.id, m.content, m.compression, m.size, m.storedSize, m.metadata, dbTime(m.publishedAt))
+		if err != nil {
+			return 0, err
+		}
+		added++
+	}
+	return added, nil
+}
@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", &TopicConfig{MessageRetentionDuration: time.Hour}), "failed to create topic")
+	ok(t, s.CreateTopic(ctx, "events", nil), "failed to create topic")
+	ok(t, s.PublishMessage(ctx, "orders", "first", map[string]string{"region": "us"}), "failed to publish message")
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	ok(t, s.PublishMessage(ctx, "orders", "second", map[string]string{"region": "eu"}), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "events", "unretained", nil), "failed to publish message")
+
+	// Subscriptions created after publishing start empty, until backfilled.
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", nil), "failed to create subscription")
+	messages, err := s.GetMessages(ctx, "billing")
+	ok(t, err, "failed to get messages")
+	equals(t, 0, len(messages), "new subscription should start empty")
+
+	n, err := s.Backfill(ctx, "billing", time.Time{})
+	ok(t, err, "failed to backfill")
+	equals(t, 2, n, "every retained message should be backfilled")
+	n, err = s.Backfill(ctx, "billing", time.Time{})
+	ok(t, err, "failed to backfill")
+	equals(t, 0, n, "backfilling again should not duplicate messages")
+
+	ok(t, s.CreateSubscription(ctx, "orders", "recent", nil), "failed to create subscription")
+	n, err = s.Backfill(ctx, "recent", since)
+	ok(t, err, "failed to backfill")
+	equals(t, 1, n, "only messages since the start time should be backfilled")
+
+	ok(t, s.CreateSubscription(ctx, "orders", "us", &SubscriptionConfig{Filter: `attributes.region = "us"`}), "failed to create subscription")
+	ok(t, s.PublishMessage(ctx, "orders", "third", map[string]string{"region": "us"}), "failed to publish message")
+	n, err = s.Backfill(ctx, "us", time.Time{})
+	ok(t, err, "failed to backfill")
+	equals(t, 1, n, "backfill should apply the subscription's filter and skip delivered messages")
+	messages, err = s.PullMessages(ctx, "us", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 2, len(messages), "expected the backfilled and the published message")
+	equals(t, "first", messages[1].Content, "backfilled messages should follow those already delivered")
+
+	ok(t, s.CreateSubscription(ctx, "events", "audit", nil), "failed to create subscription")
+	n, err = s.Backfill(ctx, "audit", time.Time{})
+	ok(t, err, "failed to backfill")
+	equals(t, 0, n, "topics without retention have no history")
+
+	// Seeking also delivers retained messages the subscription never received.
+	ok(t, s.CreateSubscription(ctx, "orders", "replay", nil), "failed to create subscription")
+	result, err := s.Seek(ctx, "replay", since)
+	ok(t, err, "failed to seek")
+	equals(t, 2, result.Redelivered, "seek should backfill messages since the seek time")
+
+	// Retained messages are kept until the topic's retention passes, even once every subscription deleted them.
+	deleted, err := s.GC(ctx, time.Now().Add(2*time.Hour))
+	ok(t, err, "failed to collect garbage")
+	equals(t, 3, deleted, "retained messages past the topic's retention should be deleted")
+	n, err = s.Backfill(ctx, "billing", time.Time{})
+	ok(t, err, "failed to backfill")
+	equals(t, 0, n, "expired messages should not be backfilled")
+}
@@ -0,0 +1,526 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSchemaValidation is matched by every *ValidationError.
+var ErrSchemaValidation = errors.New("schema validation failed")
+
+// ErrSchemaInUse is returned when deleting a schema that topics are still bound to.
+var ErrSchemaInUse = errors.New("schema is in use")
+
+type SchemaType string
+
+const (
+	SchemaTypeJSON           SchemaType = "JSON"
+	SchemaTypeProtocolBuffer SchemaType = "PROTOCOL_BUFFER"
+	SchemaTypeAvro           SchemaType = "AVRO"
+)
+
+// ParseSchemaType accepts a schema type name in any case.
+func ParseSchemaType(s string) (SchemaType, error) {
+	switch strings.ToUpper(s) {
+	case "JSON", "JSON_SCHEMA", "JSONSCHEMA":
+		return SchemaTypeJSON, nil
+	case "PROTOCOL_BUFFER", "PROTOBUF", "PROTO":
+		return SchemaTypeProtocolBuffer, nil
+	case "AVRO":
+		return SchemaTypeAvro, nil
+	}
+	return "", fmt.Errorf("unknown schema type %q", s)
+}
+
+// Encoding is the wire format of messages published to a topic with a schema.
+type Encoding string
+
+const (
+	EncodingJSON   Encoding = "JSON"
+	EncodingBinary Encoding = "BINARY"
+)
+
+// ParseEncoding accepts an encoding name in any case.
+func ParseEncoding(s string) (Encoding, error) {
+	switch strings.ToUpper(s) {
+	case "JSON":
+		return EncodingJSON, nil
+	case "BINARY":
+		return EncodingBinary, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q", s)
+}
+
+// Compatibility selects which compatibility checks a new schema revision must pass against every existing revision.
+type Compatibility string
+
+const (
+	CompatibilityNone     Compatibility = "NONE"
+	CompatibilityBackward Compatibility = "BACKWARD" // Data written with older revisions is valid under the new one
+	CompatibilityForward  Compatibility = "FORWARD"  // Data written with the new revision is valid under older ones
+	CompatibilityFull     Compatibility = "FULL"     // Both backward and forward
+)
+
+// ParseCompatibility accepts a compatibility mode in any case.
+func ParseCompatibility(s string) (Compatibility, error) {
+	switch c := Compatibility(strings.ToUpper(s)); c {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compatibility mode %q", s)
+}
+
+// Schema is one revision of a schema. Revisions are numbered from 1 and the definition of a revision never changes.
+type Schema struct {
+	ID      int
+	Project string
+	Name    string
+	Type    SchemaType
+	// Definition is a JSON Schema or Avro schema document, or a serialized FileDescriptorSet for protocol buffers.
+	Definition string
+	// MessageType is the fully-qualified name of the protocol buffer message within the descriptor set. It may be
+	// omitted when the set declares exactly one message.
+	MessageType   string
+	Revision      int
+	Compatibility Compatibility // Defaults to CompatibilityBackward
+	CreatedAt     time.Time     // When this revision was committed
+}
+
+// SchemaSettings binds a topic to a schema that every published message must satisfy. Messages are accepted if they
+// are valid under any revision from FirstRevision to LastRevision; zero leaves that end of the range open.
+type SchemaSettings struct {
+	Schema        string
+	Encoding      Encoding // Defaults to EncodingJSON
+	FirstRevision int
+	LastRevision  int
+}
+
+// ValidationError describes a message payload that does not satisfy its topic's schema.
+type ValidationError struct {
+	Schema   string
+	Revision int
+	Path     string // JSON Pointer to the offending value, empty for the document root
+	Reason   string
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("message does not match schema %q revision %d at %s: %s", e.Schema, e.Revision, path, e.Reason)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrSchemaValidation
+}
+
+// codec validates payloads against a compiled schema and renders them as JSON.
+type codec interface {
+	Validate(content string, encoding Encoding) error
+	JSON(content string, encoding Encoding) (string, error)
+}
+
+func compileSchema(schema *Schema) (codec, error) {
+	switch schema.Type {
+	case SchemaTypeJSON:
+		return compileJSONSchema(schema.Definition)
+	case SchemaTypeProtocolBuffer:
+		return compileProtoSchema(schema.Definition, schema.MessageType)
+	case SchemaTypeAvro:
+		return compileAvroSchema(schema.Definition)
+	}
+	return nil, fmt.Errorf("unknown schema type %q", schema.Type)
+}
+
+// codecs caches compiled schema revisions. Revisions never change once committed, so entries only need to be dropped
+// when a schema is deleted.
+type codecs struct {
+	mu    sync.Mutex
+	cache map[codecKey]codec
+}
+
+type codecKey struct{ schemaID, revision int }
+
+func (c *codecs) get(schema *Schema) (codec, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := codecKey{schema.ID, schema.Revision}
+	if compiled, ok := c.cache[key]; ok {
+		return compiled, nil
+	}
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	if c.cache == nil {
+		c.cache = map[codecKey]codec{}
+	}
+	c.cache[key] = compiled
+	return compiled, nil
+}
+
+func (c *codecs) forget(schemaID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.cache {
+		if key.schemaID == schemaID {
+			delete(c.cache, key)
+		}
+	}
+}
+
+// storedDefinition returns the value bound for a definition column. Descriptor sets are binary, so they are stored as
+// a blob rather than text.
+func storedDefinition(schema *Schema) any {
+	if schema.Type == SchemaTypeProtocolBuffer {
+		return []byte(schema.Definition)
+	}
+	return schema.Definition
+}
+
+// CreateSchema registers a schema as revision 1 after checking that its definition compiles.
+func (s *Service) CreateSchema(ctx context.Context, schema *Schema) error {
+	compatibility := CompatibilityBackward
+	if schema.Compatibility != "" {
+		var err error
+		if compatibility, err = ParseCompatibility(string(schema.Compatibility)); err != nil {
+			return err
+		}
+	}
+	project, id, err := resolveName(ctx, schema.Name, "schemas")
+	if err != nil {
+		return err
+	}
+	if _, err := compileSchema(schema); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO Schemas (project, name, type, definition, message_type, revision, compatibility) VALUES (?, ?, ?, ?, ?, 1, ?)",
+		project, id, schema.Type, storedDefinition(schema), schema.MessageType, compatibility)
+	if err != nil {
+		return alreadyExists(err, "schema", schema.Name)
+	}
+	schemaID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO SchemaRevisions (schema_id, revision, definition, message_type) VALUES (?, 1, ?, ?)",
+		schemaID, storedDefinition(schema), schema.MessageType)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CommitSchema adds a new revision to an existing schema. The revision must compile and pass the schema's
+// compatibility checks against every existing revision, otherwise a *CompatibilityError lists the offending changes.
+func (s *Service) CommitSchema(ctx context.Context, name, definition, messageType string) (*Schema, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	revisions, err := s.schemaRevisions(ctx, tx, name, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	latest := revisions[0]
+	candidate := &Schema{
+		ID:            latest.ID,
+		Project:       latest.Project,
+		Name:          latest.Name,
+		Type:          latest.Type,
+		Definition:    definition,
+		MessageType:   messageType,
+		Revision:      latest.Revision + 1,
+		Compatibility: latest.Compatibility,
+	}
+	if _, err := compileSchema(candidate); err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		changes, err := DiffSchemas(revision, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if incompatible := candidate.Compatibility.Incompatible(changes); len(incompatible) > 0 {
+			return nil, &CompatibilityError{Schema: name, Revision: revision.Revision, Compatibility: candidate.Compatibility, Changes: incompatible}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO SchemaRevisions (schema_id, revision, definition, message_type) VALUES (?, ?, ?, ?)",
+		candidate.ID, candidate.Revision, storedDefinition(candidate), candidate.MessageType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE Schemas SET definition = ?, message_type = ?, revision = ? WHERE id = ?",
+		storedDefinition(candidate), candidate.MessageType, candidate.Revision, candidate.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetSchemaRevision(ctx, name, candidate.Revision)
+}
+
+const schemaRevisionQuery = `SELECT s.id, s.project, s.name, s.type, r.definition, r.message_type, r.revision, s.compatibility, r.created_at
+    FROM Schemas s JOIN SchemaRevisions r ON r.schema_id = s.id`
+
+func scanSchema(row interface{ Scan(...any) error }) (*Schema, error) {
+	schema := &Schema{}
+	err := row.Scan(&schema.ID, &schema.Project, &schema.Name, &schema.Type, &schema.Definition, &schema.MessageType, &schema.Revision,
+		&schema.Compatibility, &schema.CreatedAt)
+	return schema, err
+}
+
+// ResourceName returns the full resource path of the schema, e.g. "projects/my-project/schemas/order".
+func (s *Schema) ResourceName() string {
+	return ResourceName(s.Project, "schemas", s.Name)
+}
+
+// GetSchema returns the latest revision of a schema.
+func (s *Service) GetSchema(ctx context.Context, name string) (*Schema, error) {
+	return s.GetSchemaRevision(ctx, name, 0)
+}
+
+// GetSchemaRevision returns a specific revision of a schema, or the latest when revision is zero.
+func (s *Service) GetSchemaRevision(ctx context.Context, name string, revision int) (*Schema, error) {
+	return schemaRevision(ctx, s.db, name, revision)
+}
+
+func schemaRevision(ctx context.Context, q querier, name string, revision int) (*Schema, error) {
+	project, id, err := resolveName(ctx, name, "schemas")
+	if err != nil {
+		return nil, err
+	}
+	var row *sql.Row
+	if revision == 0 {
+		row = q.QueryRowContext(ctx, schemaRevisionQuery+" WHERE s.project = ? AND s.name = ? AND r.revision = s.revision", project, id)
+	} else {
+		row = q.QueryRowContext(ctx, schemaRevisionQuery+" WHERE s.project = ? AND s.name = ? AND r.revision = ?", project, id, revision)
+	}
+	schema, err := scanSchema(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		if revision == 0 {
+			return nil, &NotFoundError{Resource: "schema", Name: name}
+		}
+		return nil, &NotFoundError{Resource: "schema revision", Name: fmt.Sprintf("%s@%d", name, revision)}
+	}
+	return schema, err
+}
+
+// ListSchemaRevisions returns every revision of a schema, newest first.
+func (s *Service) ListSchemaRevisions(ctx context.Context, name string) ([]*Schema, error) {
+	return s.schemaRevisions(ctx, s.db, name, 0, 0)
+}
+
+// schemaRevisions returns the revisions of a schema between first and last inclusive, newest first. Zero leaves that
+// end of the range open.
+func (s *Service) schemaRevisions(ctx context.Context, q querier, name string, first, last int) ([]*Schema, error) {
+	project, id, err := resolveName(ctx, name, "schemas")
+	if err != nil {
+		return nil, err
+	}
+	query := schemaRevisionQuery + " WHERE s.project = ? AND s.name = ? AND r.revision >= ?"
+	args := []any{project, id, first}
+	if last > 0 {
+		query += " AND r.revision <= ?"
+		args = append(args, last)
+	}
+	rows, err := q.QueryContext(ctx, query+" ORDER BY r.revision DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*Schema
+	for rows.Next() {
+		schema, err := scanSchema(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, schema)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return nil, fmt.Errorf("schema %q has no revisions in range", name)
+	}
+	return revisions, nil
+}
+
+// ListSchemas returns the latest revision of every schema of the project.
+func (s *Service) ListSchemas(ctx context.Context) ([]*Schema, error) {
+	rows, err := s.db.QueryContext(ctx, schemaRevisionQuery+" WHERE s.project = ? AND r.revision = s.revision ORDER BY s.name", contextProject(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []*Schema
+	for rows.Next() {
+		schema, err := scanSchema(rows)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schemas, nil
+}
+
+// DeleteSchema removes a schema. Schemas that are still bound to a topic cannot be deleted.
+func (s *Service) DeleteSchema(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	schema, err := schemaRevision(ctx, tx, name, 0)
+	if err != nil {
+		return err
+	}
+
+	// Topics name the schemas of their own project by name, and those of other projects by resource path.
+	var topics int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Topics WHERE (project = ? AND schema_name = ?) OR schema_name = ?",
+		schema.Project, schema.Name, schema.ResourceName()).Scan(&topics)
+	if err != nil {
+		return err
+	}
+	if topics > 0 {
+		return fmt.Errorf("cannot delete schema %q bound to %d topic(s): %w", name, topics, ErrSchemaInUse)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM SchemaRevisions WHERE schema_id = ?", schema.ID); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM Schemas WHERE id = ?", schema.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.codecs.forget(schema.ID)
+	return nil
+}
+
+// SetTopicSchema binds a topic to a schema. Passing nil removes the binding. A plain schema name names a schema of the
+// topic's project.
+func (s *Service) SetTopicSchema(ctx context.Context, topicName string, settings *SchemaSettings) error {
+	topic, err := s.GetTopic(ctx, topicName)
+	if err != nil {
+		return err
+	}
+	return s.setTopicSchema(ctx, s.db, topic, settings)
+}
+
+func (s *Service) setTopicSchema(ctx context.Context, q querier, topic *Topic, settings *SchemaSettings) error {
+	ctx = WithProject(ctx, topic.Project)
+	var (
+		name, encoding sql.NullString
+		first, last    int
+	)
+	if settings != nil {
+		schema, err := schemaRevision(ctx, q, settings.Schema, 0)
+		if err != nil {
+			return err
+		}
+		enc := EncodingJSON
+		if settings.Encoding != "" {
+			if enc, err = ParseEncoding(string(settings.Encoding)); err != nil {
+				return err
+			}
+		}
+		if schema.Type == SchemaTypeJSON && enc != EncodingJSON {
+			return fmt.Errorf("JSON schemas only support the %s encoding", EncodingJSON)
+		}
+		first, last = settings.FirstRevision, settings.LastRevision
+		if first < 0 || last < 0 || (last > 0 && first > last) {
+			return fmt.Errorf("invalid revision range %d to %d", first, last)
+		}
+		if _, err := s.schemaRevisions(ctx, q, settings.Schema, first, last); err != nil {
+			return err
+		}
+		name = sql.NullString{String: settings.Schema, Valid: true}
+		encoding = sql.NullString{String: string(enc), Valid: true}
+	}
+
+	_, err := q.ExecContext(ctx, "UPDATE Topics SET schema_name = ?, schema_encoding = ?, schema_first_revision = ?, schema_last_revision = ? WHERE id = ?",
+		name, encoding, first, last, topic.ID)
+	return err
+}
+
+// decodeMessage validates content against the revisions of the schema bound to a topic, newest first, and returns
+// the JSON rendering from the first revision that accepts it. Topics without a schema return content unchanged.
+func (s *Service) decodeMessage(ctx context.Context, q querier, topic *Topic, content string) (string, error) {
+	settings := topic.SchemaSettings
+	if settings == nil {
+		return content, nil
+	}
+	revisions, err := s.schemaRevisions(WithProject(ctx, topic.Project), q, settings.Schema, settings.FirstRevision, settings.LastRevision)
+	if err != nil {
+		return "", err
+	}
+
+	var firstErr error
+	for _, revision := range revisions {
+		compiled, err := s.codecs.get(revision)
+		if err != nil {
+			return "", err
+		}
+		decoded, err := compiled.JSON(content, settings.Encoding)
+		if err == nil {
+			return decoded, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// Report the failure against the newest revision, which is the one producers are most likely targeting.
+	var validationErr *ValidationError
+	if errors.As(firstErr, &validationErr) {
+		validationErr.Schema = settings.Schema
+		validationErr.Revision = revisions[0].Revision
+	}
+	return "", firstErr
+}
+
+// validateMessage checks content against the schema bound to a topic, if any.
+func (s *Service) validateMessage(ctx context.Context, q querier, topic *Topic, content string) error {
+	_, err := s.decodeMessage(ctx, q, topic, content)
+	return err
+}
+
+// DecodeMessage renders a message payload as JSON using the schema bound to its topic. Payloads of topics without a
+// schema are returned unchanged.
+func (s *Service) DecodeMessage(ctx context.Context, message *Message) (string, error) {
+	topic, err := scanTopic(s.db.QueryRowContext(ctx, "SELECT "+topicColumns+" FROM Topics WHERE id = ?", message.TopicID))
+	if err != nil {
+		return "", err
+	}
+	return s.decodeMessage(ctx, s.db, topic, message.Content)
+}
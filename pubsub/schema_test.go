@@ -0,0 +1,254 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const orderSchema = `{
+  "type": "object",
+  "required": ["id", "items"],
+  "additionalProperties": false,
+  "properties": {
+    "id": {"type": "integer", "minimum": 1},
+    "note": {"type": "string", "maxLength": 10},
+    "items": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/item"}}
+  },
+  "$defs": {
+    "item": {
+      "type": "object",
+      "required": ["sku"],
+      "properties": {"sku": {"type": "string", "pattern": "^[A-Z]+$"}}
+    }
+  }
+}`
+
+func TestJSONSchemaValidation(t *testing.T) {
+	schema, err := compileJSONSchema(orderSchema)
+	ok(t, err, "failed to compile schema")
+
+	tests := []struct {
+		name    string
+		payload string
+		path    string // Empty when the payload is valid
+	}{
+		{"valid", `{"id": 1, "items": [{"sku": "ABC"}]}`, ""},
+		{"wrong type", `{"id": "1", "items": [{"sku": "ABC"}]}`, "/id"},
+		{"below minimum", `{"id": 0, "items": [{"sku": "ABC"}]}`, "/id"},
+		{"missing required", `{"id": 1}`, ""},
+		{"nested ref", `{"id": 1, "items": [{"sku": "ABC"}, {"sku": "abc"}]}`, "/items/1/sku"},
+		{"additional property", `{"id": 1, "items": [{"sku": "A"}], "extra": true}`, ""},
+		{"too long", `{"id": 1, "items": [{"sku": "A"}], "note": "far too long"}`, "/note"},
+		{"not json", `{`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate(tt.payload, EncodingJSON)
+			if tt.name == "valid" {
+				ok(t, err, "valid payload was rejected")
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected *ValidationError, got %v", err)
+			}
+			equals(t, tt.path, validationErr.Path, "validation path doesn't match expectation")
+		})
+	}
+
+	_, err = compileJSONSchema(`{"$ref": "#/$defs/missing"}`)
+	if err == nil {
+		t.Fatal("expected an unresolvable reference to be rejected")
+	}
+}
+
+func TestRecursiveJSONSchema(t *testing.T) {
+	// A tree of nodes is recursive but descends into the value, so it is validated to any depth the value has.
+	tree, err := compileJSONSchema(`{"type": "object", "properties": {"child": {"$ref": "#"}, "n": {"type": "integer"}}}`)
+	ok(t, err, "failed to compile recursive schema")
+	ok(t, tree.Validate(`{"n": 1, "child": {"n": 2, "child": {"n": 3}}}`, EncodingJSON), "nested value was rejected")
+	var validationErr *ValidationError
+	if err := tree.Validate(`{"child": {"child": {"n": "x"}}}`, EncodingJSON); !errors.As(err, &validationErr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	equals(t, "/child/child/n", validationErr.Path, "validation path doesn't match expectation")
+	deep := strings.Repeat(`{"child": `, 2*maxJSONSchemaDepth) + "{}" + strings.Repeat("}", 2*maxJSONSchemaDepth)
+	if err := tree.Validate(deep, EncodingJSON); !errors.As(err, &validationErr) {
+		t.Fatalf("expected a value nested too deeply to be rejected, got %v", err)
+	}
+
+	// Schemas referring to themselves without descending into the value are rejected instead of overflowing the stack.
+	for _, definition := range []string{
+		`{"$ref": "#"}`,
+		`{"properties": {"a": {"$ref": "#"}}, "anyOf": [{"$ref": "#"}]}`,
+		`{"not": {"$ref": "#/$defs/loop"}, "$defs": {"loop": {"allOf": [{"$ref": "#"}]}}}`,
+	} {
+		schema, err := compileJSONSchema(definition)
+		ok(t, err, "failed to compile self-referencing schema")
+		if err := schema.Validate(`{"a": {"a": {}}}`, EncodingJSON); !errors.As(err, &validationErr) {
+			t.Fatalf("%s: expected *ValidationError, got %v", definition, err)
+		}
+	}
+}
+
+func TestTopicSchema(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	err := s.CreateSchema(ctx, &Schema{Name: "orders", Type: SchemaTypeJSON, Definition: orderSchema})
+	ok(t, err, "failed to create schema")
+	err = s.CreateSchema(ctx, &Schema{Name: "broken", Type: SchemaTypeJSON, Definition: `{"type": 1}`})
+	if err == nil {
+		t.Fatal("expected an invalid schema definition to be rejected")
+	}
+
+	schemas, err := s.ListSchemas(ctx)
+	ok(t, err, "failed to list schemas")
+	equals(t, 1, len(schemas), "schema count doesn't match expectation")
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	topic, err := s.GetTopic(ctx, "orders")
+	ok(t, err, "failed to get topic")
+	ok(t, s.SetTopicSchema(ctx, topic.Name, &SchemaSettings{Schema: "orders"}), "failed to bind schema")
+
+	topic, err = s.GetTopic(ctx, "orders")
+	ok(t, err, "failed to get topic")
+	equals(t, "orders", topic.SchemaSettings.Schema, "topic schema doesn't match expectation")
+
+	err = s.PublishMessage(ctx, topic.Name, `{"id": 7, "items": [{"sku": "X"}]}`, nil)
+	ok(t, err, "valid message was rejected")
+
+	err = s.PublishMessage(ctx, topic.Name, `{"id": 7, "items": [{"sku": 1}]}`, nil)
+	if !errors.Is(err, ErrSchemaValidation) {
+		t.Fatalf("expected ErrSchemaValidation, got %v", err)
+	}
+	equals(t, `message does not match schema "orders" revision 1 at /items/0/sku: expected string, got integer`, err.Error(), "error doesn't match expectation")
+
+	err = s.DeleteSchema(ctx, "orders")
+	if !errors.Is(err, ErrSchemaInUse) {
+		t.Fatalf("expected deleting a bound schema to fail with ErrSchemaInUse, got %v", err)
+	}
+	ok(t, s.SetTopicSchema(ctx, topic.Name, nil), "failed to unbind schema")
+	ok(t, s.DeleteSchema(ctx, "orders"), "failed to delete schema")
+}
+
+func TestCreateTopicWithSettings(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	err := s.CreateSchema(ctx, &Schema{Name: "orders", Type: SchemaTypeJSON, Definition: orderSchema})
+	ok(t, err, "failed to create schema")
+
+	limits := &Limits{MaxMessageBytes: 64}
+	for _, settings := range []*SchemaSettings{
+		{Schema: "missing"},
+		{Schema: "orders", FirstRevision: 2},
+	} {
+		err := s.CreateTopic(ctx, "orders", &TopicConfig{Limits: limits, SchemaSettings: settings})
+		if err == nil {
+			t.Fatalf("expected schema settings %+v to be rejected", settings)
+		}
+		_, err = s.GetTopic(ctx, "orders")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected a topic whose settings were rejected not to be created, got %v", err)
+		}
+	}
+
+	config := &TopicConfig{Limits: limits, Compression: CompressionGzip, SchemaSettings: &SchemaSettings{Schema: "orders"}}
+	ok(t, s.CreateTopic(ctx, "orders", config), "failed to create topic")
+	topic, err := s.GetTopic(ctx, "orders")
+	ok(t, err, "failed to get topic")
+	equals(t, 64, topic.Limits.MaxMessageBytes, "topic limits don't match expectation")
+	equals(t, CompressionGzip, topic.Compression, "topic compression doesn't match expectation")
+	equals(t, "orders", topic.SchemaSettings.Schema, "topic schema doesn't match expectation")
+}
+
+// userDescriptorSet returns a descriptor set declaring example.User { string name = 1; int32 age = 2; }.
+func userDescriptorSet(t *testing.T) string {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("user.proto"),
+		Package: proto.String("example"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("User"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("name"), JsonName: proto.String("name"), Number: proto.Int32(1),
+					Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				{Name: proto.String("age"), JsonName: proto.String("age"), Number: proto.Int32(2),
+					Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			},
+		}},
+	}
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	ok(t, err, "failed to marshal descriptor set")
+	return string(b)
+}
+
+func TestBinarySchemas(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	err := s.CreateSchema(ctx, &Schema{Name: "users", Type: SchemaTypeProtocolBuffer, Definition: userDescriptorSet(t)})
+	ok(t, err, "failed to create protobuf schema")
+	err = s.CreateSchema(ctx, &Schema{Name: "missing", Type: SchemaTypeProtocolBuffer, Definition: userDescriptorSet(t), MessageType: "example.Missing"})
+	if err == nil {
+		t.Fatal("expected an unknown message type to be rejected")
+	}
+	err = s.CreateSchema(ctx, &Schema{Name: "events", Type: SchemaTypeAvro, Definition: `{
+		"type": "record", "name": "Event",
+		"fields": [{"name": "kind", "type": "string"}, {"name": "count", "type": "long"}]
+	}`})
+	ok(t, err, "failed to create Avro schema")
+
+	tests := []struct {
+		schema   string
+		encoding Encoding
+		valid    string
+		invalid  string
+		json     string
+	}{
+		// Binary protobuf: name = "ann" (field 1, length 3), age = 30 (field 2, varint).
+		{"users", EncodingBinary, "\x0a\x03ann\x10\x1e", "\x0a\x09ann", `{"name":"ann","age":30}`},
+		{"users", EncodingJSON, `{"name": "ann", "age": 30}`, `{"name": 1}`, `{"name":"ann","age":30}`},
+		// Binary Avro: string "ok" (zig-zag length 2), long 3 (zig-zag 6).
+		{"events", EncodingBinary, "\x04ok\x06", "\x04ok", `{"kind":"ok","count":3}`},
+		{"events", EncodingJSON, `{"kind": "ok", "count": 3}`, `{"kind": "ok"}`, `{"kind":"ok","count":3}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema+"/"+string(tt.encoding), func(t *testing.T) {
+			name := tt.schema + "-" + string(tt.encoding)
+			ok(t, s.CreateTopic(ctx, name, nil), "failed to create topic")
+			topic, err := s.GetTopic(ctx, name)
+			ok(t, err, "failed to get topic")
+			ok(t, s.SetTopicSchema(ctx, topic.Name, &SchemaSettings{Schema: tt.schema, Encoding: tt.encoding}), "failed to bind schema")
+			ok(t, s.CreateSubscription(ctx, topic.Name, name, nil), "failed to create subscription")
+			subscription, err := s.GetSubscription(ctx, name)
+			ok(t, err, "failed to get subscription")
+
+			ok(t, s.PublishMessage(ctx, topic.Name, tt.valid, nil), "valid message was rejected")
+			err = s.PublishMessage(ctx, topic.Name, tt.invalid, nil)
+			if !errors.Is(err, ErrSchemaValidation) {
+				t.Fatalf("expected ErrSchemaValidation, got %v", err)
+			}
+
+			messages, err := s.GetMessages(ctx, subscription.SubscriberID)
+			ok(t, err, "failed to get messages")
+			equals(t, 1, len(messages), "message count doesn't match expectation")
+			decoded, err := s.DecodeMessage(ctx, messages[0])
+			ok(t, err, "failed to decode message")
+			var expected, actual any
+			ok(t, json.Unmarshal([]byte(tt.json), &expected), "invalid expectation")
+			ok(t, json.Unmarshal([]byte(decoded), &actual), "decoded message is not JSON")
+			if !reflect.DeepEqual(expected, actual) {
+				t.Fatalf("decoded message doesn't match expectation: expected %s, got %s", tt.json, decoded)
+			}
+		})
+	}
+}
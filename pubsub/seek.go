@@ -0,0 +1,71 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SeekResult reports how many of a subscription's messages changed state during a seek.
+type SeekResult struct {
+	// Redelivered counts messages published at or after the seek time that were acknowledged or leased and are now
+	// available for delivery again.
+	Redelivered int
+	// Acknowledged counts messages published before the seek time that were outstanding and are now acknowledged.
+	Acknowledged int
+}
+
+// Seek replays a subscription from a point in time. Messages published at or after t are marked unacknowledged and
+// their leases cleared, while earlier messages are marked acknowledged. Acknowledged messages can only be replayed
+// while they are retained, see SubscriptionConfig.RetainAckedMessages. When the topic retains messages, those published
+// at or after t that the subscription does not have are delivered as well, see Backfill.
+func (s *Service) Seek(ctx context.Context, subscriptionName string, t time.Time) (*SeekResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := s.subscriptionByName(ctx, tx, subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Detached {
+		return nil, fmt.Errorf("cannot seek subscription %q: %w", subscriptionName, ErrDetached)
+	}
+
+	result := &SeekResult{}
+	res, err := tx.ExecContext(ctx, `UPDATE Messages SET acknowledged = 0, ack_deadline = NULL
+        WHERE subscription_id = ? AND published_at >= ? AND (acknowledged = 1 OR ack_deadline IS NOT NULL)`,
+		subscription.ID, dbTime(t))
+	if err != nil {
+		return nil, err
+	}
+	if result.Redelivered, err = rowsAffected(res); err != nil {
+		return nil, err
+	}
+
+	res, err = tx.ExecContext(ctx, `UPDATE Messages SET acknowledged = 1
+        WHERE subscription_id = ? AND published_at < ? AND acknowledged = 0`,
+		subscription.ID, dbTime(t))
+	if err != nil {
+		return nil, err
+	}
+	if result.Acknowledged, err = rowsAffected(res); err != nil {
+		return nil, err
+	}
+
+	// Messages retained by the topic that the subscription never received, e.g. because they were published before
+	// it was created, are delivered too.
+	backfilled, err := backfill(ctx, tx, subscription, t, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result.Redelivered += backfilled
+	return result, tx.Commit()
+}
+
+func rowsAffected(res interface{ RowsAffected() (int64, error) }) (int, error) {
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,54 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSeek(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", &SubscriptionConfig{RetainAckedMessages: true}), "failed to create subscription")
+	ok(t, s.CreateSubscription(ctx, "orders", "audit", nil), "failed to create subscription")
+
+	ok(t, s.PublishMessage(ctx, "orders", "first", nil), "failed to publish message")
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	ok(t, s.PublishMessage(ctx, "orders", "second", nil), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "orders", "third", nil), "failed to publish message")
+
+	messages, err := s.PullMessages(ctx, "billing", time.Now().Add(time.Hour))
+	ok(t, err, "failed to pull messages")
+	equals(t, 3, len(messages), "expected every message")
+	ok(t, s.AcknowledgeMessage(ctx, "billing", messages[0].ID), "failed to ack message")
+	ok(t, s.AcknowledgeMessage(ctx, "billing", messages[1].ID), "failed to ack message")
+
+	result, err := s.Seek(ctx, "billing", since)
+	ok(t, err, "failed to seek")
+	equals(t, SeekResult{Redelivered: 2, Acknowledged: 0}, *result, "seek result doesn't match")
+	messages, err = s.PullMessages(ctx, "billing", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 2, len(messages), "messages since the seek time should be redelivered")
+	equals(t, "second", messages[0].Content, "wrong message redelivered")
+
+	result, err = s.Seek(ctx, "billing", time.Now().Add(time.Minute))
+	ok(t, err, "failed to seek")
+	equals(t, SeekResult{Redelivered: 0, Acknowledged: 2}, *result, "seek result doesn't match")
+
+	// Acknowledged messages are only kept by subscriptions that retain them.
+	audit, err := s.PullMessages(ctx, "audit", time.Time{})
+	ok(t, err, "failed to pull messages")
+	ok(t, s.AcknowledgeMessage(ctx, "audit", audit[0].ID), "failed to ack message")
+	deleted, err := s.GC(ctx, time.Now())
+	ok(t, err, "failed to collect garbage")
+	equals(t, 1, deleted, "only the acked audit message should be deleted")
+
+	// Messages older than the retention window are deleted regardless.
+	deleted, err = s.GC(ctx, time.Now().Add(DefaultRetention+time.Minute))
+	ok(t, err, "failed to collect garbage")
+	equals(t, 5, deleted, "messages past retention should be deleted")
+}
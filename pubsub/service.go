@@ -3,26 +3,70 @@ package pubsub
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
+	"strings"
 	"time"
 )
 
 const DefaultFilename = "pubsub.db"
 
 type Service struct {
-	db *sql.DB
+	db     *sql.DB
+	codecs codecs
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 type Topic struct {
-	ID       int
-	Name     string
-	Metadata []byte
+	ID             int
+	Project        string
+	Name           string
+	Config         TopicConfig
+	Limits         Limits
+	SchemaSettings *SchemaSettings
+	Compression    Compression
 }
 
 type Subscription struct {
 	ID           int
-	TopicID      int
+	Project      string
+	TopicID      int    // Zero once the topic has been deleted
+	TopicProject string // Project of the topic, which may differ from the subscription's
+	Topic        string // Name of the topic, empty once it has been deleted
 	SubscriberID string
+	// Detached subscriptions no longer receive messages and cannot be pulled from.
+	Detached bool
+	Config   SubscriptionConfig
+	// LastActive is when the subscription was created or last pulled from, see SubscriptionConfig.ExpirationPolicy.
+	LastActive time.Time
+}
+
+// ResourceName returns the full resource path of the topic.
+func (t *Topic) ResourceName() string {
+	return ResourceName(t.Project, "topics", t.Name)
+}
+
+// ResourceName returns the full resource path of the subscription.
+func (s *Subscription) ResourceName() string {
+	return ResourceName(s.Project, "subscriptions", s.SubscriberID)
+}
+
+// TopicResourceName returns the full resource path of the subscription's topic, or an empty string once the topic has
+// been deleted.
+func (s *Subscription) TopicResourceName() string {
+	if s.Topic == "" {
+		return ""
+	}
+	return ResourceName(s.TopicProject, "topics", s.Topic)
 }
 
 type Message struct {
@@ -30,17 +74,76 @@ type Message struct {
 	TopicID        int
 	SubscriptionID int
 	Content        string
-	Metadata       []byte
+	Attributes     map[string]string
 	Acknowledged   bool
 	AckDeadline    sql.NullTime // Use sql.NullTime for fields that may not always have a value
+	// DeliveryAttempt counts how many times the message has been pulled.
+	DeliveryAttempt int
+	PublishedAt     time.Time
+	// PublishID identifies the published message, which every subscription receives a copy of with its own ID. It
+	// is zero for messages published by older versions.
+	PublishID int64
 }
 
 func (m *Message) String() string {
-	return fmt.Sprintf("ID: %d, TopicID: %d, SubscriptionID: %d, Content: %s, Metadata: %s, Acknowledged: %t, AckDeadline: %v", m.ID, m.TopicID, m.SubscriptionID, m.Content, string(m.Metadata), m.Acknowledged, m.AckDeadline)
+	return fmt.Sprintf("ID: %d, TopicID: %d, SubscriptionID: %d, Content: %s, Attributes: %v, Acknowledged: %t, AckDeadline: %v", m.ID, m.TopicID, m.SubscriptionID, m.Content, m.Attributes, m.Acknowledged, m.AckDeadline)
+}
+
+// dbTime formats a time for the timestamp columns. Timestamps are stored in UTC with a fixed layout so that they
+// compare correctly as text, including against the CURRENT_TIMESTAMP defaults written by older versions.
+func dbTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
+
+// Message attributes are stored as a JSON object in the metadata column.
+func encodeAttributes(attributes map[string]string) ([]byte, error) {
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(attributes)
+}
+
+func decodeAttributes(b []byte) (map[string]string, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var attributes map[string]string
+	if err := json.Unmarshal(b, &attributes); err != nil {
+		return nil, fmt.Errorf("invalid message attributes: %v", err)
+	}
+	return attributes, nil
+}
+
+const messageColumns = "id, topic_id, subscription_id, content, compression, metadata, acknowledged, ack_deadline, delivery_attempt, published_at, publish_id"
+
+// scanMessage scans a row selected with messageColumns, decompressing the payload.
+func scanMessage(rows *sql.Rows) (*Message, error) {
+	message := &Message{}
+	var (
+		content     []byte
+		compression Compression
+		metadata    []byte
+		publishID   sql.NullInt64
+	)
+	if err := rows.Scan(&message.ID, &message.TopicID, &message.SubscriptionID, &content, &compression, &metadata, &message.Acknowledged, &message.AckDeadline, &message.DeliveryAttempt, &message.PublishedAt, &publishID); err != nil {
+		return nil, err
+	}
+	message.PublishID = publishID.Int64
+	var err error
+	if message.Content, err = decompress(compression, content); err != nil {
+		return nil, err
+	}
+	if message.Attributes, err = decodeAttributes(metadata); err != nil {
+		return nil, err
+	}
+	return message, nil
 }
 
 func NewService(fname string) (*Service, error) {
-	db, err := sql.Open("sqlite3", fname)
+	// Foreign keys are enforced so that deletes cannot leave orphaned rows behind. Transactions take the write lock
+	// when they begin, and wait for it while another connection holds it, so that concurrent callers such as the
+	// server's requests queue up instead of failing with "database is locked".
+	db, err := sql.Open("sqlite3", fname+"?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
@@ -51,20 +154,93 @@ func (s *Service) Close() error {
 	return s.db.Close()
 }
 
-func (s *Service) CreateTopic(ctx context.Context, name string, metadata []byte) error {
-	_, err := s.db.ExecContext(ctx, "INSERT INTO Topics (name, metadata) VALUES (?, ?)", name, metadata)
-	return err
+// CreateTopic creates a topic. A nil config selects the defaults. The topic is only created if its limits, compression
+// and schema settings can be applied as well.
+func (s *Service) CreateTopic(ctx context.Context, name string, config *TopicConfig) error {
+	project, id, err := resolveName(ctx, name, "topics")
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		config = &TopicConfig{}
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	compression, err := ParseCompression(string(config.Compression))
+	if err != nil {
+		return err
+	}
+	// The settings stored in their own columns are left out of the metadata.
+	stored := TopicConfig{Labels: config.Labels, MessageRetentionDuration: config.MessageRetentionDuration}
+	metadata, err := encodeConfig(&stored)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO Topics (project, name, metadata) VALUES (?, ?, ?)", project, id, metadata)
+	if err != nil {
+		return alreadyExists(err, "topic", name)
+	}
+	topicID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	topic := &Topic{ID: int(topicID), Project: project, Name: id}
+	if config.Limits != nil {
+		if err := setTopicLimits(ctx, tx, topic, *config.Limits); err != nil {
+			return err
+		}
+	}
+	if compression != CompressionNone {
+		if err := setTopicCompression(ctx, tx, topic, compression); err != nil {
+			return err
+		}
+	}
+	if config.SchemaSettings != nil {
+		if err := s.setTopicSchema(ctx, tx, topic, config.SchemaSettings); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (s *Service) GetTopic(ctx context.Context, name string) (*Topic, error) {
-	row := s.db.QueryRowContext(ctx, "SELECT id, name, metadata FROM Topics WHERE name = ?", name)
+const topicColumns = `id, project, name, metadata, max_message_bytes, max_attributes, max_attribute_key_bytes, max_attribute_value_bytes,
+    schema_name, schema_encoding, schema_first_revision, schema_last_revision, compression`
+
+func scanTopic(row interface{ Scan(...any) error }) (*Topic, error) {
 	topic := &Topic{}
-	err := row.Scan(&topic.ID, &topic.Name, &topic.Metadata)
-	return topic, err
+	var schema, encoding sql.NullString
+	var first, last int
+	var metadata []byte
+	err := row.Scan(&topic.ID, &topic.Project, &topic.Name, &metadata, &topic.Limits.MaxMessageBytes, &topic.Limits.MaxAttributes,
+		&topic.Limits.MaxAttributeKeyBytes, &topic.Limits.MaxAttributeValueBytes, &schema, &encoding, &first, &last, &topic.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Valid {
+		topic.SchemaSettings = &SchemaSettings{Schema: schema.String, Encoding: EncodingJSON, FirstRevision: first, LastRevision: last}
+		if encoding.Valid {
+			topic.SchemaSettings.Encoding = Encoding(encoding.String)
+		}
+	}
+	return topic, decodeStoredConfig(metadata, &topic.Config)
 }
 
+// GetTopic returns a topic by name or resource path, or a *NotFoundError.
+func (s *Service) GetTopic(ctx context.Context, name string) (*Topic, error) {
+	return s.topicByName(ctx, s.db, name)
+}
+
+// ListTopics returns the topics of the project of ctx.
 func (s *Service) ListTopics(ctx context.Context) ([]*Topic, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT id, name, metadata FROM Topics")
+	rows, err := s.db.QueryContext(ctx, "SELECT "+topicColumns+" FROM Topics WHERE project = ?", contextProject(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -72,8 +248,8 @@ func (s *Service) ListTopics(ctx context.Context) ([]*Topic, error) {
 
 	var topics []*Topic
 	for rows.Next() {
-		topic := &Topic{}
-		if err := rows.Scan(&topic.ID, &topic.Name, &topic.Metadata); err != nil {
+		topic, err := scanTopic(rows)
+		if err != nil {
 			return nil, err
 		}
 		topics = append(topics, topic)
@@ -86,20 +262,88 @@ func (s *Service) ListTopics(ctx context.Context) ([]*Topic, error) {
 	return topics, nil
 }
 
-func (s *Service) CreateSubscription(ctx context.Context, topicID int, subscriberID string, metadata []byte) error {
-	_, err := s.db.ExecContext(ctx, "INSERT INTO Subscriptions (topic_id, subscriber_id, metadata) VALUES (?, ?, ?)", topicID, subscriberID, metadata)
+// CreateSubscription subscribes to a topic, which may belong to another project than the subscription. Subscription
+// names are unique across all topics of a project. A nil config selects the defaults.
+func (s *Service) CreateSubscription(ctx context.Context, topicName, name string, config *SubscriptionConfig) error {
+	topic, err := s.GetTopic(ctx, topicName)
+	if err != nil {
+		return err
+	}
+	_, err = s.createSubscription(ctx, s.db, topic, name, config)
 	return err
 }
 
-func (s *Service) GetSubscription(ctx context.Context, topicID int, subscriberID string) (*Subscription, error) {
-	row := s.db.QueryRowContext(ctx, "SELECT id, topic_id, subscriber_id FROM Subscriptions WHERE topic_id = ? AND subscriber_id = ?", topicID, subscriberID)
+// createSubscription validates the name and config of a new subscription and inserts it, returning its id.
+func (s *Service) createSubscription(ctx context.Context, q querier, topic *Topic, name string, config *SubscriptionConfig) (int, error) {
+	project, id, err := resolveName(ctx, name, "subscriptions")
+	if err != nil {
+		return 0, err
+	}
+	// Names in the config, such as the topic messages are forwarded to, are relative to the subscription's project.
+	ctx = WithProject(ctx, project)
+	if config == nil {
+		config = &SubscriptionConfig{}
+	}
+	if err := config.Validate(); err != nil {
+		return 0, err
+	}
+	if err := s.checkForward(ctx, q, topic, config); err != nil {
+		return 0, err
+	}
+	metadata, err := encodeConfig(config)
+	if err != nil {
+		return 0, err
+	}
+	res, err := q.ExecContext(ctx, "INSERT INTO Subscriptions (project, topic_id, subscriber_id, metadata, last_active_at) VALUES (?, ?, ?, ?, ?)",
+		project, topic.ID, id, metadata, dbTime(time.Now()))
+	if err != nil {
+		return 0, alreadyExists(err, "subscription", name)
+	}
+	subscriptionID, err := res.LastInsertId()
+	return int(subscriptionID), err
+}
+
+const subscriptionColumns = `id, project, topic_id, (SELECT project FROM Topics t WHERE t.id = topic_id),
+    (SELECT name FROM Topics t WHERE t.id = topic_id), subscriber_id, detached, metadata, last_active_at`
+
+func scanSubscription(row interface{ Scan(...any) error }) (*Subscription, error) {
 	subscription := &Subscription{}
-	err := row.Scan(&subscription.ID, &subscription.TopicID, &subscription.SubscriberID)
-	return subscription, err
+	var topicID sql.NullInt64
+	var topicProject, topic sql.NullString
+	var metadata []byte
+	var lastActive sql.NullTime
+	if err := row.Scan(&subscription.ID, &subscription.Project, &topicID, &topicProject, &topic, &subscription.SubscriberID,
+		&subscription.Detached, &metadata, &lastActive); err != nil {
+		return nil, err
+	}
+	subscription.TopicID = int(topicID.Int64)
+	subscription.TopicProject = topicProject.String
+	subscription.Topic = topic.String
+	subscription.LastActive = lastActive.Time
+	return subscription, decodeStoredConfig(metadata, &subscription.Config)
+}
+
+// GetSubscription returns a subscription by name or resource path, or a *NotFoundError.
+func (s *Service) GetSubscription(ctx context.Context, name string) (*Subscription, error) {
+	return s.subscriptionByName(ctx, s.db, name)
+}
+
+func (s *Service) ListSubscriptions(ctx context.Context, topicName string) ([]*Subscription, error) {
+	topic, err := s.GetTopic(ctx, topicName)
+	if err != nil {
+		return nil, err
+	}
+	return s.listSubscriptions(ctx, "WHERE topic_id = ? AND detached = 0", topic.ID)
 }
 
-func (s *Service) ListSubscriptions(ctx context.Context, topicID int) ([]*Subscription, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT id, topic_id, subscriber_id FROM Subscriptions WHERE topic_id = ?", topicID)
+// ListAllSubscriptions returns the subscriptions of every topic in every project, including detached ones, for the
+// work done on behalf of all of them such as pushing messages.
+func (s *Service) ListAllSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	return s.listSubscriptions(ctx, "")
+}
+
+func (s *Service) listSubscriptions(ctx context.Context, where string, args ...any) ([]*Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+subscriptionColumns+" FROM Subscriptions "+where, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -107,8 +351,8 @@ func (s *Service) ListSubscriptions(ctx context.Context, topicID int) ([]*Subscr
 
 	var subscriptions []*Subscription
 	for rows.Next() {
-		subscription := &Subscription{}
-		if err := rows.Scan(&subscription.ID, &subscription.TopicID, &subscription.SubscriberID); err != nil {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
 			return nil, err
 		}
 		subscriptions = append(subscriptions, subscription)
@@ -121,51 +365,159 @@ func (s *Service) ListSubscriptions(ctx context.Context, topicID int) ([]*Subscr
 	return subscriptions, nil
 }
 
-func (s *Service) PublishMessage(ctx context.Context, topicID int, content string, metadata []byte) error {
+// PublishMessage fans a message out to every subscription of a topic, see Publish.
+func (s *Service) PublishMessage(ctx context.Context, topicName string, content string, attributes map[string]string) error {
+	_, err := s.Publish(ctx, topicName, content, attributes)
+	return err
+}
+
+// Publish fans a message out to every subscription of a topic and returns its publish ID. Messages exceeding the
+// topic's limits are rejected with a *LimitError, and messages that do not match the topic's schema with a
+// *ValidationError. Forwarding subscriptions republish the message in the same transaction, so it is published
+// everywhere or nowhere.
+func (s *Service) Publish(ctx context.Context, topicName string, content string, attributes map[string]string) (int64, error) {
 	// Start a transaction
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	rows, err := tx.QueryContext(ctx, "SELECT id FROM Subscriptions WHERE topic_id = ?", topicID)
+	publishID, err := s.publish(ctx, tx, topicName, content, attributes, nil)
 	if err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
-			return fmt.Errorf("query error: %v, rollback error: %v", err, rbErr)
+			return 0, fmt.Errorf("publish error: %w, rollback error: %v", err, rbErr)
 		}
-		return err
+		return 0, err
 	}
-	defer rows.Close()
 
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return publishID, nil
+}
+
+// publish stores a message for every subscription of a topic and forwards it through forwarding subscriptions. path
+// holds the ids of the topics the message has already been published to, to stop forwarding cycles.
+func (s *Service) publish(ctx context.Context, tx *sql.Tx, topicName string, content string, attributes map[string]string, path []int) (int64, error) {
+	metadata, err := encodeAttributes(attributes)
+	if err != nil {
+		return 0, err
+	}
+
+	topic, err := s.topicByName(ctx, tx, topicName)
+	if err != nil {
+		return 0, err
+	}
+	if slices.Contains(path, topic.ID) {
+		return 0, fmt.Errorf("message forwarded back to topic %q: %w", topic.Name, ErrForwardingCycle)
+	}
+	path = append(path, topic.ID)
+	if err := topic.Limits.Check(content, attributes); err != nil {
+		return 0, err
+	}
+	if err := s.validateMessage(ctx, tx, topic, content); err != nil {
+		return 0, err
+	}
+
+	// Compress once and store the same payload for every subscription.
+	stored, storedSize, err := compress(topic.Compression, content)
+	if err != nil {
+		return 0, err
+	}
+
+	publishID, err := nextPublishID(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	publishedAt := dbTime(time.Now())
+	if err := retainMessage(ctx, tx, topic, publishID, stored, len(content), storedSize, metadata, publishedAt); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+subscriptionColumns+" FROM Subscriptions WHERE topic_id = ? AND detached = 0", topic.ID)
+	if err != nil {
+		return 0, err
+	}
+	var subscriptions []*Subscription
 	for rows.Next() {
-		var subscriptionID int
-		if err := rows.Scan(&subscriptionID); err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				return fmt.Errorf("scan error: %v, rollback error: %v", err, rbErr)
-			}
-			return err
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
 		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
 
-		_, err := tx.ExecContext(ctx, "INSERT INTO Messages (topic_id, subscription_id, content, metadata) VALUES (?, ?, ?, ?)",
-			topicID, subscriptionID, content, metadata)
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		// Expired subscriptions are only deleted by GC, but receive nothing in the meantime.
+		if subscription.expired(now) {
+			continue
+		}
+		// Messages that do not pass a subscription's filter are never delivered to it.
+		// Filters are checked when they are written, so one that fails here was stored by hand or by an older version,
+		// and only its own subscription misses the message.
+		match, err := matchFilter(subscription.Config.Filter, attributes)
 		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				return fmt.Errorf("insert error: %v, rollback error: %v", err, rbErr)
+			log.Printf("Skipping subscription %s, whose filter cannot be evaluated: %v", subscription.ResourceName(), err)
+			continue
+		}
+		if !match {
+			continue
+		}
+
+		if forward := subscription.Config.Forward; forward != nil {
+			// A target that no longer exists drops the forwarded copy rather than the publish. Targets further down the
+			// chain are skipped the same way, so a not found error can only be about this one.
+			forwardCtx := WithProject(ctx, subscription.Project)
+			_, err := s.publish(forwardCtx, tx, forward.Topic, content, forward.transform(attributes), path)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return 0, fmt.Errorf("forwarding subscription %q: %w", subscription.SubscriberID, err)
 			}
-			return err
+			// Nothing pulls from forwarding subscriptions, so forwarding counts as their activity.
+			if err := touchSubscription(ctx, tx, subscription.ID, now); err != nil {
+				return 0, err
+			}
+			continue
 		}
-	}
 
-	if err := tx.Commit(); err != nil {
-		return err
+		_, err = tx.ExecContext(ctx, "INSERT INTO Messages (topic_id, subscription_id, publish_id, content, compression, size, stored_size, metadata, published_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			topic.ID, subscription.ID, publishID, stored, topic.Compression, len(content), storedSize, metadata, publishedAt)
+		if err != nil {
+			return 0, err
+		}
 	}
+	return publishID, nil
+}
 
-	return nil
+// nextPublishID allocates a publish ID. IDs are drawn from an AUTOINCREMENT column, which never reuses a value even
+// once its row is deleted, so the row is only kept for the duration of the allocation.
+func nextPublishID(ctx context.Context, tx *sql.Tx) (int64, error) {
+	res, err := tx.ExecContext(ctx, "INSERT INTO PublishIDs DEFAULT VALUES")
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM PublishIDs WHERE id = ?", id)
+	return id, err
 }
 
 // GetMessages returns all messages for a subscription regardless of acknowledgement status
-func (s *Service) GetMessages(ctx context.Context, subscriptionID int) ([]*Message, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT id, topic_id, subscription_id, content, metadata, acknowledged, ack_deadline FROM Messages WHERE subscription_id = ?", subscriptionID)
+func (s *Service) GetMessages(ctx context.Context, subscriptionName string) ([]*Message, error) {
+	subscription, err := s.GetSubscription(ctx, subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+messageColumns+" FROM Messages WHERE subscription_id = ?", subscription.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query messages: %v", err)
 	}
@@ -173,8 +525,8 @@ func (s *Service) GetMessages(ctx context.Context, subscriptionID int) ([]*Messa
 
 	var messages []*Message
 	for rows.Next() {
-		message := &Message{}
-		if err := rows.Scan(&message.ID, &message.TopicID, &message.SubscriptionID, &message.Content, &message.Metadata, &message.Acknowledged, &message.AckDeadline); err != nil {
+		message, err := scanMessage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %v", err)
 		}
 		messages = append(messages, message)
@@ -187,14 +539,39 @@ func (s *Service) GetMessages(ctx context.Context, subscriptionID int) ([]*Messa
 	return messages, nil
 }
 
-// PullMessages returns all messages that have not been acknowledged and have not passed their ack_deadline.
-func (s *Service) PullMessages(ctx context.Context, subscriptionID int, ackDeadline time.Time) ([]*Message, error) {
+// PullMessages returns all messages that have not been acknowledged and have not passed their ack_deadline. A zero
+// ackDeadline leases the messages for the subscription's configured ack deadline.
+func (s *Service) PullMessages(ctx context.Context, subscriptionName string, ackDeadline time.Time) ([]*Message, error) {
+	return s.Pull(ctx, subscriptionName, 0, ackDeadline)
+}
+
+// Pull is PullMessages returning at most max messages, or every available message when max is zero.
+func (s *Service) Pull(ctx context.Context, subscriptionName string, max int, ackDeadline time.Time) ([]*Message, error) {
+	subscription, err := s.GetSubscription(ctx, subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Detached {
+		return nil, fmt.Errorf("cannot pull from subscription %q: %w", subscriptionName, ErrDetached)
+	}
+	if ackDeadline.IsZero() {
+		ackDeadline = time.Now().Add(subscription.Config.EffectiveAckDeadline())
+	}
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := tx.QueryContext(ctx, "SELECT id, topic_id, subscription_id, content, metadata, acknowledged, ack_deadline FROM Messages WHERE subscription_id = ? AND acknowledged = 0", subscriptionID)
+	// Every pull counts as activity, even when no messages are outstanding.
+	if err := touchSubscription(ctx, tx, subscription.ID, time.Now()); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("update error: %v, rollback error: %v", err, rbErr)
+		}
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+messageColumns+" FROM Messages WHERE subscription_id = ? AND acknowledged = 0", subscription.ID)
 	if err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
 			return nil, fmt.Errorf("query error: %v, rollback error: %v", err, rbErr)
@@ -205,8 +582,8 @@ func (s *Service) PullMessages(ctx context.Context, subscriptionID int, ackDeadl
 
 	var messages []*Message
 	for rows.Next() {
-		message := &Message{}
-		if err := rows.Scan(&message.ID, &message.TopicID, &message.SubscriptionID, &message.Content, &message.Metadata, &message.Acknowledged, &message.AckDeadline); err != nil {
+		message, err := scanMessage(rows)
+		if err != nil {
 			if rbErr := tx.Rollback(); rbErr != nil {
 				return nil, fmt.Errorf("scan error: %v, rollback error: %v", err, rbErr)
 			}
@@ -219,10 +596,14 @@ func (s *Service) PullMessages(ctx context.Context, subscriptionID int, ackDeadl
 			continue
 		}
 		messages = append(messages, message)
+		if len(messages) == max {
+			break
+		}
 	}
 
 	for _, message := range messages {
-		_, err := tx.ExecContext(ctx, "UPDATE Messages SET ack_deadline = ? WHERE id = ?", ackDeadline, message.ID)
+		message.DeliveryAttempt++
+		_, err := tx.ExecContext(ctx, "UPDATE Messages SET ack_deadline = ?, delivery_attempt = ? WHERE id = ?", ackDeadline, message.DeliveryAttempt, message.ID)
 		if err != nil {
 			if rbErr := tx.Rollback(); rbErr != nil {
 				return nil, fmt.Errorf("update error: %v, rollback error: %v", err, rbErr)
@@ -246,47 +627,116 @@ func (s *Service) PullMessages(ctx context.Context, subscriptionID int, ackDeadl
 }
 
 // AcknowledgeMessage sets the acknowledged field to true for a message
-func (s *Service) AcknowledgeMessage(ctx context.Context, subscriptionId int, messageID int) error {
-	_, err := s.db.ExecContext(ctx, "UPDATE Messages SET acknowledged = 1 WHERE id = ? and subscription_id = ?", messageID, subscriptionId)
+func (s *Service) AcknowledgeMessage(ctx context.Context, subscriptionName string, messageID int) error {
+	subscription, err := s.GetSubscription(ctx, subscriptionName)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE Messages SET acknowledged = 1 WHERE id = ? and subscription_id = ?", messageID, subscription.ID)
 	return err
 }
 
-func (s *Service) ModifyAckDeadline(ctx context.Context, subscriptionId int, messageID int, ackDeadline time.Time) error {
-	_, err := s.db.ExecContext(ctx, "UPDATE Messages SET ack_deadline = ? WHERE id = ? and subscription_id = ?", ackDeadline, messageID, subscriptionId)
+// ModifyAckDeadline extends or shortens the lease on a message. A deadline that is not in the future nacks the message;
+// if the subscription has a retry policy, redelivery is then delayed by its backoff.
+func (s *Service) ModifyAckDeadline(ctx context.Context, subscriptionName string, messageID int, ackDeadline time.Time) error {
+	subscription, err := s.GetSubscription(ctx, subscriptionName)
+	if err != nil {
+		return err
+	}
+	if policy := subscription.Config.RetryPolicy; policy != nil && !ackDeadline.After(time.Now()) {
+		var attempt int
+		err := s.db.QueryRowContext(ctx, "SELECT delivery_attempt FROM Messages WHERE id = ? and subscription_id = ?", messageID, subscription.ID).Scan(&attempt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		ackDeadline = time.Now().Add(policy.backoff(attempt))
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE Messages SET ack_deadline = ? WHERE id = ? and subscription_id = ?", ackDeadline, messageID, subscription.ID)
 	return err
 }
 
+// ExpireLeases clears the leases of outstanding messages whose ack deadline has passed at now, so that they are
+// listed as available again. Pulling does not depend on it, as expired leases are ignored, and it returns the number of
+// leases cleared.
+func (s *Service) ExpireLeases(ctx context.Context, now time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, ack_deadline FROM Messages WHERE acknowledged = 0 AND ack_deadline IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+	var expired []int
+	for rows.Next() {
+		var id int
+		var deadline sql.NullTime
+		if err := rows.Scan(&id, &deadline); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if deadline.Valid && !now.Before(deadline.Time) {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range expired {
+		if _, err := tx.ExecContext(ctx, "UPDATE Messages SET ack_deadline = NULL WHERE id = ?", id); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), tx.Commit()
+}
+
 func (s *Service) Init(ctx context.Context) error {
-	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS Topics (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        name TEXT NOT NULL UNIQUE,
-        metadata BLOB,
-        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-    );`)
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(topicsTable, "Topics"))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(schemasTable, "Schemas"))
 	if err != nil {
 		return err
 	}
 
-	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS Subscriptions (
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS SchemaRevisions (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
-        topic_id INTEGER NOT NULL,
-        subscriber_id TEXT NOT NULL,
-        metadata BLOB,
+        schema_id INTEGER NOT NULL,
+        revision INTEGER NOT NULL,
+        definition TEXT NOT NULL,
+        message_type TEXT NOT NULL DEFAULT '',
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-        FOREIGN KEY (topic_id) REFERENCES Topics(id)
+        UNIQUE (schema_id, revision),
+        FOREIGN KEY (schema_id) REFERENCES Schemas(id)
     );`)
 	if err != nil {
 		return err
 	}
 
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(subscriptionsTable, "Subscriptions"))
+	if err != nil {
+		return err
+	}
+
 	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS Messages (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         topic_id INTEGER NOT NULL,
         subscription_id INTEGER,
         content TEXT NOT NULL,
+        compression TEXT NOT NULL DEFAULT '',
+        size INTEGER,
+        stored_size INTEGER,
         metadata BLOB,
         acknowledged BOOLEAN DEFAULT FALSE,
         ack_deadline DATETIME,
+        delivery_attempt INTEGER NOT NULL DEFAULT 0,
+        publish_id INTEGER,
         published_at DATETIME DEFAULT CURRENT_TIMESTAMP,
         FOREIGN KEY (topic_id) REFERENCES Topics(id),
         FOREIGN KEY (subscription_id) REFERENCES Subscriptions(id)
@@ -294,5 +744,293 @@ func (s *Service) Init(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+
+	_, err = s.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS PublishIDs (id INTEGER PRIMARY KEY AUTOINCREMENT)")
+	if err != nil {
+		return err
+	}
+
+	// TopicMessages holds the messages retained by topics with a message retention duration, see Backfill.
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS TopicMessages (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        topic_id INTEGER NOT NULL,
+        content TEXT NOT NULL,
+        compression TEXT NOT NULL DEFAULT '',
+        size INTEGER,
+        stored_size INTEGER,
+        metadata BLOB,
+        published_at DATETIME NOT NULL,
+        FOREIGN KEY (topic_id) REFERENCES Topics(id)
+    );`)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(snapshotsTable, "Snapshots"))
+	if err != nil {
+		return err
+	}
+
+	// SnapshotMessages copies the messages a subscription had outstanding when a snapshot was taken, so that they can
+	// be restored after the originals are deleted. message_id is the id of the original row.
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS SnapshotMessages (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        snapshot_id INTEGER NOT NULL,
+        message_id INTEGER NOT NULL,
+        content TEXT NOT NULL,
+        compression TEXT NOT NULL DEFAULT '',
+        size INTEGER,
+        stored_size INTEGER,
+        metadata BLOB,
+        publish_id INTEGER,
+        published_at DATETIME,
+        FOREIGN KEY (snapshot_id) REFERENCES Snapshots(id)
+    );`)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range addedColumns {
+		if err := s.addColumn(ctx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if err := s.migrateSubscriptions(ctx); err != nil {
+		return fmt.Errorf("failed to migrate subscriptions: %v", err)
+	}
+	if err := s.migrateProjects(ctx); err != nil {
+		return fmt.Errorf("failed to migrate to projects: %v", err)
+	}
+
+	// Subscriptions are addressed by project and name alone, so names must be unique across the topics of a project.
+	_, err = s.db.ExecContext(ctx, "DROP INDEX IF EXISTS subscriptions_subscriber_id")
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_project_subscriber_id ON Subscriptions (project, subscriber_id)")
+	if err != nil {
+		return fmt.Errorf("subscription names must be unique: %v", err)
+	}
+
+	// Subscriptions created before expiration was introduced were last active when they were created.
+	_, err = s.db.ExecContext(ctx, "UPDATE Subscriptions SET last_active_at = created_at WHERE last_active_at IS NULL")
+	if err != nil {
+		return err
+	}
+
+	// Schemas created before revisions were introduced become revision 1.
+	_, err = s.db.ExecContext(ctx, `INSERT INTO SchemaRevisions (schema_id, revision, definition, message_type, created_at)
+        SELECT id, 1, definition, message_type, created_at FROM Schemas
+        WHERE id NOT IN (SELECT schema_id FROM SchemaRevisions)`)
+	return err
+}
+
+// The tables of resources are created from these definitions under the given name, so that they can be rebuilt.
+// Resources belong to a project, DefaultProject for those created before projects were introduced, and their names
+// are unique within it.
+const topicsTable = `CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        project TEXT NOT NULL DEFAULT '` + DefaultProject + `',
+        name TEXT NOT NULL,
+        metadata BLOB,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        max_message_bytes INTEGER NOT NULL DEFAULT 0,
+        max_attributes INTEGER NOT NULL DEFAULT 0,
+        max_attribute_key_bytes INTEGER NOT NULL DEFAULT 0,
+        max_attribute_value_bytes INTEGER NOT NULL DEFAULT 0,
+        schema_name TEXT,
+        schema_encoding TEXT,
+        schema_first_revision INTEGER NOT NULL DEFAULT 0,
+        schema_last_revision INTEGER NOT NULL DEFAULT 0,
+        compression TEXT NOT NULL DEFAULT '',
+        UNIQUE (project, name)
+    );`
+
+const schemasTable = `CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        project TEXT NOT NULL DEFAULT '` + DefaultProject + `',
+        name TEXT NOT NULL,
+        type TEXT NOT NULL,
+        definition TEXT NOT NULL,
+        message_type TEXT NOT NULL DEFAULT '',
+        revision INTEGER NOT NULL DEFAULT 1,
+        compatibility TEXT NOT NULL DEFAULT 'NONE',
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (project, name)
+    );`
+
+const snapshotsTable = `CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        project TEXT NOT NULL DEFAULT '` + DefaultProject + `',
+        name TEXT NOT NULL,
+        topic_id INTEGER NOT NULL,
+        subscription_id INTEGER,
+        created_at DATETIME NOT NULL,
+        expire_at DATETIME NOT NULL,
+        UNIQUE (project, name),
+        FOREIGN KEY (topic_id) REFERENCES Topics(id)
+    );`
+
+// subscriptionsTable creates the Subscriptions table under the given name. topic_id is NULL once the topic has been
+// deleted. Subscription names are unique within a project through an index instead, which Init creates.
+const subscriptionsTable = `CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        project TEXT NOT NULL DEFAULT '` + DefaultProject + `',
+        topic_id INTEGER,
+        subscriber_id TEXT NOT NULL,
+        metadata BLOB,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        detached BOOLEAN NOT NULL DEFAULT FALSE,
+        last_active_at DATETIME,
+        FOREIGN KEY (topic_id) REFERENCES Topics(id)
+    );`
+
+// migrateSubscriptions rebuilds Subscriptions tables created while topic_id was NOT NULL, since SQLite cannot drop
+// the constraint in place.
+func (s *Service) migrateSubscriptions(ctx context.Context) error {
+	var notNull bool
+	err := s.db.QueryRowContext(ctx, "SELECT \"notnull\" FROM pragma_table_info('Subscriptions') WHERE name = 'topic_id'").Scan(&notNull)
+	if err != nil || !notNull {
+		return err
+	}
+	return s.rebuildTable(ctx, "Subscriptions", subscriptionsTable)
+}
+
+// migrateProjects rebuilds the tables whose names were unique across the database before projects were introduced,
+// since SQLite cannot drop a UNIQUE constraint in place. Their rows move to DefaultProject.
+func (s *Service) migrateProjects(ctx context.Context) error {
+	for _, t := range []struct{ table, definition string }{
+		{"Topics", topicsTable},
+		{"Schemas", schemasTable},
+		{"Snapshots", snapshotsTable},
+	} {
+		migrated, err := s.hasColumn(ctx, t.table, "project")
+		if err != nil {
+			return err
+		}
+		if !migrated {
+			if err := s.rebuildTable(ctx, t.table, t.definition); err != nil {
+				return fmt.Errorf("failed to rebuild %s: %v", t.table, err)
+			}
+		}
+	}
 	return nil
 }
+
+// rebuildTable recreates a table from definition, which takes the table name, and copies its rows and ids over.
+// Columns the old table lacks get their default. Foreign keys are disabled on a dedicated connection for the duration
+// of the rebuild, as SQLite recommends, so that dropping the old table leaves the rows referencing it alone.
+func (s *Service) rebuildTable(ctx context.Context, table, definition string) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+table+"_new"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(definition, table+"_new")); err != nil {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?) WHERE name IN (SELECT name FROM pragma_table_info(?))",
+		table, table+"_new")
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, column)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	list := strings.Join(columns, ", ")
+	for _, stmt := range []string{
+		fmt.Sprintf("INSERT INTO %s_new (%s) SELECT %s FROM %s", table, list, list, table),
+		"DROP TABLE " + table,
+		fmt.Sprintf("ALTER TABLE %s_new RENAME TO %s", table, table),
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addedColumns lists columns introduced after the original schema. Init adds any that are missing so that databases
+// created by older versions keep working.
+var addedColumns = []struct{ table, column, definition string }{
+	{"Topics", "max_message_bytes", "INTEGER NOT NULL DEFAULT 0"},
+	{"Topics", "max_attributes", "INTEGER NOT NULL DEFAULT 0"},
+	{"Topics", "max_attribute_key_bytes", "INTEGER NOT NULL DEFAULT 0"},
+	{"Topics", "max_attribute_value_bytes", "INTEGER NOT NULL DEFAULT 0"},
+	{"Topics", "schema_name", "TEXT"},
+	{"Topics", "schema_encoding", "TEXT"},
+	{"Topics", "schema_first_revision", "INTEGER NOT NULL DEFAULT 0"},
+	{"Topics", "schema_last_revision", "INTEGER NOT NULL DEFAULT 0"},
+	{"Topics", "compression", "TEXT NOT NULL DEFAULT ''"},
+	{"Messages", "compression", "TEXT NOT NULL DEFAULT ''"},
+	{"Messages", "size", "INTEGER"},
+	{"Messages", "stored_size", "INTEGER"},
+	{"Messages", "delivery_attempt", "INTEGER NOT NULL DEFAULT 0"},
+	{"Messages", "publish_id", "INTEGER"},
+	{"SnapshotMessages", "publish_id", "INTEGER"},
+	{"Subscriptions", "detached", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"Subscriptions", "last_active_at", "DATETIME"},
+	{"Subscriptions", "project", "TEXT NOT NULL DEFAULT '" + DefaultProject + "'"},
+	{"Schemas", "message_type", "TEXT NOT NULL DEFAULT ''"},
+	{"Schemas", "revision", "INTEGER NOT NULL DEFAULT 1"},
+	{"Schemas", "compatibility", "TEXT NOT NULL DEFAULT 'NONE'"},
+}
+
+func (s *Service) addColumn(ctx context.Context, table, column, definition string) error {
+	exists, err := s.hasColumn(ctx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (s *Service) hasColumn(ctx context.Context, table, column string) (bool, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
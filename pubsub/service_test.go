@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "github.com/mattn/go-sqlite3"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -20,52 +21,53 @@ func TestService(t *testing.T) {
 	err = s.Init(ctx)
 	ok(t, err, "failed to initialize service")
 
-	err = s.CreateTopic(ctx, "topic1", []byte("metadata"))
+	err = s.CreateTopic(ctx, "topic1", &TopicConfig{Labels: map[string]string{"env": "test"}})
 	ok(t, err, "failed to create topic")
 
 	topic, err := s.GetTopic(ctx, "topic1")
 	ok(t, err, "failed to get topic")
 	equals(t, "topic1", topic.Name, "topic doesn't match expectation")
 
-	err = s.CreateSubscription(ctx, topic.ID, "subscriber1", []byte("metadata"))
+	err = s.CreateSubscription(ctx, topic.Name, "subscriber1", &SubscriptionConfig{AckDeadline: time.Minute})
 	ok(t, err, "failed to create subscription")
 
-	subscription, err := s.GetSubscription(ctx, topic.ID, "subscriber1")
+	subscription, err := s.GetSubscription(ctx, "subscriber1")
 	ok(t, err, "failed to get subscription")
 	equals(t, topic.ID, subscription.TopicID, "topic id doesn't match expectation")
 	equals(t, "subscriber1", subscription.SubscriberID, "subscriber id doesn't match expectation")
 
-	err = s.PublishMessage(ctx, topic.ID, "content", []byte("metadata"))
+	err = s.PublishMessage(ctx, topic.Name, "content", map[string]string{"key": "value"})
 	ok(t, err, "failed to publish message")
 
-	messages, err := s.GetMessages(ctx, subscription.ID)
+	messages, err := s.GetMessages(ctx, subscription.SubscriberID)
 	ok(t, err, "failed to get messages")
 	equals(t, 1, len(messages), "message count doesn't match expectation")
 	equals(t, "content", messages[0].Content, "message content doesn't match expectation")
+	equals(t, "value", messages[0].Attributes["key"], "message attributes don't match expectation")
 
 	message := messages[0]
 
 	now := time.Now()
-	messages, err = s.PullMessages(ctx, subscription.ID, now.Add(time.Second*10))
+	messages, err = s.PullMessages(ctx, subscription.SubscriberID, now.Add(time.Second*10))
 	ok(t, err, "failed to pull messages")
 	equals(t, 1, len(messages), "message count when pulling messages doesn't match expectation")
 	equals(t, "content", messages[0].Content, "message content when pulling messages doesn't match expectation")
 
-	messages, err = s.PullMessages(ctx, subscription.ID, now.Add(time.Second*10))
+	messages, err = s.PullMessages(ctx, subscription.SubscriberID, now.Add(time.Second*10))
 	ok(t, err, "failed to pull messages again")
 	equals(t, 0, len(messages), "message count when pulling messages again doesn't match expectation")
 
-	err = s.ModifyAckDeadline(ctx, subscription.ID, message.ID, now.Add(-time.Minute*12))
+	err = s.ModifyAckDeadline(ctx, subscription.SubscriberID, message.ID, now.Add(-time.Minute*12))
 	ok(t, err, "failed to modify ack deadline")
 
-	messages, err = s.PullMessages(ctx, subscription.ID, now.Add(time.Second*10))
+	messages, err = s.PullMessages(ctx, subscription.SubscriberID, now.Add(time.Second*10))
 	ok(t, err, "failed to pull messages after modifying ack deadline")
 	equals(t, 1, len(messages), "message count after modifying ack deadline doesn't match expectation")
 
-	err = s.AcknowledgeMessage(ctx, subscription.ID, message.ID)
+	err = s.AcknowledgeMessage(ctx, subscription.SubscriberID, message.ID)
 	ok(t, err, "failed to acknowledge message")
 
-	messages, err = s.PullMessages(ctx, subscription.ID, now.Add(time.Second*10))
+	messages, err = s.PullMessages(ctx, subscription.SubscriberID, now.Add(time.Second*10))
 	ok(t, err, "failed to pull messages after acknowledging message")
 	equals(t, 0, len(messages), "message count after acknowledging message doesn't match expectation")
 
@@ -73,6 +75,15 @@ func TestService(t *testing.T) {
 	t.Log("Test successful. Database removed")
 }
 
+// newTestService returns an initialized service backed by a temporary database.
+func newTestService(t *testing.T) *Service {
+	s, err := NewService(filepath.Join(t.TempDir(), DefaultFilename))
+	ok(t, err, "failed to create service")
+	t.Cleanup(func() { _ = s.Close() })
+	ok(t, s.Init(context.Background()), "failed to initialize service")
+	return s
+}
+
 func equals(t *testing.T, expected, actual interface{}, desc string) {
 	if expected != actual {
 		_ = os.Remove("pubsub.db")
@@ -86,3 +97,31 @@ func ok(t *testing.T, err error, desc string) {
 		t.Fatalf("error: %v", err)
 	}
 }
+
+func TestExpireLeases(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", nil), "failed to create subscription")
+	ok(t, s.PublishMessage(ctx, "orders", "first", nil), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "orders", "second", nil), "failed to publish message")
+
+	now := time.Now()
+	_, err := s.Pull(ctx, "billing", 1, now.Add(time.Minute))
+	ok(t, err, "failed to pull messages")
+	_, err = s.Pull(ctx, "billing", 1, now.Add(time.Second))
+	ok(t, err, "failed to pull messages")
+
+	n, err := s.ExpireLeases(ctx, now.Add(2*time.Second))
+	ok(t, err, "failed to expire leases")
+	equals(t, 1, n, "only the lease past its deadline should expire")
+	messages, err := s.GetMessages(ctx, "billing")
+	ok(t, err, "failed to get messages")
+	leased := 0
+	for _, message := range messages {
+		if message.AckDeadline.Valid {
+			leased++
+		}
+	}
+	equals(t, 1, leased, "the lease still running should be kept")
+}
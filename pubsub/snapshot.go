@@ -0,0 +1,343 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultSnapshotLifetime is how long snapshots are kept unless another lifetime is chosen.
+const DefaultSnapshotLifetime = 7 * 24 * time.Hour
+
+// Snapshot captures the acknowledgement state of a subscription at a point in time.
+type Snapshot struct {
+	ID           int
+	Project      string
+	Name         string
+	TopicID      int
+	TopicProject string
+	Topic        string
+	Subscription string // Source subscription, empty once it has been deleted
+	CreatedAt    time.Time
+	ExpireAt     time.Time
+	Messages     int // Messages that were outstanding when the snapshot was taken
+}
+
+const snapshotQuery = `SELECT sn.id, sn.project, sn.name, sn.topic_id, t.project, t.name, COALESCE(s.subscriber_id, ''), sn.created_at, sn.expire_at,
+    (SELECT COUNT(*) FROM SnapshotMessages sm WHERE sm.snapshot_id = sn.id)
+    FROM Snapshots sn JOIN Topics t ON t.id = sn.topic_id LEFT JOIN Subscriptions s ON s.id = sn.subscription_id`
+
+// ResourceName returns the full resource path of the snapshot, e.g. "projects/my-project/snapshots/before-deploy".
+func (s *Snapshot) ResourceName() string {
+	return ResourceName(s.Project, "snapshots", s.Name)
+}
+
+// TopicResourceName returns the full resource path of the snapshot's topic.
+func (s *Snapshot) TopicResourceName() string {
+	return ResourceName(s.TopicProject, "topics", s.Topic)
+}
+
+func scanSnapshot(row interface{ Scan(...any) error }) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	err := row.Scan(&snapshot.ID, &snapshot.Project, &snapshot.Name, &snapshot.TopicID, &snapshot.TopicProject, &snapshot.Topic, &snapshot.Subscription,
+		&snapshot.CreatedAt, &snapshot.ExpireAt, &snapshot.Messages)
+	return snapshot, err
+}
+
+// CreateSnapshot captures which messages of a subscription are outstanding. The snapshot expires after lifetime, or
+// DefaultSnapshotLifetime when lifetime is zero.
+func (s *Service) CreateSnapshot(ctx context.Context, name, subscriptionName string, lifetime time.Duration) (*Snapshot, error) {
+	project, id, err := resolveName(ctx, name, "snapshots")
+	if err != nil {
+		return nil, err
+	}
+	if lifetime < 0 {
+		return nil, fmt.Errorf("invalid snapshot lifetime %s", lifetime)
+	}
+	if lifetime == 0 {
+		lifetime = DefaultSnapshotLifetime
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := s.subscriptionByName(ctx, tx, subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Detached {
+		return nil, fmt.Errorf("cannot snapshot subscription %q: %w", subscriptionName, ErrDetached)
+	}
+
+	now := time.Now()
+	res, err := tx.ExecContext(ctx, "INSERT INTO Snapshots (project, name, topic_id, subscription_id, created_at, expire_at) VALUES (?, ?, ?, ?, ?, ?)",
+		project, id, subscription.TopicID, subscription.ID, dbTime(now), dbTime(now.Add(lifetime)))
+	if err != nil {
+		return nil, alreadyExists(err, "snapshot", name)
+	}
+	snapshotID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO SnapshotMessages (snapshot_id, message_id, content, compression, size, stored_size, metadata, publish_id, published_at)
+        SELECT ?, id, content, compression, size, stored_size, metadata, publish_id, published_at FROM Messages
+        WHERE subscription_id = ? AND acknowledged = 0`, snapshotID, subscription.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := scanSnapshot(tx.QueryRowContext(ctx, snapshotQuery+" WHERE sn.id = ?", snapshotID))
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, tx.Commit()
+}
+
+func (s *Service) snapshotByName(ctx context.Context, q querier, name string) (*Snapshot, error) {
+	project, id, err := resolveName(ctx, name, "snapshots")
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := scanSnapshot(q.QueryRowContext(ctx, snapshotQuery+" WHERE sn.project = ? AND sn.name = ? AND sn.expire_at > ?",
+		project, id, dbTime(time.Now())))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &NotFoundError{Resource: "snapshot", Name: name}
+	}
+	return snapshot, err
+}
+
+// GetSnapshot returns a snapshot by name or resource path. Expired snapshots are not found.
+func (s *Service) GetSnapshot(ctx context.Context, name string) (*Snapshot, error) {
+	return s.snapshotByName(ctx, s.db, name)
+}
+
+// ListSnapshots returns the snapshots of the project that have not expired, oldest first.
+func (s *Service) ListSnapshots(ctx context.Context) ([]*Snapshot, error) {
+	rows, err := s.db.QueryContext(ctx, snapshotQuery+" WHERE sn.project = ? AND sn.expire_at > ? ORDER BY sn.created_at, sn.id",
+		contextProject(ctx), dbTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []*Snapshot
+	for rows.Next() {
+		snapshot, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+// DeleteSnapshot deletes a snapshot. Subscriptions that were seeked to it are not affected.
+func (s *Service) DeleteSnapshot(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	snapshot, err := s.snapshotByName(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	if err := deleteSnapshots(ctx, tx, "id = ?", snapshot.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteSnapshots deletes the snapshots matching a condition on the Snapshots table, with their messages.
+func deleteSnapshots(ctx context.Context, q querier, where string, args ...any) error {
+	_, err := q.ExecContext(ctx, "DELETE FROM SnapshotMessages WHERE snapshot_id IN (SELECT id FROM Snapshots WHERE "+where+")", args...)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, "DELETE FROM Snapshots WHERE "+where, args...)
+	return err
+}
+
+// SeekToSnapshot restores the acknowledgement state captured by a snapshot. Messages that were outstanding in the
+// snapshot, and messages published after it was taken, become unacknowledged with their leases cleared; every other
+// message is acknowledged. Outstanding messages that have since been deleted are restored from the snapshot. The
+// subscription must belong to the snapshot's topic.
+func (s *Service) SeekToSnapshot(ctx context.Context, subscriptionName, snapshotName string) (*SeekResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := s.subscriptionByName(ctx, tx, subscriptionName)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Detached {
+		return nil, fmt.Errorf("cannot seek subscription %q: %w", subscriptionName, ErrDetached)
+	}
+	snapshot, err := s.snapshotByName(ctx, tx, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	result, err := seekToSnapshot(ctx, tx, subscription, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+// CreateSubscriptionFromSnapshot creates a subscription on the snapshot's topic whose backlog is the snapshot's
+// outstanding messages followed by everything published since the snapshot was taken. Messages published since are
+// copied from the snapshot's subscription, and from the messages the topic retains, if either still has them. Like
+// Backfill, messages that do not pass the new subscription's filter are skipped.
+func (s *Service) CreateSubscriptionFromSnapshot(ctx context.Context, name, snapshotName string, config *SubscriptionConfig) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	snapshot, err := s.snapshotByName(ctx, tx, snapshotName)
+	if err != nil {
+		return err
+	}
+	topic, err := scanTopic(tx.QueryRowContext(ctx, "SELECT "+topicColumns+" FROM Topics WHERE id = ?", snapshot.TopicID))
+	if err != nil {
+		return err
+	}
+	id, err := s.createSubscription(ctx, tx, topic, name, config)
+	if err != nil {
+		return err
+	}
+	subscription, err := scanSubscription(tx.QueryRowContext(ctx, "SELECT "+subscriptionColumns+" FROM Subscriptions WHERE id = ?", id))
+	if err != nil {
+		return err
+	}
+	if _, err := seekToSnapshot(ctx, tx, subscription, snapshot); err != nil {
+		return err
+	}
+	if err := copySince(ctx, tx, subscription, snapshot); err != nil {
+		return err
+	}
+	if _, err := backfill(ctx, tx, subscription, snapshot.CreatedAt, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// copySince copies the messages the snapshot's subscription received since the snapshot was taken into subscription,
+// as outstanding messages.
+func copySince(ctx context.Context, tx *sql.Tx, subscription *Subscription, snapshot *Snapshot) error {
+	if subscription.Config.Forward != nil {
+		return nil
+	}
+	f, err := compileFilter(subscription.Config.Filter)
+	if err != nil {
+		return err
+	}
+
+	type received struct {
+		publishID   sql.NullInt64
+		content     []byte
+		compression Compression
+		size        sql.NullInt64
+		storedSize  sql.NullInt64
+		metadata    []byte
+		publishedAt time.Time
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT publish_id, content, compression, size, stored_size, metadata, published_at FROM Messages m
+        WHERE subscription_id = (SELECT subscription_id FROM Snapshots WHERE id = ?) AND published_at >= ?
+        AND NOT EXISTS (SELECT 1 FROM Messages o WHERE o.subscription_id = ? AND o.publish_id = m.publish_id)
+        ORDER BY id`,
+		snapshot.ID, dbTime(snapshot.CreatedAt), subscription.ID)
+	if err != nil {
+		return err
+	}
+	var messages []received
+	for rows.Next() {
+		var m received
+		if err := rows.Scan(&m.publishID, &m.content, &m.compression, &m.size, &m.storedSize, &m.metadata, &m.publishedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		if f != nil {
+			attributes, err := decodeAttributes(m.metadata)
+			if err != nil {
+				return err
+			}
+			if !f.match(attributes) {
+				continue
+			}
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO Messages (topic_id, subscription_id, publish_id, content, compression, size, stored_size, metadata, published_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			subscription.TopicID, subscription.ID, m.publishID, m.content, m.compression, m.size, m.storedSize, m.metadata, dbTime(m.publishedAt))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seekToSnapshot(ctx context.Context, tx *sql.Tx, subscription *Subscription, snapshot *Snapshot) (*SeekResult, error) {
+	if subscription.TopicID != snapshot.TopicID {
+		return nil, fmt.Errorf("snapshot %q was taken on topic %q, which the subscription does not belong to", snapshot.Name, snapshot.Topic)
+	}
+	created := dbTime(snapshot.CreatedAt)
+	result := &SeekResult{}
+
+	// Messages published after the snapshot, or outstanding in it, are redelivered.
+	res, err := tx.ExecContext(ctx, `UPDATE Messages SET acknowledged = 0, ack_deadline = NULL
+        WHERE subscription_id = ? AND (acknowledged = 1 OR ack_deadline IS NOT NULL)
+        AND (published_at >= ? OR id IN (SELECT message_id FROM SnapshotMessages WHERE snapshot_id = ?))`,
+		subscription.ID, created, snapshot.ID)
+	if err != nil {
+		return nil, err
+	}
+	if result.Redelivered, err = rowsAffected(res); err != nil {
+		return nil, err
+	}
+
+	res, err = tx.ExecContext(ctx, `UPDATE Messages SET acknowledged = 1
+        WHERE subscription_id = ? AND acknowledged = 0 AND published_at < ?
+        AND id NOT IN (SELECT message_id FROM SnapshotMessages WHERE snapshot_id = ?)`,
+		subscription.ID, created, snapshot.ID)
+	if err != nil {
+		return nil, err
+	}
+	if result.Acknowledged, err = rowsAffected(res); err != nil {
+		return nil, err
+	}
+
+	// Outstanding messages the subscription no longer has, or never had, are copied from the snapshot.
+	res, err = tx.ExecContext(ctx, `INSERT INTO Messages (topic_id, subscription_id, content, compression, size, stored_size, metadata, publish_id, published_at)
+        SELECT ?, ?, content, compression, size, stored_size, metadata, publish_id, published_at FROM SnapshotMessages sm
+        WHERE snapshot_id = ? AND NOT EXISTS (SELECT 1 FROM Messages m WHERE m.subscription_id = ?
+            AND (m.id = sm.message_id OR m.publish_id = sm.publish_id))
+        ORDER BY sm.message_id`,
+		subscription.TopicID, subscription.ID, snapshot.ID, subscription.ID)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := rowsAffected(res)
+	if err != nil {
+		return nil, err
+	}
+	result.Redelivered += restored
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateTopic(ctx, "refunds", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", nil), "failed to create subscription")
+	ok(t, s.CreateSubscription(ctx, "refunds", "refunds", nil), "failed to create subscription")
+	for _, content := range []string{"first", "second", "third"} {
+		ok(t, s.PublishMessage(ctx, "orders", content, nil), "failed to publish message")
+	}
+
+	messages, err := s.PullMessages(ctx, "billing", time.Now().Add(time.Hour))
+	ok(t, err, "failed to pull messages")
+	ok(t, s.AcknowledgeMessage(ctx, "billing", messages[0].ID), "failed to ack message")
+
+	snapshot, err := s.CreateSnapshot(ctx, "projects/default/snapshots/before-deploy", "billing", time.Hour)
+	ok(t, err, "failed to create snapshot")
+	equals(t, "before-deploy", snapshot.Name, "snapshot name doesn't match")
+	equals(t, "orders", snapshot.Topic, "snapshot topic doesn't match")
+	equals(t, 2, snapshot.Messages, "snapshot should capture the outstanding messages")
+
+	// A bad deploy acknowledges everything and the acked messages are collected.
+	time.Sleep(5 * time.Millisecond)
+	ok(t, s.PublishMessage(ctx, "orders", "fourth", nil), "failed to publish message")
+	messages, err = s.PullMessages(ctx, "billing", time.Now().Add(time.Hour))
+	ok(t, err, "failed to pull messages")
+	for _, message := range messages {
+		ok(t, s.AcknowledgeMessage(ctx, "billing", message.ID), "failed to ack message")
+	}
+	_, err = s.GC(ctx, time.Now())
+	ok(t, err, "failed to collect garbage")
+
+	result, err := s.SeekToSnapshot(ctx, "billing", "before-deploy")
+	ok(t, err, "failed to seek to snapshot")
+	equals(t, SeekResult{Redelivered: 2, Acknowledged: 0}, *result, "seek result doesn't match")
+	messages, err = s.PullMessages(ctx, "billing", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 2, len(messages), "snapshot messages should be restored")
+	equals(t, "second", messages[0].Content, "wrong message restored")
+
+	ok(t, s.CreateSubscriptionFromSnapshot(ctx, "replay", "before-deploy", nil), "failed to seed subscription")
+	replay, err := s.GetSubscription(ctx, "replay")
+	ok(t, err, "failed to get seeded subscription")
+	equals(t, snapshot.TopicID, replay.TopicID, "seeded subscription should be on the snapshot's topic")
+	messages, err = s.GetMessages(ctx, "replay")
+	ok(t, err, "failed to get messages")
+	equals(t, 2, len(messages), "seeded subscription should receive the snapshot's messages")
+
+	if _, err := s.SeekToSnapshot(ctx, "refunds", "before-deploy"); err == nil {
+		t.Fatal("expected seeking a subscription on another topic to fail")
+	}
+
+	snapshots, err := s.ListSnapshots(ctx)
+	ok(t, err, "failed to list snapshots")
+	equals(t, 1, len(snapshots), "expected one snapshot")
+	ok(t, s.DeleteSnapshot(ctx, "before-deploy"), "failed to delete snapshot")
+	if _, err := s.GetSnapshot(ctx, "before-deploy"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted snapshot to be gone, got %v", err)
+	}
+
+	// Snapshots expire after their lifetime and are removed by GC, as are those of deleted topics.
+	_, err = s.CreateSnapshot(ctx, "short", "billing", time.Millisecond)
+	ok(t, err, "failed to create snapshot")
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.GetSnapshot(ctx, "short"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected expired snapshot to be gone, got %v", err)
+	}
+	_, err = s.CreateSnapshot(ctx, "kept", "billing", 0)
+	ok(t, err, "failed to create snapshot")
+	ok(t, s.DeleteTopic(ctx, "orders"), "failed to delete topic with snapshots")
+}
+
+func TestCreateSubscriptionFromSnapshot(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", nil), "failed to create topic")
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", nil), "failed to create subscription")
+	ok(t, s.PublishMessage(ctx, "orders", "before", nil), "failed to publish message")
+	_, err := s.CreateSnapshot(ctx, "before-deploy", "billing", time.Hour)
+	ok(t, err, "failed to create snapshot")
+
+	// Messages published after the snapshot are part of the new backlog, even once the source has acknowledged them.
+	time.Sleep(5 * time.Millisecond)
+	ok(t, s.PublishMessage(ctx, "orders", "after", nil), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "orders", "filtered", map[string]string{"region": "us"}), "failed to publish message")
+	messages, err := s.PullMessages(ctx, "billing", time.Now().Add(time.Hour))
+	ok(t, err, "failed to pull messages")
+	for _, message := range messages {
+		ok(t, s.AcknowledgeMessage(ctx, "billing", message.ID), "failed to ack message")
+	}
+
+	config := &SubscriptionConfig{Filter: `NOT attributes:region`}
+	ok(t, s.CreateSubscriptionFromSnapshot(ctx, "replay", "before-deploy", config), "failed to seed subscription")
+	messages, err = s.PullMessages(ctx, "replay", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 2, len(messages), "seeded subscription should receive the snapshot's messages and those published since")
+	equals(t, "before", messages[0].Content, "wrong message delivered")
+	equals(t, "after", messages[1].Content, "wrong message delivered")
+}
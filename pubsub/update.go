@@ -0,0 +1,174 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Update masks name configuration fields as they are written in configuration files. Fields of nested policies can
+// be named individually, e.g. "retryPolicy.maximumBackoff".
+var topicFields = map[string]func(dst, src *TopicConfig){
+	"labels":                   func(dst, src *TopicConfig) { dst.Labels = src.Labels },
+	"messageRetentionDuration": func(dst, src *TopicConfig) { dst.MessageRetentionDuration = src.MessageRetentionDuration },
+}
+
+var subscriptionFields = map[string]func(dst, src *SubscriptionConfig){
+	"labels":                   func(dst, src *SubscriptionConfig) { dst.Labels = src.Labels },
+	"ackDeadline":              func(dst, src *SubscriptionConfig) { dst.AckDeadline = src.AckDeadline },
+	"messageRetentionDuration": func(dst, src *SubscriptionConfig) { dst.MessageRetentionDuration = src.MessageRetentionDuration },
+	"retainAckedMessages":      func(dst, src *SubscriptionConfig) { dst.RetainAckedMessages = src.RetainAckedMessages },
+	"filter":                   func(dst, src *SubscriptionConfig) { dst.Filter = src.Filter },
+	"retryPolicy":              func(dst, src *SubscriptionConfig) { dst.RetryPolicy = src.RetryPolicy },
+	"retryPolicy.minimumBackoff": func(dst, src *SubscriptionConfig) {
+		dst.RetryPolicy = mergeRetryPolicy(dst.RetryPolicy, src.RetryPolicy, func(dst, src *RetryPolicy) { dst.MinimumBackoff = src.MinimumBackoff })
+	},
+	"retryPolicy.maximumBackoff": func(dst, src *SubscriptionConfig) {
+		dst.RetryPolicy = mergeRetryPolicy(dst.RetryPolicy, src.RetryPolicy, func(dst, src *RetryPolicy) { dst.MaximumBackoff = src.MaximumBackoff })
+	},
+	"expirationPolicy":     func(dst, src *SubscriptionConfig) { dst.ExpirationPolicy = src.ExpirationPolicy },
+	"expirationPolicy.ttl": func(dst, src *SubscriptionConfig) { dst.ExpirationPolicy = src.ExpirationPolicy },
+	"forward":              func(dst, src *SubscriptionConfig) { dst.Forward = src.Forward },
+	"pushConfig":           func(dst, src *SubscriptionConfig) { dst.PushConfig = src.PushConfig },
+	"pushConfig.pushEndpoint": func(dst, src *SubscriptionConfig) {
+		dst.PushConfig = mergePushConfig(dst.PushConfig, src.PushConfig, func(dst, src *PushConfig) { dst.Endpoint = src.Endpoint })
+	},
+	"pushConfig.cloudEvents": func(dst, src *SubscriptionConfig) {
+		dst.PushConfig = mergePushConfig(dst.PushConfig, src.PushConfig, func(dst, src *PushConfig) { dst.CloudEvents = src.CloudEvents })
+	},
+}
+
+// mergeRetryPolicy copies one field of src into a copy of dst, treating missing policies as empty.
+func mergeRetryPolicy(dst, src *RetryPolicy, set func(dst, src *RetryPolicy)) *RetryPolicy {
+	merged := &RetryPolicy{}
+	if dst != nil {
+		*merged = *dst
+	}
+	if src == nil {
+		src = &RetryPolicy{}
+	}
+	set(merged, src)
+	return merged
+}
+
+// mergePushConfig copies one field of src into a copy of dst, treating missing push configurations as empty.
+func mergePushConfig(dst, src *PushConfig, set func(dst, src *PushConfig)) *PushConfig {
+	merged := &PushConfig{}
+	if dst != nil {
+		*merged = *dst
+	}
+	if src == nil {
+		src = &PushConfig{}
+	}
+	set(merged, src)
+	return merged
+}
+
+// applyMask copies the masked fields from src to dst. Fields named in the mask but unset in src are reset to their
+// defaults.
+func applyMask[C any](fields map[string]func(dst, src *C), dst, src *C, mask []string) error {
+	if len(mask) == 0 {
+		return &ConfigError{Field: "updateMask", Reason: "at least one field must be updated"}
+	}
+	var errs []error
+	for _, path := range mask {
+		if _, ok := fields[path]; !ok {
+			errs = append(errs, &ConfigError{Field: path, Reason: "unknown or immutable field"})
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for _, path := range mask {
+		fields[path](dst, src)
+	}
+	return nil
+}
+
+// UpdateTopic changes the fields of a topic's configuration named in the mask and returns the updated topic.
+func (s *Service) UpdateTopic(ctx context.Context, name string, config *TopicConfig, mask []string) (*Topic, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	topic, err := s.topicByName(ctx, tx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyMask(topicFields, &topic.Config, config, mask); err != nil {
+		return nil, err
+	}
+	if err := topic.Config.Validate(); err != nil {
+		return nil, err
+	}
+	metadata, err := encodeConfig(&topic.Config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE Topics SET metadata = ? WHERE id = ?", metadata, topic.ID); err != nil {
+		return nil, err
+	}
+	return topic, tx.Commit()
+}
+
+// UpdateSubscription changes the fields of a subscription's configuration named in the mask and returns the updated
+// subscription. Changes apply to future deliveries; leases on messages that are already pulled are kept.
+func (s *Service) UpdateSubscription(ctx context.Context, name string, config *SubscriptionConfig, mask []string) (*Subscription, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	subscription, err := s.subscriptionByName(ctx, tx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyMask(subscriptionFields, &subscription.Config, config, mask); err != nil {
+		return nil, err
+	}
+	if err := subscription.Config.Validate(); err != nil {
+		return nil, err
+	}
+	// Detached subscriptions never forward, and may no longer have a topic.
+	if !subscription.Detached {
+		topic, err := scanTopic(tx.QueryRowContext(ctx, "SELECT "+topicColumns+" FROM Topics WHERE id = ?", subscription.TopicID))
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkForward(WithProject(ctx, subscription.Project), tx, topic, &subscription.Config); err != nil {
+			return nil, err
+		}
+	}
+	metadata, err := encodeConfig(&subscription.Config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE Subscriptions SET metadata = ? WHERE id = ?", metadata, subscription.ID); err != nil {
+		return nil, err
+	}
+	return subscription, tx.Commit()
+}
+
+// ConfigFields lists the fields set in a YAML or JSON configuration document, for use as an update mask. Nested
+// policies are listed as a whole, e.g. "retryPolicy", so the policy in the document replaces the current one; only a
+// mask naming nested fields, e.g. "pushConfig.cloudEvents", changes them individually.
+func ConfigFields(data []byte) ([]string, error) {
+	var doc map[string]any
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	fields := make([]string, 0, len(doc))
+	for field := range doc {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
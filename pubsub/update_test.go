@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	ok(t, s.CreateTopic(ctx, "orders", &TopicConfig{Labels: map[string]string{"team": "payments"}}), "failed to create topic")
+	topic, err := s.UpdateTopic(ctx, "orders", &TopicConfig{MessageRetentionDuration: time.Hour}, []string{"messageRetentionDuration"})
+	ok(t, err, "failed to update topic")
+	equals(t, time.Hour, topic.Config.MessageRetentionDuration, "retention should be updated")
+	equals(t, "payments", topic.Config.Labels["team"], "fields outside the mask should be kept")
+
+	config := &SubscriptionConfig{
+		Labels:      map[string]string{"team": "payments"},
+		RetryPolicy: &RetryPolicy{MinimumBackoff: 20 * time.Second},
+	}
+	ok(t, s.CreateSubscription(ctx, "orders", "billing", config), "failed to create subscription")
+	ok(t, s.PublishMessage(ctx, "orders", "first", nil), "failed to publish message")
+	leased, err := s.PullMessages(ctx, "billing", time.Now().Add(time.Hour))
+	ok(t, err, "failed to pull messages")
+	equals(t, 1, len(leased), "expected a message to lease")
+
+	update := &SubscriptionConfig{
+		AckDeadline: time.Minute,
+		Filter:      `attributes:priority`,
+		RetryPolicy: &RetryPolicy{MaximumBackoff: time.Minute},
+	}
+	subscription, err := s.UpdateSubscription(ctx, "billing", update, []string{"ackDeadline", "filter", "labels", "retryPolicy.maximumBackoff"})
+	ok(t, err, "failed to update subscription")
+	equals(t, time.Minute, subscription.Config.AckDeadline, "ack deadline should be updated")
+	equals(t, 0, len(subscription.Config.Labels), "masked fields missing from the update should be cleared")
+	equals(t, 20*time.Second, subscription.Config.RetryPolicy.MinimumBackoff, "unmasked policy fields should be kept")
+	equals(t, time.Minute, subscription.Config.RetryPolicy.MaximumBackoff, "masked policy fields should be updated")
+
+	stored, err := s.GetSubscription(ctx, "billing")
+	ok(t, err, "failed to get subscription")
+	equals(t, `attributes:priority`, stored.Config.Filter, "update should be stored")
+
+	// The outstanding lease is kept, and the new filter applies to later messages only.
+	messages, err := s.PullMessages(ctx, "billing", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 0, len(messages), "leased message should not be redelivered")
+	ok(t, s.PublishMessage(ctx, "orders", "second", nil), "failed to publish message")
+	ok(t, s.PublishMessage(ctx, "orders", "third", map[string]string{"priority": "high"}), "failed to publish message")
+	messages, err = s.PullMessages(ctx, "billing", time.Time{})
+	ok(t, err, "failed to pull messages")
+	equals(t, 1, len(messages), "filter should apply to new messages")
+	equals(t, "third", messages[0].Content, "wrong message delivered")
+
+	for _, tt := range []struct {
+		desc   string
+		config *SubscriptionConfig
+		mask   []string
+	}{
+		{"empty mask", update, nil},
+		{"unknown field", update, []string{"topic"}},
+		{"invalid value", &SubscriptionConfig{AckDeadline: time.Hour}, []string{"ackDeadline"}},
+	} {
+		if _, err := s.UpdateSubscription(ctx, "billing", tt.config, tt.mask); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("%s: expected an invalid config error, got %v", tt.desc, err)
+		}
+	}
+
+	fields, err := ConfigFields([]byte("ackDeadline: 20s\nretryPolicy:\n  minimumBackoff: 1s\n"))
+	ok(t, err, "failed to list config fields")
+	equals(t, "ackDeadline,retryPolicy", fields[0]+","+fields[1], "fields don't match")
+}
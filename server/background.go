@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// BackgroundOptions sets how often a long-running server does its periodic work. A zero interval disables the work.
+type BackgroundOptions struct {
+	// GCInterval is how often messages that are no longer retained, expired subscriptions and expired snapshots are
+	// deleted, see pubsub.Service.GC.
+	GCInterval time.Duration
+	// LeaseInterval is how often expired leases are cleared, see pubsub.Service.ExpireLeases.
+	LeaseInterval time.Duration
+	// PushInterval is how often push subscriptions are checked for messages to push.
+	PushInterval time.Duration
+	// Logf reports the work done and its failures. It defaults to log.Printf.
+	Logf func(format string, args ...any)
+}
+
+// RunBackground does the periodic work of svc until ctx is done, and returns once the work in progress has stopped.
+func RunBackground(ctx context.Context, svc *pubsub.Service, opts BackgroundOptions) {
+	logf := opts.Logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	pusher := newPusher(svc, logf)
+
+	var wg sync.WaitGroup
+	every := func(interval time.Duration, work func(ctx context.Context)) {
+		if interval <= 0 {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					work(ctx)
+				}
+			}
+		}()
+	}
+
+	every(opts.GCInterval, func(ctx context.Context) {
+		deleted, err := svc.GC(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			logf("Error deleting expired messages: %v", err)
+		} else if deleted > 0 {
+			logf("Deleted %d message(s)", deleted)
+		}
+	})
+	every(opts.LeaseInterval, func(ctx context.Context) {
+		if _, err := svc.ExpireLeases(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			logf("Error expiring leases: %v", err)
+		}
+	})
+	every(opts.PushInterval, pusher.dispatch)
+	wg.Wait()
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	service "github.com/nigel-campbell/pubsub/pubsub"
+)
+
+func TestPush(t *testing.T) {
+	ctx := context.Background()
+	svc, err := service.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	defer svc.Close()
+	if err := svc.Init(ctx); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+
+	var mu sync.Mutex
+	var pushed []pushRequest
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req pushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode push request: %v", err)
+		}
+		mu.Lock()
+		pushed = append(pushed, req)
+		mu.Unlock()
+	}))
+	defer endpoint.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	if err := svc.CreateTopic(ctx, "orders", nil); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	for name, url := range map[string]string{"billing": endpoint.URL, "broken": failing.URL} {
+		config := &service.SubscriptionConfig{PushConfig: &service.PushConfig{Endpoint: url}}
+		if err := svc.CreateSubscription(ctx, "orders", name, config); err != nil {
+			t.Fatalf("failed to create subscription: %v", err)
+		}
+	}
+	if err := svc.PublishMessage(ctx, "orders", "hello", map[string]string{"region": "eu"}); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	// A subscription of another project with the same name is pushed its own messages.
+	if err := svc.CreateTopic(ctx, "projects/team-b/topics/orders", nil); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	config := &service.SubscriptionConfig{PushConfig: &service.PushConfig{Endpoint: endpoint.URL}}
+	if err := svc.CreateSubscription(ctx, "projects/team-b/topics/orders", "projects/team-b/subscriptions/billing", config); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	if err := svc.PublishMessage(ctx, "projects/team-b/topics/orders", "hallo", nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+
+	bgCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		RunBackground(bgCtx, svc, BackgroundOptions{PushInterval: 10 * time.Millisecond, Logf: t.Logf})
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		accepted, err := svc.GetMessages(ctx, "billing")
+		if err != nil {
+			t.Fatalf("failed to get messages: %v", err)
+		}
+		rejected, err := svc.GetMessages(ctx, "broken")
+		if err != nil {
+			t.Fatalf("failed to get messages: %v", err)
+		}
+		other, err := svc.GetMessages(ctx, "projects/team-b/subscriptions/billing")
+		if err != nil {
+			t.Fatalf("failed to get messages: %v", err)
+		}
+		if len(accepted) == 1 && accepted[0].Acknowledged && len(rejected) == 1 && rejected[0].DeliveryAttempt > 0 &&
+			len(other) == 1 && other[0].Acknowledged {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected every message to be pushed and the accepted ones acknowledged, got %v, %v and %v", accepted, rejected, other)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pushed) != 2 {
+		t.Fatalf("expected two pushes, got %d", len(pushed))
+	}
+	sort.Slice(pushed, func(i, j int) bool { return pushed[i].Subscription < pushed[j].Subscription })
+	if m := pushed[0].Message; string(m.Data) != "hello" || m.Attributes["region"] != "eu" || m.MessageID == "" || m.PublishTime.IsZero() {
+		t.Fatalf("unexpected pushed message %+v", m)
+	}
+	if pushed[0].Subscription != "projects/default/subscriptions/billing" {
+		t.Fatalf("unexpected subscription %q", pushed[0].Subscription)
+	}
+	if pushed[1].Subscription != "projects/team-b/subscriptions/billing" || string(pushed[1].Message.Data) != "hallo" {
+		t.Fatalf("unexpected push %+v", pushed[1])
+	}
+
+	messages, err := svc.GetMessages(ctx, "broken")
+	if err != nil {
+		t.Fatalf("failed to get messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Acknowledged {
+		t.Fatalf("expected the rejected message to stay outstanding, got %v", messages)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// cloudEventHeaderPrefix prefixes the headers holding the attributes of a CloudEvent in the binary mode of the HTTP
+// binding, e.g. ce-id and ce-source.
+const cloudEventHeaderPrefix = "ce-"
+
+// readCloudEvent reads the CloudEvent a request carries in the binary or structured mode of the CloudEvents HTTP
+// binding. It returns nil for requests that carry no CloudEvent.
+func readCloudEvent(r *http.Request) (*pubsub.CloudEvent, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	structured := mediaType == pubsub.CloudEventsContentType
+	if !structured && r.Header.Get(cloudEventHeaderPrefix+"specversion") == "" {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	if structured {
+		return pubsub.ParseCloudEvent(body)
+	}
+
+	event := &pubsub.CloudEvent{
+		Attributes:      map[string]string{},
+		DataContentType: r.Header.Get("Content-Type"),
+		Data:            string(body),
+	}
+	for key, values := range r.Header {
+		name, ok := strings.CutPrefix(strings.ToLower(key), cloudEventHeaderPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CloudEvent header %s: %v", key, err)
+		}
+		event.Attributes[name] = value
+	}
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// writeCloudEvent encodes an event as the body and headers of a request in the binary or structured mode.
+func writeCloudEvent(header http.Header, event *pubsub.CloudEvent, mode pubsub.CloudEventsMode) ([]byte, error) {
+	if mode == pubsub.CloudEventsStructured {
+		header.Set("Content-Type", pubsub.CloudEventsContentType)
+		return json.Marshal(event)
+	}
+	for name, value := range event.Attributes {
+		header.Set(cloudEventHeaderPrefix+name, escapeHeaderValue(value))
+	}
+	if event.DataContentType != "" {
+		header.Set("Content-Type", event.DataContentType)
+	}
+	return []byte(event.Data), nil
+}
+
+// escapeHeaderValue percent-encodes the characters the HTTP binding requires to be: spaces, double quotes, percent
+// signs and everything outside of printable ASCII.
+func escapeHeaderValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	service "github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// pushedRequest is a request received by a push endpoint.
+type pushedRequest struct {
+	header http.Header
+	body   string
+}
+
+func TestCloudEvents(t *testing.T) {
+	ctx := service.WithProject(context.Background(), "test")
+	svc, err := service.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	defer svc.Close()
+	if err := svc.Init(ctx); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+	srv := httptest.NewServer(NewREST(svc))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	pushed := map[string][]pushedRequest{}
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		pushed[r.URL.Path] = append(pushed[r.URL.Path], pushedRequest{header: r.Header, body: string(body)})
+		mu.Unlock()
+	}))
+	defer endpoint.Close()
+
+	if err := svc.CreateTopic(ctx, "orders", nil); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	for _, mode := range []service.CloudEventsMode{service.CloudEventsBinary, service.CloudEventsStructured} {
+		config := &service.SubscriptionConfig{PushConfig: &service.PushConfig{Endpoint: endpoint.URL + "/" + string(mode), CloudEvents: mode}}
+		if err := svc.CreateSubscription(ctx, "orders", string(mode), config); err != nil {
+			t.Fatalf("failed to create subscription: %v", err)
+		}
+	}
+	if err := svc.CreateSubscription(ctx, "orders", "billing", nil); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+
+	publish := func(body string, header map[string]string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest("POST", srv.URL+"/v1/projects/test/topics/orders:publish", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+		defer resp.Body.Close()
+		var res struct{ MessageIds []string }
+		json.NewDecoder(resp.Body).Decode(&res)
+		if len(res.MessageIds) != 1 {
+			return resp.StatusCode, ""
+		}
+		return resp.StatusCode, res.MessageIds[0]
+	}
+
+	// The binary mode carries the attributes in ce-* headers, percent-encoded.
+	code, _ := publish(`{"id": 1}`, map[string]string{
+		"Content-Type":   "application/json",
+		"ce-specversion": "1.0",
+		"ce-id":          "e1",
+		"ce-source":      "/orders",
+		"ce-type":        "com.example.order.created",
+		"ce-subject":     "order%201",
+	})
+	if code != http.StatusOK {
+		t.Fatalf("expected a binary CloudEvent to be published, got %d", code)
+	}
+	code, _ = publish(`{"specversion": "1.0", "id": "e2", "source": "/orders", "type": "com.example.order.noted",
+		"datacontenttype": "text/plain", "data": "note"}`, map[string]string{"Content-Type": service.CloudEventsContentType})
+	if code != http.StatusOK {
+		t.Fatalf("expected a structured CloudEvent to be published, got %d", code)
+	}
+	if code, _ := publish(`{"specversion": "1.0", "source": "/orders", "type": "t"}`, map[string]string{"Content-Type": service.CloudEventsContentType}); code != http.StatusBadRequest {
+		t.Fatalf("expected an event without id to be rejected with 400, got %d", code)
+	}
+	code, plainID := publish(`{"messages": [{"data": "cGxhaW4=", "attributes": {"region": "eu"}}]}`, map[string]string{"Content-Type": "application/json"})
+	if code != http.StatusOK {
+		t.Fatalf("expected a plain message to be published, got %d", code)
+	}
+
+	messages, err := svc.GetMessages(ctx, "billing")
+	if err != nil || len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d, %v", len(messages), err)
+	}
+	if a := messages[0].Attributes; a["ce-id"] != "e1" || a["ce-subject"] != "order 1" || a["content-type"] != "application/json" ||
+		messages[0].Content != `{"id": 1}` {
+		t.Fatalf("unexpected message %v", messages[0])
+	}
+	if a := messages[1].Attributes; a["ce-id"] != "e2" || a["content-type"] != "text/plain" || messages[1].Content != "note" {
+		t.Fatalf("unexpected message %v", messages[1])
+	}
+
+	newPusher(svc, t.Logf).dispatch(ctx)
+	mu.Lock()
+	defer mu.Unlock()
+
+	binary := pushed["/binary"]
+	if len(binary) != 3 {
+		t.Fatalf("expected 3 binary pushes, got %d", len(binary))
+	}
+	if h := binary[0].header; h.Get("ce-id") != "e1" || h.Get("ce-subject") != "order%201" || h.Get("ce-specversion") != "1.0" ||
+		h.Get("Content-Type") != "application/json" || binary[0].body != `{"id": 1}` {
+		t.Fatalf("unexpected binary push %v %q", h, binary[0].body)
+	}
+	if h := binary[2].header; h.Get("ce-id") != plainID || h.Get("ce-type") != service.MessagePublishedType ||
+		h.Get("ce-source") != "//pubsub.googleapis.com/projects/test/topics/orders" || h.Get("ce-time") == "" {
+		t.Fatalf("unexpected binary push of a plain message %v", h)
+	}
+
+	structured := pushed["/structured"]
+	if len(structured) != 3 {
+		t.Fatalf("expected 3 structured pushes, got %d", len(structured))
+	}
+	for _, req := range structured {
+		if req.header.Get("Content-Type") != service.CloudEventsContentType {
+			t.Fatalf("unexpected content type %q", req.header.Get("Content-Type"))
+		}
+	}
+	var note map[string]any
+	if err := json.Unmarshal([]byte(structured[1].body), &note); err != nil || note["data"] != "note" || note["id"] != "e2" {
+		t.Fatalf("unexpected structured push %s, %v", structured[1].body, err)
+	}
+	var plain struct {
+		ID   string
+		Time string
+		Data pushRequest
+	}
+	if err := json.Unmarshal([]byte(structured[2].body), &plain); err != nil {
+		t.Fatalf("failed to decode structured push: %v", err)
+	}
+	if plain.ID != plainID || plain.Time == "" || string(plain.Data.Message.Data) != "plain" ||
+		plain.Data.Message.Attributes["region"] != "eu" || plain.Data.Subscription != "projects/test/subscriptions/structured" {
+		t.Fatalf("unexpected structured push of a plain message %s", structured[2].body)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// connectTimeout bounds the wait for the frame or packet opening a session on a new connection.
+const connectTimeout = 10 * time.Second
+
+// ErrServerClosed is returned by the Serve methods of MQTTServer and STOMPServer after a call to Close.
+var ErrServerClosed = errors.New("server: closed")
+
+// connServer accepts the connections of a protocol served over plain TCP, and tracks them so that they can be closed
+// along with the listeners.
+type connServer struct {
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	wg        sync.WaitGroup
+}
+
+// serve calls handle in a goroutine for every connection accepted on lis, until close is called.
+func (s *connServer) serve(lis net.Listener, handle func(conn net.Conn)) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		lis.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = map[net.Listener]bool{}
+		s.conns = map[net.Conn]bool{}
+	}
+	s.listeners[lis] = true
+	s.mu.Unlock()
+
+	for {
+		conn, err := lis.Accept()
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return ErrServerClosed
+		}
+		if err != nil {
+			delete(s.listeners, lis)
+			s.mu.Unlock()
+			return err
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// close closes the listeners and connections, and returns once the connections have been handled.
+func (s *connServer) close() {
+	s.mu.Lock()
+	s.closed = true
+	for lis := range s.listeners {
+		lis.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// sessionSubscriptionConfig returns the config of the subscriptions created for the length of a session of a
+// protocol, labelled with the protocol. Subscriptions left behind when the server stops abruptly expire after a day.
+func sessionSubscriptionConfig(protocol string) *pubsub.SubscriptionConfig {
+	return &pubsub.SubscriptionConfig{
+		Labels:                   map[string]string{"managed-by": protocol},
+		MessageRetentionDuration: pubsub.MinExpirationTTL,
+		ExpirationPolicy:         &pubsub.ExpirationPolicy{TTL: pubsub.MinExpirationTTL},
+	}
+}
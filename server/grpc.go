@@ -0,0 +1,110 @@
+// Package server exposes a pubsub.Service over the network, so that clients other than the pubsub CLI can use it.
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// NewGRPC returns a gRPC server implementing the google.pubsub.v1 Publisher and Subscriber services on top of svc.
+// The official client libraries connect to it when PUBSUB_EMULATOR_HOST is set to its address.
+func NewGRPC(svc *pubsub.Service) *grpc.Server {
+	s := grpc.NewServer()
+	pubsubpb.RegisterPublisherServer(s, &publisherServer{svc: svc})
+	pubsubpb.RegisterSubscriberServer(s, &subscriberServer{svc: svc})
+	return s
+}
+
+// grpcError converts an error returned by the service into a gRPC status error.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Internal
+	switch {
+	case errors.Is(err, pubsub.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, pubsub.ErrAlreadyExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, pubsub.ErrInvalidConfig), errors.Is(err, pubsub.ErrSchemaValidation),
+		errors.Is(err, pubsub.ErrLimitExceeded), errors.Is(err, pubsub.ErrIncompatibleSchema),
+		errors.Is(err, pubsub.ErrForwardingCycle):
+		code = codes.InvalidArgument
+	case errors.Is(err, pubsub.ErrDetached), errors.Is(err, pubsub.ErrSchemaInUse):
+		code = codes.FailedPrecondition
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
+
+// projectContext scopes ctx to the project of a list request, given as "projects/{project}".
+func projectContext(ctx context.Context, project string) (context.Context, error) {
+	id, ok := strings.CutPrefix(project, "projects/")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid project %q, expected projects/{project}", project)
+	}
+	return pubsub.WithProject(ctx, id), nil
+}
+
+// deletedTopic is the topic Google Pub/Sub reports for subscriptions whose topic has been deleted.
+const deletedTopic = "_deleted-topic_"
+
+func duration(d *durationpb.Duration) time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.AsDuration()
+}
+
+func durationProto(d time.Duration) *durationpb.Duration {
+	if d == 0 {
+		return nil
+	}
+	return durationpb.New(d)
+}
+
+func timestampProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// updateMask converts the snake_case field paths of an update mask into the field names of configuration files.
+func updateMask(paths []string) []string {
+	mask := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if path == "ack_deadline_seconds" {
+			mask = append(mask, "ackDeadline")
+			continue
+		}
+		segments := strings.Split(path, ".")
+		for i, segment := range segments {
+			words := strings.Split(segment, "_")
+			for j := 1; j < len(words); j++ {
+				if words[j] != "" {
+					words[j] = strings.ToUpper(words[j][:1]) + words[j][1:]
+				}
+			}
+			segments[i] = strings.Join(words, "")
+		}
+		mask = append(mask, strings.Join(segments, "."))
+	}
+	return mask
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	service "github.com/nigel-campbell/pubsub/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestClient serves a fresh service over gRPC and connects the official client library to it the way
+// applications do, through PUBSUB_EMULATOR_HOST.
+func newTestClient(t *testing.T) (*pubsub.Client, *service.Service) {
+	t.Helper()
+	ctx := context.Background()
+	svc, err := service.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	t.Cleanup(func() { svc.Close() })
+	if err := svc.Init(ctx); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := NewGRPC(svc)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	t.Setenv("PUBSUB_EMULATOR_HOST", lis.Addr().String())
+	client, err := pubsub.NewClient(ctx, "test-project")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, svc
+}
+
+func TestGRPC(t *testing.T) {
+	ctx := context.Background()
+	client, svc := newTestClient(t)
+
+	topic, err := client.CreateTopic(ctx, "orders")
+	if err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	if _, err := client.CreateTopic(ctx, "orders"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected creating a topic twice to fail with AlreadyExists, got %v", err)
+	}
+	sub, err := client.CreateSubscription(ctx, "billing", pubsub.SubscriptionConfig{
+		Topic:       topic,
+		AckDeadline: 30 * time.Second,
+		Filter:      `attributes.region = "eu"`,
+	})
+	if err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	config, err := sub.Config(ctx)
+	if err != nil {
+		t.Fatalf("failed to get subscription config: %v", err)
+	}
+	if config.AckDeadline != 30*time.Second || config.Topic.ID() != "orders" {
+		t.Fatalf("unexpected subscription config %+v", config)
+	}
+	if exists, err := client.Topic("missing").Exists(ctx); err != nil || exists {
+		t.Fatalf("expected a missing topic not to exist, got %t, %v", exists, err)
+	}
+
+	var ids []string
+	for _, region := range []string{"eu", "us", "eu"} {
+		id, err := topic.Publish(ctx, &pubsub.Message{Data: []byte("order"), Attributes: map[string]string{"region": region}}).Get(ctx)
+		if err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	topic.Stop()
+
+	var mu sync.Mutex
+	received := map[string]bool{}
+	receiveCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err = sub.Receive(receiveCtx, func(ctx context.Context, m *pubsub.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[m.ID] = true
+		m.Ack()
+		if len(received) == 2 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to receive: %v", err)
+	}
+	if len(received) != 2 || !received[ids[0]] || !received[ids[2]] {
+		t.Fatalf("expected the filtered messages %s and %s, got %v", ids[0], ids[2], received)
+	}
+
+	// Acks sent on the stream are applied before it closes.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages, err := svc.GetMessages(ctx, "projects/test-project/subscriptions/billing")
+		if err != nil {
+			t.Fatalf("failed to get messages: %v", err)
+		}
+		acked := 0
+		for _, m := range messages {
+			if m.Acknowledged {
+				acked++
+			}
+		}
+		if acked == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected both messages to be acknowledged, got %d", acked)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := sub.Delete(ctx); err != nil {
+		t.Fatalf("failed to delete subscription: %v", err)
+	}
+	if err := topic.Delete(ctx); err != nil {
+		t.Fatalf("failed to delete topic: %v", err)
+	}
+	if _, err := svc.GetTopic(ctx, "projects/test-project/topics/orders"); !errors.Is(err, service.ErrNotFound) {
+		t.Fatalf("expected the topic to be deleted")
+	}
+}
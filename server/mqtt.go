@@ -0,0 +1,530 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/nigel-campbell/pubsub/internal/mqtt"
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+const (
+	// mqttMaxInflight caps the QoS 1 messages of a subscription delivered to a client and not acknowledged yet.
+	mqttMaxInflight = 20
+	// mqttRescanInterval is how often topic filters are matched against the topics, to subscribe to new ones.
+	mqttRescanInterval = time.Second
+)
+
+// MQTTServer serves MQTT 3.1.1 clients, so that devices can share topics with the clients of the other APIs.
+//
+// Topic levels, separated by slashes in MQTT, are separated by dots in Pub/Sub topic names, which cannot contain
+// slashes: devices/42/temperature is the Pub/Sub topic devices.42.temperature. PUBLISH publishes the payload to the
+// topic, which must exist. SUBSCRIBE creates a subscription on every topic matching the filter, including topics
+// created later, and delivers their messages until UNSUBSCRIBE or the end of the connection, when the subscriptions
+// are deleted. Messages delivered with QoS 0 are acknowledged once sent, and with QoS 1 when the client answers with
+// PUBACK. QoS 2 subscriptions are granted QoS 1.
+//
+// Every session is clean: the messages a client has not acknowledged when it disconnects are discarded with its
+// subscriptions, and a client reconnecting with the same client ID takes over the connection, but not the
+// subscriptions of the previous one. Retained messages are not supported.
+type MQTTServer struct {
+	svc  *pubsub.Service
+	logf func(format string, args ...any)
+
+	conns    connServer
+	mu       sync.Mutex
+	sessions map[string]*mqttSession // By client ID
+}
+
+// NewMQTT returns an MQTT server on top of svc. logf reports the errors closing connections, and defaults to
+// log.Printf.
+func NewMQTT(svc *pubsub.Service, logf func(format string, args ...any)) *MQTTServer {
+	if logf == nil {
+		logf = log.Printf
+	}
+	return &MQTTServer{svc: svc, logf: logf, sessions: map[string]*mqttSession{}}
+}
+
+// Serve accepts MQTT connections on lis until Close is called, when it returns ErrServerClosed.
+func (m *MQTTServer) Serve(lis net.Listener) error {
+	return m.conns.serve(lis, m.serveConn)
+}
+
+// Close stops the listeners and closes every connection, and returns once their subscriptions have been deleted.
+func (m *MQTTServer) Close() error {
+	m.conns.close()
+	return nil
+}
+
+func (m *MQTTServer) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	p, err := mqtt.Read(r)
+	if err != nil {
+		return
+	}
+	connect, ok := p.(*mqtt.Connect)
+	if !ok {
+		// The first packet must be CONNECT.
+		return
+	}
+	if connect.ProtocolName != mqtt.ProtocolName || connect.ProtocolLevel != mqtt.ProtocolLevel {
+		mqtt.Write(conn, &mqtt.Connack{ReturnCode: mqtt.RefusedProtocolVersion})
+		return
+	}
+	clientID := connect.ClientID
+	if clientID == "" {
+		if !connect.CleanSession {
+			mqtt.Write(conn, &mqtt.Connack{ReturnCode: mqtt.RefusedIdentifierRejected})
+			return
+		}
+		clientID = "auto-" + randomHex()
+	}
+
+	s := m.register(clientID, conn)
+	defer m.unregister(s)
+	s.will = connect.Will
+	err = s.run(r, time.Duration(connect.KeepAlive)*time.Second)
+	conn.Close()
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		m.logf("MQTT client %q disconnected: %v", clientID, err)
+	}
+	s.close()
+}
+
+// register starts the session of a client, taking over the connection of the client's current session, if any.
+func (m *MQTTServer) register(clientID string, conn net.Conn) *mqttSession {
+	for {
+		m.mu.Lock()
+		current := m.sessions[clientID]
+		if current == nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			s := &mqttSession{
+				svc:           m.svc,
+				logf:          m.logf,
+				conn:          conn,
+				clientID:      clientID,
+				prefix:        "mqtt-" + randomHex() + "-",
+				ctx:           ctx,
+				cancel:        cancel,
+				done:          make(chan struct{}),
+				subscriptions: map[string]*mqttSubscription{},
+				inflight:      map[uint16]mqttInflight{},
+				received:      map[uint16]bool{},
+			}
+			m.sessions[clientID] = s
+			m.mu.Unlock()
+			return s
+		}
+		m.mu.Unlock()
+		current.conn.Close()
+		<-current.done
+	}
+}
+
+func (m *MQTTServer) unregister(s *mqttSession) {
+	m.mu.Lock()
+	if m.sessions[s.clientID] == s {
+		delete(m.sessions, s.clientID)
+	}
+	m.mu.Unlock()
+	close(s.done)
+}
+
+// mqttSession is the session of a connected client.
+type mqttSession struct {
+	svc      *pubsub.Service
+	logf     func(format string, args ...any)
+	conn     net.Conn
+	clientID string
+	prefix   string // Of the names of the session's subscriptions
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{} // Closed once the session has ended
+	will     *mqtt.Publish // Published unless the client sends DISCONNECT
+	writeMu  sync.Mutex
+
+	// Only used by the goroutine reading the connection.
+	subscriptions map[string]*mqttSubscription // By topic filter
+	received      map[uint16]bool              // Packet IDs of QoS 2 publishes awaiting PUBREL
+
+	mu           sync.Mutex
+	inflight     map[uint16]mqttInflight // By packet ID
+	nextPacketID uint16
+	seq          int // Numbers the names of the session's subscriptions
+}
+
+// mqttInflight is a message delivered with QoS 1 and not acknowledged yet.
+type mqttInflight struct {
+	st    *streamer
+	ackID int
+}
+
+// mqttSubscription delivers the messages of the topics matching a topic filter, using a Pub/Sub subscription per
+// topic.
+type mqttSubscription struct {
+	filter   string
+	qos      byte // Guarded by the session's mu, as it changes when the filter is subscribed again
+	ctx      context.Context
+	cancel   context.CancelFunc
+	watching chan struct{}            // Closed once the filter is no longer matched against new topics
+	topics   map[string]*mqttDelivery // By topic name
+	wg       sync.WaitGroup           // Running deliveries
+}
+
+// mqttDelivery streams the messages of a topic's subscription to the client.
+type mqttDelivery struct {
+	subscription string
+	done         chan struct{} // Closed once the stream has stopped
+}
+
+func (s *mqttSession) write(p mqtt.Packet) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return mqtt.Write(s.conn, p)
+}
+
+// run handles the packets of the client until it disconnects or breaks the protocol.
+func (s *mqttSession) run(r *bufio.Reader, keepAlive time.Duration) error {
+	if err := s.write(&mqtt.Connack{ReturnCode: mqtt.Accepted}); err != nil {
+		return err
+	}
+	for {
+		// The client must send a packet within one and a half keep alive periods.
+		if keepAlive > 0 {
+			s.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			s.conn.SetReadDeadline(time.Time{})
+		}
+		p, err := mqtt.Read(r)
+		if err != nil {
+			return err
+		}
+		switch p := p.(type) {
+		case *mqtt.Publish:
+			err = s.publish(p)
+		case *mqtt.Puback:
+			s.acknowledge(p.PacketID)
+		case *mqtt.Pubrel:
+			delete(s.received, p.PacketID)
+			err = s.write(&mqtt.Pubcomp{PacketID: p.PacketID})
+		case *mqtt.Subscribe:
+			codes := make([]byte, len(p.Subscriptions))
+			for i, subscription := range p.Subscriptions {
+				qos := min(subscription.QoS, 1)
+				if err := s.subscribe(subscription.Filter, qos); err != nil {
+					s.logf("MQTT client %q failed to subscribe to %q: %v", s.clientID, subscription.Filter, err)
+					codes[i] = mqtt.SubscribeFailure
+					continue
+				}
+				codes[i] = qos
+			}
+			err = s.write(&mqtt.Suback{PacketID: p.PacketID, ReturnCodes: codes})
+		case *mqtt.Unsubscribe:
+			for _, filter := range p.Filters {
+				if sub := s.subscriptions[filter]; sub != nil {
+					delete(s.subscriptions, filter)
+					s.stop(sub)
+				}
+			}
+			err = s.write(&mqtt.Unsuback{PacketID: p.PacketID})
+		case *mqtt.Pingreq:
+			err = s.write(&mqtt.Pingresp{})
+		case *mqtt.Disconnect:
+			s.will = nil
+			return nil
+		default:
+			return fmt.Errorf("unexpected %T packet", p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// close ends the session once its connection is closed: the subscriptions are deleted, and the will published.
+func (s *mqttSession) close() {
+	s.cancel()
+	for filter, sub := range s.subscriptions {
+		delete(s.subscriptions, filter)
+		s.stop(sub)
+	}
+	if s.will != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), nackTimeout)
+		defer cancel()
+		if err := s.svc.PublishMessage(ctx, pubsubTopic(s.will.Topic), string(s.will.Payload), nil); err != nil {
+			s.logf("MQTT client %q: failed to publish will to %q: %v", s.clientID, s.will.Topic, err)
+		}
+	}
+}
+
+// publish publishes a message of the client.
+func (s *mqttSession) publish(p *mqtt.Publish) error {
+	if p.Topic == "" || strings.ContainsAny(p.Topic, "+#") {
+		return fmt.Errorf("invalid topic name %q", p.Topic)
+	}
+	// A QoS 2 message sent again before PUBREL has been published already.
+	if p.QoS < 2 || !s.received[p.PacketID] {
+		if err := s.svc.PublishMessage(s.ctx, pubsubTopic(p.Topic), string(p.Payload), nil); err != nil {
+			return fmt.Errorf("failed to publish to %q: %w", p.Topic, err)
+		}
+	}
+	switch p.QoS {
+	case 1:
+		return s.write(&mqtt.Puback{PacketID: p.PacketID})
+	case 2:
+		s.received[p.PacketID] = true
+		return s.write(&mqtt.Pubrec{PacketID: p.PacketID})
+	}
+	return nil
+}
+
+// acknowledge acknowledges the message delivered with a packet ID.
+func (s *mqttSession) acknowledge(packetID uint16) {
+	s.mu.Lock()
+	inflight, ok := s.inflight[packetID]
+	delete(s.inflight, packetID)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	inflight.st.forget(inflight.ackID)
+	// The subscription is gone when the client unsubscribed in the meantime.
+	if err := s.svc.AcknowledgeMessage(s.ctx, inflight.st.subscription, inflight.ackID); err != nil && !errors.Is(err, pubsub.ErrNotFound) {
+		s.logf("MQTT client %q: failed to acknowledge message: %v", s.clientID, err)
+	}
+}
+
+// subscribe subscribes to the topics matching a filter, or changes the QoS of a filter already subscribed to.
+func (s *mqttSession) subscribe(filter string, qos byte) error {
+	if err := validateTopicFilter(filter); err != nil {
+		return err
+	}
+	if sub := s.subscriptions[filter]; sub != nil {
+		s.mu.Lock()
+		sub.qos = qos
+		s.mu.Unlock()
+		return nil
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	sub := &mqttSubscription{
+		filter:   filter,
+		qos:      qos,
+		ctx:      ctx,
+		cancel:   cancel,
+		watching: make(chan struct{}),
+		topics:   map[string]*mqttDelivery{},
+	}
+	// The topics that exist are subscribed to before SUBACK, so that the client receives what is published next.
+	if err := s.rescan(sub); err != nil {
+		close(sub.watching)
+		s.stop(sub)
+		return err
+	}
+	s.subscriptions[filter] = sub
+	go func() {
+		defer close(sub.watching)
+		ticker := time.NewTicker(mqttRescanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.rescan(sub); err != nil && ctx.Err() == nil {
+					s.logf("MQTT client %q: failed to subscribe to %q: %v", s.clientID, filter, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// rescan subscribes to the topics matching the filter that are not subscribed to yet, and restarts the deliveries
+// that stopped, unless their topic was deleted.
+func (s *mqttSession) rescan(sub *mqttSubscription) error {
+	topics, err := s.svc.ListTopics(sub.ctx)
+	if err != nil {
+		return err
+	}
+	matched := map[string]bool{}
+	for _, topic := range topics {
+		if matchTopicFilter(sub.filter, mqttTopic(topic.Name)) {
+			matched[topic.Name] = true
+		}
+	}
+	for topic, d := range sub.topics {
+		select {
+		case <-d.done:
+		default:
+			continue
+		}
+		subscription, err := s.svc.GetSubscription(sub.ctx, d.subscription)
+		if err == nil && !subscription.Detached && matched[topic] {
+			s.deliver(sub, topic, subscription)
+			continue
+		}
+		s.svc.DeleteSubscription(sub.ctx, d.subscription)
+		delete(sub.topics, topic)
+	}
+	for topic := range matched {
+		if sub.topics[topic] != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.seq++
+		name := s.prefix + strconv.Itoa(s.seq)
+		s.mu.Unlock()
+		if err := s.svc.CreateSubscription(sub.ctx, topic, name, sessionSubscriptionConfig("mqtt")); err != nil {
+			return err
+		}
+		subscription, err := s.svc.GetSubscription(sub.ctx, name)
+		if err != nil {
+			return err
+		}
+		s.deliver(sub, topic, subscription)
+	}
+	return nil
+}
+
+// deliver starts streaming the messages of a topic's subscription to the client.
+func (s *mqttSession) deliver(sub *mqttSubscription, topic string, subscription *pubsub.Subscription) {
+	st := &streamer{
+		svc:            s.svc,
+		subscription:   subscription.ResourceName(),
+		ackDeadline:    subscription.Config.EffectiveAckDeadline(),
+		maxOutstanding: mqttMaxInflight,
+		outstanding:    map[int]bool{},
+	}
+	d := &mqttDelivery{subscription: subscription.ResourceName(), done: make(chan struct{})}
+	sub.topics[topic] = d
+	sub.wg.Add(1)
+	go func() {
+		defer sub.wg.Done()
+		defer close(d.done)
+		// Clients keep the connection alive themselves.
+		st.run(sub.ctx, func(message *pubsubpb.ReceivedMessage) error {
+			return s.send(sub, st, topic, message)
+		}, func() error { return nil })
+	}()
+}
+
+// send sends a message to the client with the QoS of the filter it was delivered for.
+func (s *mqttSession) send(sub *mqttSubscription, st *streamer, topic string, message *pubsubpb.ReceivedMessage) error {
+	ackID, err := strconv.Atoi(message.AckId)
+	if err != nil {
+		return err
+	}
+	p := &mqtt.Publish{Topic: mqttTopic(topic), Payload: message.Message.Data}
+	s.mu.Lock()
+	p.QoS = sub.qos
+	if p.QoS > 0 {
+		// The message is in flight before it is sent, as the client may acknowledge it at once.
+		if p.PacketID, err = s.packetID(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.inflight[p.PacketID] = mqttInflight{st: st, ackID: ackID}
+	}
+	s.mu.Unlock()
+	if err := s.write(p); err != nil {
+		return err
+	}
+	if p.QoS == 0 {
+		st.forget(ackID)
+		return s.svc.AcknowledgeMessage(sub.ctx, st.subscription, ackID)
+	}
+	return nil
+}
+
+// packetID returns an unused packet ID. The caller must hold s.mu.
+func (s *mqttSession) packetID() (uint16, error) {
+	for range 1 << 16 {
+		s.nextPacketID++
+		if id := s.nextPacketID; id != 0 {
+			if _, ok := s.inflight[id]; !ok {
+				return id, nil
+			}
+		}
+	}
+	return 0, errors.New("too many messages in flight")
+}
+
+// stop stops delivering the messages of a filter and deletes its subscriptions. The messages in flight are
+// discarded with them.
+func (s *mqttSession) stop(sub *mqttSubscription) {
+	sub.cancel()
+	<-sub.watching
+	sub.wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), nackTimeout)
+	defer cancel()
+	for _, d := range sub.topics {
+		if err := s.svc.DeleteSubscription(ctx, d.subscription); err != nil && !errors.Is(err, pubsub.ErrNotFound) {
+			s.logf("MQTT client %q: failed to delete subscription %q: %v", s.clientID, d.subscription, err)
+		}
+	}
+}
+
+// mqttTopic returns the MQTT topic name of a Pub/Sub topic, whose levels are separated by dots.
+func mqttTopic(name string) string {
+	return strings.ReplaceAll(name, ".", "/")
+}
+
+// pubsubTopic returns the Pub/Sub topic of an MQTT topic name.
+func pubsubTopic(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// validateTopicFilter checks that the wildcards of a topic filter each take a whole level, and that # is last.
+func validateTopicFilter(filter string) error {
+	if filter == "" {
+		return errors.New("empty topic filter")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) > 1 {
+			return fmt.Errorf("invalid topic filter %q: wildcards must take a whole level", filter)
+		}
+		if level == "#" && i != len(levels)-1 {
+			return fmt.Errorf("invalid topic filter %q: # must be the last level", filter)
+		}
+	}
+	return nil
+}
+
+// matchTopicFilter reports whether a topic name matches a valid topic filter, where + matches a single level and #
+// any number of levels, including none. Wildcards in the first level do not match topics starting with $.
+func matchTopicFilter(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (f[0] == "+" || f[0] == "#") {
+		return false
+	}
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func randomHex() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
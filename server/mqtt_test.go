@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nigel-campbell/pubsub/internal/mqtt"
+	service "github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// mqttClient is a minimal MQTT client reading and writing packets one at a time.
+type mqttClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialMQTT(t *testing.T, addr, clientID string) *mqttClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &mqttClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	c.write(&mqtt.Connect{ProtocolName: mqtt.ProtocolName, ProtocolLevel: mqtt.ProtocolLevel, CleanSession: true, ClientID: clientID})
+	if connack, ok := c.read().(*mqtt.Connack); !ok || connack.ReturnCode != mqtt.Accepted {
+		t.Fatalf("expected the connection to be accepted, got %+v", connack)
+	}
+	return c
+}
+
+func (c *mqttClient) write(p mqtt.Packet) {
+	c.t.Helper()
+	if err := mqtt.Write(c.conn, p); err != nil {
+		c.t.Fatalf("failed to write %T: %v", p, err)
+	}
+}
+
+func (c *mqttClient) read() mqtt.Packet {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := mqtt.Read(c.r)
+	if err != nil {
+		c.t.Fatalf("failed to read packet: %v", err)
+	}
+	return p
+}
+
+func (c *mqttClient) readPublish() *mqtt.Publish {
+	c.t.Helper()
+	p, ok := c.read().(*mqtt.Publish)
+	if !ok {
+		c.t.Fatalf("expected PUBLISH, got %T", p)
+	}
+	return p
+}
+
+// mqttSubscriptions returns the subscriptions the MQTT server manages.
+func mqttSubscriptions(t *testing.T, svc *service.Service) []*service.Subscription {
+	t.Helper()
+	subscriptions, err := svc.ListAllSubscriptions(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list subscriptions: %v", err)
+	}
+	var managed []*service.Subscription
+	for _, subscription := range subscriptions {
+		if subscription.Config.Labels["managed-by"] == "mqtt" {
+			managed = append(managed, subscription)
+		}
+	}
+	return managed
+}
+
+func TestMQTT(t *testing.T) {
+	ctx := context.Background()
+	svc, err := service.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	defer svc.Close()
+	if err := svc.Init(ctx); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+	for _, topic := range []string{"devices.1.temperature", "devices.2.temperature", "orders"} {
+		if err := svc.CreateTopic(ctx, topic, nil); err != nil {
+			t.Fatalf("failed to create topic: %v", err)
+		}
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := NewMQTT(svc, t.Logf)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(lis) }()
+	defer func() {
+		srv.Close()
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected Serve to return ErrServerClosed, got %v", err)
+		}
+	}()
+
+	device := dialMQTT(t, lis.Addr().String(), "device")
+	device.write(&mqtt.Subscribe{PacketID: 1, Subscriptions: []mqtt.Subscription{
+		{Filter: "devices/+/temperature", QoS: 2},
+		{Filter: "orders", QoS: 0},
+		{Filter: "devices/#/temperature", QoS: 1},
+	}})
+	suback, ok := device.read().(*mqtt.Suback)
+	if !ok || suback.PacketID != 1 || string(suback.ReturnCodes) != string([]byte{1, 0, mqtt.SubscribeFailure}) {
+		t.Fatalf("unexpected SUBACK %+v", suback)
+	}
+	if n := len(mqttSubscriptions(t, svc)); n != 3 {
+		t.Fatalf("expected a subscription per matching topic, got %d", n)
+	}
+
+	// Messages published by other clients are delivered with the granted QoS, and acknowledged by PUBACK.
+	if _, err := svc.Publish(ctx, "devices.2.temperature", "21.5", nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	publish := device.readPublish()
+	if publish.Topic != "devices/2/temperature" || string(publish.Payload) != "21.5" || publish.QoS != 1 || publish.PacketID == 0 {
+		t.Fatalf("unexpected PUBLISH %+v", publish)
+	}
+	device.write(&mqtt.Puback{PacketID: publish.PacketID})
+	deadline := time.Now().Add(5 * time.Second)
+	for acked := false; !acked; {
+		for _, subscription := range mqttSubscriptions(t, svc) {
+			messages, err := svc.GetMessages(ctx, subscription.SubscriberID)
+			if err != nil {
+				t.Fatalf("failed to get messages: %v", err)
+			}
+			for _, message := range messages {
+				acked = acked || message.Acknowledged
+			}
+		}
+		if !acked && time.Now().After(deadline) {
+			t.Fatal("expected PUBACK to acknowledge the message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	backend := dialMQTT(t, lis.Addr().String(), "backend")
+	backend.write(&mqtt.Publish{QoS: 1, PacketID: 7, Topic: "orders", Payload: []byte("order 1")})
+	if puback, ok := backend.read().(*mqtt.Puback); !ok || puback.PacketID != 7 {
+		t.Fatalf("expected PUBACK, got %+v", puback)
+	}
+	publish = device.readPublish()
+	if publish.Topic != "orders" || string(publish.Payload) != "order 1" || publish.QoS != 0 {
+		t.Fatalf("unexpected PUBLISH %+v", publish)
+	}
+
+	// Topics created later are subscribed to.
+	if err := svc.CreateTopic(ctx, "devices.3.temperature", nil); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	for len(mqttSubscriptions(t, svc)) != 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the new topic to be subscribed to")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	backend.write(&mqtt.Publish{Topic: "devices/3/temperature", Payload: []byte("19")})
+	if publish := device.readPublish(); publish.Topic != "devices/3/temperature" {
+		t.Fatalf("unexpected PUBLISH %+v", publish)
+	}
+
+	device.write(&mqtt.Pingreq{})
+	if _, ok := device.read().(*mqtt.Pingresp); !ok {
+		t.Fatal("expected PINGRESP")
+	}
+	device.write(&mqtt.Unsubscribe{PacketID: 2, Filters: []string{"orders"}})
+	if unsuback, ok := device.read().(*mqtt.Unsuback); !ok || unsuback.PacketID != 2 {
+		t.Fatalf("expected UNSUBACK, got %+v", unsuback)
+	}
+	if n := len(mqttSubscriptions(t, svc)); n != 3 {
+		t.Fatalf("expected unsubscribing to delete the subscription, got %d", n)
+	}
+
+	// Publishing to a missing topic closes the connection.
+	backend.write(&mqtt.Publish{QoS: 1, PacketID: 8, Topic: "missing", Payload: []byte("lost")})
+	backend.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := mqtt.Read(backend.r); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+
+	// The subscriptions of a client are deleted when it disconnects.
+	device.write(&mqtt.Disconnect{})
+	for len(mqttSubscriptions(t, svc)) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the subscriptions to be deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMatchTopicFilter(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"sport/tennis/player1", "sport/tennis/player1", true},
+		{"sport/tennis/+", "sport/tennis/player1", true},
+		{"sport/tennis/+", "sport/tennis/player1/ranking", false},
+		{"sport/#", "sport", true},
+		{"sport/#", "sport/tennis/player1", true},
+		{"#", "sport/tennis", true},
+		{"+/+", "/finance", true},
+		{"+", "/finance", false},
+		{"#", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+	}
+	for _, test := range tests {
+		if got := matchTopicFilter(test.filter, test.topic); got != test.match {
+			t.Errorf("matchTopicFilter(%q, %q) = %t, expected %t", test.filter, test.topic, got, test.match)
+		}
+	}
+	for _, filter := range []string{"", "sport/#/tennis", "sport+", "sport/te#"} {
+		if err := validateTopicFilter(filter); err == nil {
+			t.Errorf("expected filter %q to be invalid", filter)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"strconv"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type publisherServer struct {
+	pubsubpb.UnimplementedPublisherServer
+	svc *pubsub.Service
+}
+
+func topicConfig(t *pubsubpb.Topic) (*pubsub.TopicConfig, error) {
+	if t.GetSchemaSettings() != nil || t.GetKmsKeyName() != "" || t.GetIngestionDataSourceSettings() != nil {
+		return nil, status.Error(codes.Unimplemented, "schema settings, encryption keys and ingestion are not supported, use the pubsub CLI to bind schemas")
+	}
+	return &pubsub.TopicConfig{
+		Labels:                   t.GetLabels(),
+		MessageRetentionDuration: duration(t.GetMessageRetentionDuration()),
+	}, nil
+}
+
+func topicProto(topic *pubsub.Topic) *pubsubpb.Topic {
+	return &pubsubpb.Topic{
+		Name:                     topic.ResourceName(),
+		Labels:                   topic.Config.Labels,
+		MessageRetentionDuration: durationProto(topic.Config.MessageRetentionDuration),
+		State:                    pubsubpb.Topic_ACTIVE,
+	}
+}
+
+func (s *publisherServer) CreateTopic(ctx context.Context, req *pubsubpb.Topic) (*pubsubpb.Topic, error) {
+	config, err := topicConfig(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.svc.CreateTopic(ctx, req.GetName(), config); err != nil {
+		return nil, grpcError(err)
+	}
+	return s.GetTopic(ctx, &pubsubpb.GetTopicRequest{Topic: req.GetName()})
+}
+
+func (s *publisherServer) UpdateTopic(ctx context.Context, req *pubsubpb.UpdateTopicRequest) (*pubsubpb.Topic, error) {
+	config, err := topicConfig(req.GetTopic())
+	if err != nil {
+		return nil, err
+	}
+	topic, err := s.svc.UpdateTopic(ctx, req.GetTopic().GetName(), config, updateMask(req.GetUpdateMask().GetPaths()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return topicProto(topic), nil
+}
+
+// Publish publishes each message in its own transaction, so an invalid message fails the request without undoing the
+// messages before it.
+func (s *publisherServer) Publish(ctx context.Context, req *pubsubpb.PublishRequest) (*pubsubpb.PublishResponse, error) {
+	if len(req.GetMessages()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one message is required")
+	}
+	res := &pubsubpb.PublishResponse{}
+	for _, message := range req.GetMessages() {
+		id, err := s.svc.Publish(ctx, req.GetTopic(), string(message.GetData()), message.GetAttributes())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		res.MessageIds = append(res.MessageIds, strconv.FormatInt(id, 10))
+	}
+	return res, nil
+}
+
+func (s *publisherServer) GetTopic(ctx context.Context, req *pubsubpb.GetTopicRequest) (*pubsubpb.Topic, error) {
+	topic, err := s.svc.GetTopic(ctx, req.GetTopic())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return topicProto(topic), nil
+}
+
+func (s *publisherServer) ListTopics(ctx context.Context, req *pubsubpb.ListTopicsRequest) (*pubsubpb.ListTopicsResponse, error) {
+	ctx, err := projectContext(ctx, req.GetProject())
+	if err != nil {
+		return nil, err
+	}
+	topics, err := s.svc.ListTopics(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &pubsubpb.ListTopicsResponse{}
+	for _, topic := range topics {
+		res.Topics = append(res.Topics, topicProto(topic))
+	}
+	return res, nil
+}
+
+func (s *publisherServer) ListTopicSubscriptions(ctx context.Context, req *pubsubpb.ListTopicSubscriptionsRequest) (*pubsubpb.ListTopicSubscriptionsResponse, error) {
+	subscriptions, err := s.svc.ListSubscriptions(ctx, req.GetTopic())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &pubsubpb.ListTopicSubscriptionsResponse{}
+	for _, subscription := range subscriptions {
+		res.Subscriptions = append(res.Subscriptions, subscription.ResourceName())
+	}
+	return res, nil
+}
+
+func (s *publisherServer) ListTopicSnapshots(ctx context.Context, req *pubsubpb.ListTopicSnapshotsRequest) (*pubsubpb.ListTopicSnapshotsResponse, error) {
+	topic, err := s.svc.GetTopic(ctx, req.GetTopic())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	snapshots, err := s.svc.ListSnapshots(pubsub.WithProject(ctx, topic.Project))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &pubsubpb.ListTopicSnapshotsResponse{}
+	for _, snapshot := range snapshots {
+		if snapshot.TopicID == topic.ID {
+			res.Snapshots = append(res.Snapshots, snapshot.ResourceName())
+		}
+	}
+	return res, nil
+}
+
+func (s *publisherServer) DeleteTopic(ctx context.Context, req *pubsubpb.DeleteTopicRequest) (*emptypb.Empty, error) {
+	if err := s.svc.DeleteTopic(ctx, req.GetTopic()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *publisherServer) DetachSubscription(ctx context.Context, req *pubsubpb.DetachSubscriptionRequest) (*pubsubpb.DetachSubscriptionResponse, error) {
+	if err := s.svc.DetachSubscription(ctx, req.GetSubscription()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &pubsubpb.DetachSubscriptionResponse{}, nil
+}
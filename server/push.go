@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// maxPushedMessages caps the messages pushed for one subscription on each dispatch.
+const maxPushedMessages = 100
+
+// pushRequest is the body POSTed to push endpoints, in the format of Google Pub/Sub. Like Google Pub/Sub, the message
+// ID and publish time are sent in both camelCase and snake_case.
+type pushRequest struct {
+	Message      pushMessage `json:"message"`
+	Subscription string      `json:"subscription"`
+}
+
+type pushMessage struct {
+	Data          []byte            `json:"data,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+	MessageID     string            `json:"messageId"`
+	MessageIDV1   string            `json:"message_id"`
+	PublishTime   time.Time         `json:"publishTime"`
+	PublishTimeV1 time.Time         `json:"publish_time"`
+}
+
+// pusher delivers the messages of push subscriptions to their endpoints.
+type pusher struct {
+	svc    *pubsub.Service
+	client *http.Client
+	logf   func(format string, args ...any)
+}
+
+func newPusher(svc *pubsub.Service, logf func(format string, args ...any)) *pusher {
+	return &pusher{svc: svc, client: &http.Client{}, logf: logf}
+}
+
+// dispatch pushes the available messages of every push subscription, one subscription per goroutine, and returns once
+// they have all been pushed.
+func (p *pusher) dispatch(ctx context.Context) {
+	subscriptions, err := p.svc.ListAllSubscriptions(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logf("Error listing push subscriptions: %v", err)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for _, subscription := range subscriptions {
+		if subscription.Config.PushConfig == nil || subscription.Detached {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.push(ctx, subscription)
+		}()
+	}
+	wg.Wait()
+}
+
+// push leases the available messages of a subscription and pushes them in publish order. Messages the endpoint
+// accepts are acknowledged. The others are redelivered once their lease expires, or after the backoff of the
+// subscription's retry policy.
+func (p *pusher) push(ctx context.Context, subscription *pubsub.Subscription) {
+	messages, err := p.svc.Pull(ctx, subscription.ResourceName(), maxPushedMessages, time.Time{})
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logf("Error pulling messages to push for subscription %s: %v", subscription.SubscriberID, err)
+		}
+		return
+	}
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			return
+		}
+		var err error
+		if pushErr := p.deliver(ctx, subscription, message); pushErr == nil {
+			err = p.svc.AcknowledgeMessage(ctx, subscription.ResourceName(), message.ID)
+		} else {
+			p.logf("Error pushing message %d of subscription %s: %v", message.PublishID, subscription.SubscriberID, pushErr)
+			if subscription.Config.RetryPolicy == nil {
+				continue
+			}
+			// Nacking applies the retry policy's backoff.
+			err = p.svc.ModifyAckDeadline(ctx, subscription.ResourceName(), message.ID, time.Now())
+		}
+		if err != nil && ctx.Err() == nil {
+			p.logf("Error settling pushed message %d of subscription %s: %v", message.PublishID, subscription.SubscriberID, err)
+		}
+	}
+}
+
+// deliver POSTs a message to the subscription's endpoint, waiting at most the subscription's ack deadline for an
+// answer. Like Google Pub/Sub, the statuses 102, 200, 201, 202 and 204 acknowledge the message.
+func (p *pusher) deliver(ctx context.Context, subscription *pubsub.Subscription, message *pubsub.Message) error {
+	header := http.Header{}
+	var body []byte
+	var err error
+	if mode := subscription.Config.PushConfig.CloudEvents; mode != "" {
+		body, err = writeCloudEvent(header, pubsub.MessageCloudEvent(subscription, message), mode)
+	} else {
+		header.Set("Content-Type", "application/json")
+		body, err = json.Marshal(pushBody(subscription, message))
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, subscription.Config.EffectiveAckDeadline())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Config.PushConfig.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusProcessing, http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	}
+	return fmt.Errorf("endpoint answered %s", resp.Status)
+}
+
+// pushBody returns the body pushed for a message in the Google Pub/Sub push format.
+func pushBody(subscription *pubsub.Subscription, message *pubsub.Message) pushRequest {
+	id := strconv.FormatInt(message.PublishID, 10)
+	return pushRequest{
+		Message: pushMessage{
+			Data:          []byte(message.Content),
+			Attributes:    message.Attributes,
+			MessageID:     id,
+			MessageIDV1:   id,
+			PublishTime:   message.PublishedAt,
+			PublishTimeV1: message.PublishedAt,
+		},
+		Subscription: subscription.ResourceName(),
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/gorilla/websocket"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// restServer serves the google.pubsub.v1 REST API. Requests and responses are the JSON encoding of the same messages
+// the gRPC API uses, so payloads are base64 and field names are camelCase, and every call is handled by the gRPC
+// implementation.
+type restServer struct {
+	publisher      *publisherServer
+	subscriber     *subscriberServer
+	allowedOrigins []string
+	upgrader       websocket.Upgrader
+}
+
+// NewREST returns an HTTP handler implementing the topic and subscription methods of the google.pubsub.v1 REST API
+// on top of svc. Custom methods such as :publish and :pull are addressed with a colon after the resource name, as in
+// POST /v1/projects/{project}/topics/{topic}:publish. :publish also accepts a single CloudEvent in the binary or
+// structured mode of the CloudEvents HTTP binding. GET on a subscription's :stream method streams its messages to
+// browsers, see stream. Pages may only stream from the same origin, or from one of allowedOrigins, where "*" allows
+// every origin.
+func NewREST(svc *pubsub.Service, allowedOrigins ...string) http.Handler {
+	s := &restServer{publisher: &publisherServer{svc: svc}, subscriber: &subscriberServer{svc: svc}, allowedOrigins: allowedOrigins}
+	s.upgrader.CheckOrigin = s.checkOrigin
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/projects/{project}/topics", s.listTopics)
+	mux.HandleFunc("/v1/projects/{project}/topics/{topic}", s.topic)
+	mux.HandleFunc("GET /v1/projects/{project}/topics/{topic}/subscriptions", s.listTopicSubscriptions)
+	mux.HandleFunc("GET /v1/projects/{project}/subscriptions", s.listSubscriptions)
+	mux.HandleFunc("/v1/projects/{project}/subscriptions/{subscription}", s.subscription)
+	mux.HandleFunc("/", noMethod)
+	return mux
+}
+
+// resource returns the full name of the resource in the request path and the custom method that follows it, if any.
+func resource(r *http.Request, collection, wildcard string) (string, string) {
+	name, verb, _ := strings.Cut(r.PathValue(wildcard), ":")
+	return pubsub.ResourceName(r.PathValue("project"), collection, name), verb
+}
+
+func (s *restServer) topic(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name, verb := resource(r, "topics", "topic")
+	switch {
+	case verb == "" && r.Method == http.MethodPut:
+		req := &pubsubpb.Topic{}
+		if decode(w, r, req) {
+			req.Name = name
+			res, err := s.publisher.CreateTopic(ctx, req)
+			write(w, res, err)
+		}
+	case verb == "" && r.Method == http.MethodGet:
+		res, err := s.publisher.GetTopic(ctx, &pubsubpb.GetTopicRequest{Topic: name})
+		write(w, res, err)
+	case verb == "" && r.Method == http.MethodDelete:
+		res, err := s.publisher.DeleteTopic(ctx, &pubsubpb.DeleteTopicRequest{Topic: name})
+		write(w, res, err)
+	case verb == "publish" && r.Method == http.MethodPost:
+		event, err := readCloudEvent(r)
+		if err != nil {
+			writeError(w, status.Error(codes.InvalidArgument, err.Error()))
+			return
+		}
+		if event != nil {
+			content, attributes := event.Message()
+			res, err := s.publisher.Publish(ctx, &pubsubpb.PublishRequest{
+				Topic:    name,
+				Messages: []*pubsubpb.PubsubMessage{{Data: []byte(content), Attributes: attributes}},
+			})
+			write(w, res, err)
+			return
+		}
+		req := &pubsubpb.PublishRequest{}
+		if decode(w, r, req) {
+			req.Topic = name
+			res, err := s.publisher.Publish(ctx, req)
+			write(w, res, err)
+		}
+	default:
+		noMethod(w, r)
+	}
+}
+
+func (s *restServer) listTopics(w http.ResponseWriter, r *http.Request) {
+	res, err := s.publisher.ListTopics(r.Context(), &pubsubpb.ListTopicsRequest{Project: "projects/" + r.PathValue("project")})
+	write(w, res, err)
+}
+
+func (s *restServer) listTopicSubscriptions(w http.ResponseWriter, r *http.Request) {
+	name, _ := resource(r, "topics", "topic")
+	res, err := s.publisher.ListTopicSubscriptions(r.Context(), &pubsubpb.ListTopicSubscriptionsRequest{Topic: name})
+	write(w, res, err)
+}
+
+func (s *restServer) subscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name, verb := resource(r, "subscriptions", "subscription")
+	switch {
+	case verb == "" && r.Method == http.MethodPut:
+		req := &pubsubpb.Subscription{}
+		if decode(w, r, req) {
+			req.Name = name
+			res, err := s.subscriber.CreateSubscription(ctx, req)
+			write(w, res, err)
+		}
+	case verb == "" && r.Method == http.MethodGet:
+		res, err := s.subscriber.GetSubscription(ctx, &pubsubpb.GetSubscriptionRequest{Subscription: name})
+		write(w, res, err)
+	case verb == "" && r.Method == http.MethodDelete:
+		res, err := s.subscriber.DeleteSubscription(ctx, &pubsubpb.DeleteSubscriptionRequest{Subscription: name})
+		write(w, res, err)
+	case verb == "stream" && r.Method == http.MethodGet:
+		s.stream(w, r, name)
+	case verb == "pull" && r.Method == http.MethodPost:
+		req := &pubsubpb.PullRequest{}
+		if decode(w, r, req) {
+			req.Subscription = name
+			res, err := s.subscriber.Pull(ctx, req)
+			write(w, res, err)
+		}
+	case verb == "acknowledge" && r.Method == http.MethodPost:
+		req := &pubsubpb.AcknowledgeRequest{}
+		if decode(w, r, req) {
+			req.Subscription = name
+			res, err := s.subscriber.Acknowledge(ctx, req)
+			write(w, res, err)
+		}
+	case verb == "modifyAckDeadline" && r.Method == http.MethodPost:
+		req := &pubsubpb.ModifyAckDeadlineRequest{}
+		if decode(w, r, req) {
+			req.Subscription = name
+			res, err := s.subscriber.ModifyAckDeadline(ctx, req)
+			write(w, res, err)
+		}
+	default:
+		noMethod(w, r)
+	}
+}
+
+func (s *restServer) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	res, err := s.subscriber.ListSubscriptions(r.Context(), &pubsubpb.ListSubscriptionsRequest{Project: "projects/" + r.PathValue("project")})
+	write(w, res, err)
+}
+
+// decode reads the JSON request body into req, writing an error response and returning false when it is invalid. An
+// empty body leaves req empty.
+func decode(w http.ResponseWriter, r *http.Request, req proto.Message) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "failed to read request body: %v", err))
+		return false
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return true
+	}
+	if err := protojson.Unmarshal(body, req); err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "invalid JSON payload: %v", err))
+		return false
+	}
+	return true
+}
+
+// write writes res as the JSON response body, or err as an error response.
+func write(w http.ResponseWriter, res proto.Message, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := protojson.Marshal(res)
+	if err != nil {
+		writeError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// restError is the error body of Google APIs, e.g. {"error": {"code": 404, "message": "...", "status": "NOT_FOUND"}}.
+type restError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(grpcError(err))
+	var body restError
+	body.Error.Code = httpStatus(st.Code())
+	body.Error.Message = st.Message()
+	body.Error.Status = code.Code(st.Code()).String()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(body.Error.Code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// noMethod reports requests that do not match a method of the API with NOT_FOUND, as Google APIs do.
+func noMethod(w http.ResponseWriter, r *http.Request) {
+	writeError(w, status.Errorf(codes.NotFound, "no method for %s %s", r.Method, r.URL.Path))
+}
+
+// httpStatus returns the HTTP status code Google APIs use for a gRPC status code.
+func httpStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
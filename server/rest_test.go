@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	service "github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// call sends a JSON request to the REST server and decodes the JSON response into res, returning the status code.
+func call(t *testing.T, srv *httptest.Server, method, path, body string, res any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to send %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatalf("failed to decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestREST(t *testing.T) {
+	ctx := context.Background()
+	svc, err := service.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	defer svc.Close()
+	if err := svc.Init(ctx); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+	srv := httptest.NewServer(NewREST(svc))
+	defer srv.Close()
+
+	var topic struct{ Name string }
+	if code := call(t, srv, "PUT", "/v1/projects/test/topics/orders", "", &topic); code != http.StatusOK {
+		t.Fatalf("expected creating a topic to succeed, got %d", code)
+	}
+	if topic.Name != "projects/test/topics/orders" {
+		t.Fatalf("unexpected topic name %q", topic.Name)
+	}
+	var apiErr restError
+	if code := call(t, srv, "PUT", "/v1/projects/test/topics/orders", "", &apiErr); code != http.StatusConflict {
+		t.Fatalf("expected creating a topic twice to fail with 409, got %d", code)
+	}
+	if apiErr.Error.Status != "ALREADY_EXISTS" || apiErr.Error.Code != http.StatusConflict {
+		t.Fatalf("unexpected error body %+v", apiErr)
+	}
+	if code := call(t, srv, "GET", "/v1/projects/test/topics/missing", "", &apiErr); code != http.StatusNotFound || apiErr.Error.Status != "NOT_FOUND" {
+		t.Fatalf("expected a missing topic to be reported with 404, got %d %+v", code, apiErr)
+	}
+	if code := call(t, srv, "POST", "/v1/projects/test/topics/orders:explode", "{}", nil); code != http.StatusNotFound {
+		t.Fatalf("expected an unknown method to fail with 404, got %d", code)
+	}
+
+	var topics struct{ Topics []struct{ Name string } }
+	call(t, srv, "GET", "/v1/projects/test/topics", "", &topics)
+	if len(topics.Topics) != 1 || topics.Topics[0].Name != "projects/test/topics/orders" {
+		t.Fatalf("unexpected topics %+v", topics)
+	}
+	topics.Topics = nil
+	call(t, srv, "GET", "/v1/projects/other/topics", "", &topics)
+	if len(topics.Topics) != 0 {
+		t.Fatalf("expected topics to be listed per project, got %+v", topics)
+	}
+
+	if code := call(t, srv, "PUT", "/v1/projects/test/subscriptions/billing",
+		`{"topic": "projects/test/topics/orders", "ackDeadlineSeconds": 30}`, nil); code != http.StatusOK {
+		t.Fatalf("expected creating a subscription to succeed, got %d", code)
+	}
+
+	data := base64.StdEncoding.EncodeToString([]byte("hello"))
+	var published struct{ MessageIds []string }
+	if code := call(t, srv, "POST", "/v1/projects/test/topics/orders:publish",
+		`{"messages": [{"data": "`+data+`", "attributes": {"region": "eu"}}]}`, &published); code != http.StatusOK {
+		t.Fatalf("expected publishing to succeed, got %d", code)
+	}
+	if len(published.MessageIds) != 1 {
+		t.Fatalf("expected one message ID, got %v", published.MessageIds)
+	}
+	if code := call(t, srv, "POST", "/v1/projects/test/topics/orders:publish", `{"messages": [{"data": 42}]}`, &apiErr); code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid payload to fail with 400, got %d", code)
+	}
+
+	var pulled struct {
+		ReceivedMessages []struct {
+			AckId   string
+			Message struct {
+				Data       string
+				Attributes map[string]string
+				MessageId  string
+			}
+		}
+	}
+	if code := call(t, srv, "POST", "/v1/projects/test/subscriptions/billing:pull", "", &apiErr); code != http.StatusBadRequest {
+		t.Fatalf("expected pulling without maxMessages to fail with 400, got %d", code)
+	}
+	call(t, srv, "POST", "/v1/projects/test/subscriptions/billing:pull", `{"maxMessages": 10}`, &pulled)
+	if len(pulled.ReceivedMessages) != 1 {
+		t.Fatalf("expected one message, got %+v", pulled)
+	}
+	received := pulled.ReceivedMessages[0]
+	if received.Message.Data != data || received.Message.Attributes["region"] != "eu" || received.Message.MessageId != published.MessageIds[0] {
+		t.Fatalf("unexpected message %+v", received)
+	}
+
+	// Expiring the lease makes the message available again, and acknowledging it removes it.
+	ackIDs := `"ackIds": ["` + received.AckId + `"]`
+	if code := call(t, srv, "POST", "/v1/projects/test/subscriptions/billing:modifyAckDeadline", `{`+ackIDs+`, "ackDeadlineSeconds": 0}`, nil); code != http.StatusOK {
+		t.Fatalf("expected modifying the ack deadline to succeed, got %d", code)
+	}
+	pulled.ReceivedMessages = nil
+	call(t, srv, "POST", "/v1/projects/test/subscriptions/billing:pull", `{"maxMessages": 10}`, &pulled)
+	if len(pulled.ReceivedMessages) != 1 {
+		t.Fatalf("expected the message to be redelivered, got %+v", pulled)
+	}
+	if code := call(t, srv, "POST", "/v1/projects/test/subscriptions/billing:acknowledge", `{`+ackIDs+`}`, nil); code != http.StatusOK {
+		t.Fatalf("expected acknowledging to succeed, got %d", code)
+	}
+	messages, err := svc.GetMessages(ctx, "projects/test/subscriptions/billing")
+	if err != nil {
+		t.Fatalf("failed to get messages: %v", err)
+	}
+	if len(messages) != 1 || !messages[0].Acknowledged {
+		t.Fatalf("expected the message to be acknowledged, got %+v", messages)
+	}
+
+	if code := call(t, srv, "DELETE", "/v1/projects/test/topics/orders", "", nil); code != http.StatusOK {
+		t.Fatalf("expected deleting the topic to succeed, got %d", code)
+	}
+	if code := call(t, srv, "GET", "/v1/projects/test/topics/orders", "", nil); code != http.StatusNotFound {
+		t.Fatalf("expected the deleted topic to be gone, got %d", code)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/nigel-campbell/pubsub/internal/rpc"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewRPC returns an HTTP handler serving every method of pubsub.API on top of svc, for the client package. Close is
+// not served, as the daemon owns svc.
+func NewRPC(svc pubsub.API) http.Handler {
+	api := reflect.TypeFor[pubsub.API]()
+	value := reflect.ValueOf(svc)
+	mux := http.NewServeMux()
+	for i := range api.NumMethod() {
+		name := api.Method(i).Name
+		if name == "Close" {
+			continue
+		}
+		mux.Handle("POST "+rpc.PathPrefix+name, rpcMethod(value.MethodByName(name)))
+	}
+	mux.HandleFunc(rpc.PathPrefix, func(w http.ResponseWriter, r *http.Request) {
+		writeRPC(w, nil, status.Errorf(codes.NotFound, "no method for %s %s", r.Method, r.URL.Path))
+	})
+	return mux
+}
+
+// rpcMethod calls a method with the request's context and the arguments in its body, and responds with the results.
+func rpcMethod(method reflect.Value) http.HandlerFunc {
+	t := method.Type()
+	return func(w http.ResponseWriter, r *http.Request) {
+		var raw []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			writeRPC(w, nil, status.Errorf(codes.InvalidArgument, "invalid arguments: %v", err))
+			return
+		}
+		if len(raw) != t.NumIn()-1 {
+			writeRPC(w, nil, status.Errorf(codes.InvalidArgument, "expected %d arguments, got %d", t.NumIn()-1, len(raw)))
+			return
+		}
+		// The request's context is canceled when the client gives up.
+		ctx := r.Context()
+		if project := r.Header.Get(rpc.ProjectHeader); project != "" {
+			ctx = pubsub.WithProject(ctx, project)
+		}
+		args := []reflect.Value{reflect.ValueOf(ctx)}
+		for i, arg := range raw {
+			v := reflect.New(t.In(i + 1))
+			if err := json.Unmarshal(arg, v.Interface()); err != nil {
+				writeRPC(w, nil, status.Errorf(codes.InvalidArgument, "invalid argument %d: %v", i+1, err))
+				return
+			}
+			args = append(args, v.Elem())
+		}
+
+		out := method.Call(args)
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			writeRPC(w, nil, err)
+			return
+		}
+		var results []any
+		for _, v := range out[:len(out)-1] {
+			results = append(results, v.Interface())
+		}
+		writeRPC(w, results, nil)
+	}
+}
+
+// writeRPC responds with the results of a call, or its error with the HTTP status of the equivalent gRPC status.
+func writeRPC(w http.ResponseWriter, results []any, err error) {
+	res := rpc.Response{}
+	code := http.StatusOK
+	if err != nil {
+		res.Error = rpc.NewError(err)
+		code = httpStatus(status.Code(grpcError(err)))
+		if st, ok := status.FromError(err); ok {
+			res.Error.Message = st.Message()
+		}
+	}
+	for _, result := range results {
+		data, err := json.Marshal(result)
+		if err != nil {
+			writeRPC(w, nil, fmt.Errorf("failed to encode result: %w", err))
+			return
+		}
+		res.Results = append(res.Results, data)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/nigel-campbell/pubsub/internal/stomp"
+	"github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// stompHeartBeat is the interval at which the server offers to send heart-beats and asks to receive them.
+const stompHeartBeat = 10 * time.Second
+
+// Ack modes of SUBSCRIBE.
+const (
+	stompAckAuto             = "auto"
+	stompAckClient           = "client"
+	stompAckClientIndividual = "client-individual"
+)
+
+const (
+	// Prefixes of the destinations of topics and subscriptions.
+	stompTopicDestination        = "/topic/"
+	stompSubscriptionDestination = "/subscription/"
+	// stompPrefetchHeader caps the messages of a subscription awaiting acknowledgement, as in RabbitMQ.
+	stompPrefetchHeader = "prefetch-count"
+)
+
+// stompReservedHeaders are the headers of SEND that are not message attributes.
+var stompReservedHeaders = map[string]bool{"destination": true, "content-length": true, "receipt": true, "transaction": true}
+
+// STOMPServer serves STOMP 1.2 clients.
+//
+// SEND publishes its body to the topic of a /topic/TOPIC destination, with its headers, but destination,
+// content-length, receipt and transaction, as attributes. SUBSCRIBE to /subscription/SUBSCRIPTION pulls from an
+// existing subscription, sharing its messages with its other subscribers, while SUBSCRIBE to /topic/TOPIC creates a
+// subscription of its own for the length of the STOMP subscription. MESSAGE frames carry the attributes as headers.
+//
+// Messages are leased while delivered: with the auto ack mode they are acknowledged once sent, and with the client
+// and client-individual modes they are acknowledged by ACK and nacked by NACK, cumulatively with client. The leases
+// of the messages not acknowledged are extended until the client unsubscribes or disconnects, when the messages are
+// nacked so that they are redelivered. The prefetch-count header of SUBSCRIBE caps the messages awaiting
+// acknowledgement, 100 by default.
+type STOMPServer struct {
+	svc   *pubsub.Service
+	logf  func(format string, args ...any)
+	conns connServer
+}
+
+// NewSTOMP returns a STOMP server on top of svc. logf reports the errors closing connections, and defaults to
+// log.Printf.
+func NewSTOMP(svc *pubsub.Service, logf func(format string, args ...any)) *STOMPServer {
+	if logf == nil {
+		logf = log.Printf
+	}
+	return &STOMPServer{svc: svc, logf: logf}
+}
+
+// Serve accepts STOMP connections on lis until Close is called, when it returns ErrServerClosed.
+func (s *STOMPServer) Serve(lis net.Listener) error {
+	return s.conns.serve(lis, s.serveConn)
+}
+
+// Close stops the listeners and closes every connection, and returns once their messages have been nacked.
+func (s *STOMPServer) Close() error {
+	s.conns.close()
+	return nil
+}
+
+func (s *STOMPServer) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	var connect *stomp.Frame
+	for connect == nil || connect.Command == "" {
+		var err error
+		if connect, err = stomp.Read(r); err != nil {
+			return
+		}
+	}
+	if connect.Command != "CONNECT" && connect.Command != "STOMP" {
+		stomp.Write(conn, stompErrorFrame(connect, fmt.Errorf("expected CONNECT, got %s", connect.Command)))
+		return
+	}
+	if versions, ok := connect.Lookup("accept-version"); !ok || !slices.Contains(strings.Split(versions, ","), stomp.Version) {
+		f := stompErrorFrame(connect, errors.New("supported protocol versions are "+stomp.Version))
+		f.Add("version", stomp.Version)
+		stomp.Write(conn, f)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &stompConn{
+		svc:           s.svc,
+		conn:          conn,
+		ctx:           ctx,
+		cancel:        cancel,
+		prefix:        "stomp-" + randomHex() + "-",
+		subscriptions: map[string]*stompSubscription{},
+		transactions:  map[string][]*stomp.Frame{},
+		acks:          map[string]*stompSubscription{},
+	}
+	send, receive := heartBeats(connect.Get("heart-beat"))
+	err := c.run(r, send, receive)
+	conn.Close()
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		s.logf("STOMP connection from %s closed: %v", conn.RemoteAddr(), err)
+	}
+	c.close()
+}
+
+// heartBeats negotiates heart-beating from the heart-beat header of CONNECT, returning how often the server sends
+// heart-beats and how often it expects them, 0 meaning never.
+func heartBeats(header string) (send, receive time.Duration) {
+	cx, cy, ok := strings.Cut(header, ",")
+	if !ok {
+		return 0, 0
+	}
+	interval := func(client string) time.Duration {
+		ms, err := strconv.Atoi(strings.TrimSpace(client))
+		if err != nil || ms <= 0 {
+			return 0
+		}
+		return max(time.Duration(ms)*time.Millisecond, stompHeartBeat)
+	}
+	return interval(cy), interval(cx)
+}
+
+// stompErrorFrame returns the ERROR frame reporting err in response to f.
+func stompErrorFrame(f *stomp.Frame, err error) *stomp.Frame {
+	message := err.Error()
+	if i := strings.IndexByte(message, '\n'); i >= 0 {
+		message = message[:i]
+	}
+	e := &stomp.Frame{Command: "ERROR", Body: []byte(err.Error())}
+	e.Add("message", message)
+	e.Add("content-type", "text/plain")
+	if receipt, ok := f.Lookup("receipt"); ok {
+		e.Add("receipt-id", receipt)
+	}
+	return e
+}
+
+// stompConn is a connected client.
+type stompConn struct {
+	svc     *pubsub.Service
+	conn    net.Conn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	prefix  string // Of the names of the subscriptions created for /topic/ destinations
+	writeMu sync.Mutex
+
+	// Only used by the goroutine reading the connection.
+	subscriptions map[string]*stompSubscription // By ID
+	transactions  map[string][]*stomp.Frame     // Frames of the transactions begun, by transaction ID
+	seq           int                           // Numbers the names of the subscriptions created
+
+	mu   sync.Mutex
+	acks map[string]*stompSubscription // Subscriptions of the messages awaiting ACK or NACK, by ack ID
+}
+
+// stompSubscription delivers the messages of a Pub/Sub subscription to a STOMP subscription.
+type stompSubscription struct {
+	id          string
+	destination string
+	ackMode     string
+	st          *streamer
+	temporary   bool // The subscription was created for a /topic/ destination and is deleted with the STOMP one
+	cancel      context.CancelFunc
+	done        chan struct{} // Closed once delivery has stopped
+	pending     []string      // Ack IDs awaiting ACK or NACK in the order delivered, guarded by the connection's mu
+}
+
+func (c *stompConn) write(f *stomp.Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return stomp.Write(c.conn, f)
+}
+
+// run sends CONNECTED and handles the frames of the client until it disconnects, breaks the protocol or a frame
+// fails, which is reported with ERROR.
+func (c *stompConn) run(r *bufio.Reader, send, receive time.Duration) error {
+	connected := &stomp.Frame{Command: "CONNECTED"}
+	connected.Add("version", stomp.Version)
+	connected.Add("heart-beat", fmt.Sprintf("%d,%d", send.Milliseconds(), receive.Milliseconds()))
+	connected.Add("server", "pubsub")
+	if err := c.write(connected); err != nil {
+		return err
+	}
+	if send > 0 {
+		go func() {
+			ticker := time.NewTicker(send)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-c.ctx.Done():
+					return
+				case <-ticker.C:
+					if c.write(&stomp.Frame{}) != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for {
+		// Heart-beats are expected within twice their interval, to allow for delays.
+		if receive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(2 * receive))
+		} else {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		f, err := stomp.Read(r)
+		if err != nil {
+			if errors.Is(err, stomp.ErrMalformed) {
+				c.write(stompErrorFrame(&stomp.Frame{}, err))
+			}
+			return err
+		}
+		if f.Command == "" {
+			continue
+		}
+		if f.Command == "DISCONNECT" {
+			return c.receipt(f)
+		}
+		if err := c.handle(f); err != nil {
+			c.write(stompErrorFrame(f, err))
+			return err
+		}
+		if err := c.receipt(f); err != nil {
+			return err
+		}
+	}
+}
+
+// receipt sends the RECEIPT asked for by a frame, if any.
+func (c *stompConn) receipt(f *stomp.Frame) error {
+	id, ok := f.Lookup("receipt")
+	if !ok {
+		return nil
+	}
+	receipt := &stomp.Frame{Command: "RECEIPT"}
+	receipt.Add("receipt-id", id)
+	return c.write(receipt)
+}
+
+func (c *stompConn) handle(f *stomp.Frame) error {
+	if tx, ok := f.Lookup("transaction"); ok && (f.Command == "SEND" || f.Command == "ACK" || f.Command == "NACK") {
+		if _, ok := c.transactions[tx]; !ok {
+			return fmt.Errorf("transaction %q has not begun", tx)
+		}
+		c.transactions[tx] = append(c.transactions[tx], f)
+		return nil
+	}
+
+	switch f.Command {
+	case "SEND":
+		return c.send(f)
+	case "SUBSCRIBE":
+		return c.subscribe(f)
+	case "UNSUBSCRIBE":
+		id, err := required(f, "id")
+		if err != nil {
+			return err
+		}
+		sub := c.subscriptions[id]
+		if sub == nil {
+			return fmt.Errorf("no subscription with ID %q", id)
+		}
+		delete(c.subscriptions, id)
+		c.stop(sub)
+		return nil
+	case "ACK", "NACK":
+		return c.ack(f)
+	case "BEGIN", "COMMIT", "ABORT":
+		tx, err := required(f, "transaction")
+		if err != nil {
+			return err
+		}
+		frames, begun := c.transactions[tx]
+		if f.Command == "BEGIN" {
+			if begun {
+				return fmt.Errorf("transaction %q has already begun", tx)
+			}
+			c.transactions[tx] = []*stomp.Frame{}
+			return nil
+		}
+		if !begun {
+			return fmt.Errorf("transaction %q has not begun", tx)
+		}
+		delete(c.transactions, tx)
+		if f.Command == "ABORT" {
+			return nil
+		}
+		for _, f := range frames {
+			var err error
+			if f.Command == "SEND" {
+				err = c.send(f)
+			} else {
+				err = c.ack(f)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to commit transaction %q: %w", tx, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected %s frame", f.Command)
+}
+
+func required(f *stomp.Frame, header string) (string, error) {
+	value, ok := f.Lookup(header)
+	if !ok || value == "" {
+		return "", fmt.Errorf("%s frame without a %s header", f.Command, header)
+	}
+	return value, nil
+}
+
+// send publishes the message of a SEND frame.
+func (c *stompConn) send(f *stomp.Frame) error {
+	destination, err := required(f, "destination")
+	if err != nil {
+		return err
+	}
+	topic, ok := strings.CutPrefix(destination, stompTopicDestination)
+	if !ok || topic == "" {
+		return fmt.Errorf("invalid destination %q, expected %sTOPIC", destination, stompTopicDestination)
+	}
+	var attributes map[string]string
+	for _, h := range f.Headers {
+		if stompReservedHeaders[h.Key] {
+			continue
+		}
+		if attributes == nil {
+			attributes = map[string]string{}
+		}
+		if _, ok := attributes[h.Key]; !ok {
+			attributes[h.Key] = h.Value
+		}
+	}
+	return c.svc.PublishMessage(c.ctx, topic, string(f.Body), attributes)
+}
+
+// subscribe starts delivering the messages of a destination.
+func (c *stompConn) subscribe(f *stomp.Frame) error {
+	id, err := required(f, "id")
+	if err != nil {
+		return err
+	}
+	if c.subscriptions[id] != nil {
+		return fmt.Errorf("subscription ID %q is already used", id)
+	}
+	destination, err := required(f, "destination")
+	if err != nil {
+		return err
+	}
+	ackMode := stompAckAuto
+	if mode, ok := f.Lookup("ack"); ok {
+		ackMode = mode
+	}
+	if ackMode != stompAckAuto && ackMode != stompAckClient && ackMode != stompAckClientIndividual {
+		return fmt.Errorf("invalid ack mode %q", ackMode)
+	}
+	prefetch := defaultMaxOutstanding
+	if v, ok := f.Lookup(stompPrefetchHeader); ok {
+		if prefetch, err = strconv.Atoi(v); err != nil || prefetch <= 0 {
+			return fmt.Errorf("invalid %s %q", stompPrefetchHeader, v)
+		}
+	}
+
+	sub := &stompSubscription{id: id, destination: destination, ackMode: ackMode, done: make(chan struct{})}
+	var name string
+	if topic, ok := strings.CutPrefix(destination, stompTopicDestination); ok && topic != "" {
+		c.seq++
+		name = c.prefix + strconv.Itoa(c.seq)
+		if err := c.svc.CreateSubscription(c.ctx, topic, name, sessionSubscriptionConfig("stomp")); err != nil {
+			return err
+		}
+		sub.temporary = true
+	} else if name, ok = strings.CutPrefix(destination, stompSubscriptionDestination); !ok || name == "" {
+		return fmt.Errorf("invalid destination %q, expected %sTOPIC or %sSUBSCRIPTION", destination, stompTopicDestination, stompSubscriptionDestination)
+	}
+	subscription, err := c.svc.GetSubscription(c.ctx, name)
+	if err == nil && subscription.Detached {
+		err = fmt.Errorf("cannot subscribe to subscription %q: %w", name, pubsub.ErrDetached)
+	}
+	if err != nil {
+		if sub.temporary {
+			c.svc.DeleteSubscription(c.ctx, name)
+		}
+		return err
+	}
+	sub.st = &streamer{
+		svc:            c.svc,
+		subscription:   subscription.ResourceName(),
+		ackDeadline:    subscription.Config.EffectiveAckDeadline(),
+		maxOutstanding: prefetch,
+		outstanding:    map[int]bool{},
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	sub.cancel = cancel
+	c.subscriptions[id] = sub
+	go func() {
+		defer close(sub.done)
+		// Heart-beats are sent for the whole connection.
+		sub.st.run(ctx, func(message *pubsubpb.ReceivedMessage) error {
+			return c.deliver(ctx, sub, message)
+		}, func() error { return nil })
+		// The subscription was deleted or detached, or the connection failed.
+		if ctx.Err() == nil {
+			c.write(stompErrorFrame(&stomp.Frame{}, fmt.Errorf("subscription %q stopped delivering messages", id)))
+			c.conn.Close()
+		}
+	}()
+	return nil
+}
+
+// deliver sends a message to the client as a MESSAGE frame.
+func (c *stompConn) deliver(ctx context.Context, sub *stompSubscription, message *pubsubpb.ReceivedMessage) error {
+	f := &stomp.Frame{Command: "MESSAGE", Body: message.Message.Data}
+	f.Add("subscription", sub.id)
+	f.Add("message-id", message.Message.MessageId)
+	f.Add("destination", sub.destination)
+	if sub.ackMode != stompAckAuto {
+		f.Add("ack", message.AckId)
+		// The message awaits acknowledgement before it is sent, as the client may acknowledge it at once.
+		c.mu.Lock()
+		c.acks[message.AckId] = sub
+		sub.pending = append(sub.pending, message.AckId)
+		c.mu.Unlock()
+	}
+	for _, key := range slices.Sorted(maps.Keys(message.Message.Attributes)) {
+		f.Add(key, message.Message.Attributes[key])
+	}
+	if err := c.write(f); err != nil {
+		return err
+	}
+	if sub.ackMode == stompAckAuto {
+		id, err := strconv.Atoi(message.AckId)
+		if err != nil {
+			return err
+		}
+		sub.st.forget(id)
+		return c.svc.AcknowledgeMessage(ctx, sub.st.subscription, id)
+	}
+	return nil
+}
+
+// ack acknowledges or nacks the message of an ACK or NACK frame, along with those delivered before it on the same
+// subscription when its ack mode is client.
+func (c *stompConn) ack(f *stomp.Frame) error {
+	ackID, err := required(f, "id")
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	sub := c.acks[ackID]
+	var ids []string
+	if sub != nil {
+		i := slices.Index(sub.pending, ackID)
+		if sub.ackMode == stompAckClient {
+			ids = slices.Clone(sub.pending[:i+1])
+			sub.pending = slices.Delete(sub.pending, 0, i+1)
+		} else {
+			ids = []string{ackID}
+			sub.pending = slices.Delete(sub.pending, i, i+1)
+		}
+		for _, id := range ids {
+			delete(c.acks, id)
+		}
+	}
+	c.mu.Unlock()
+	if sub == nil {
+		return fmt.Errorf("no message awaiting acknowledgement with ack ID %q", ackID)
+	}
+
+	for _, ackID := range ids {
+		id, err := strconv.Atoi(ackID)
+		if err != nil {
+			return err
+		}
+		sub.st.forget(id)
+		if f.Command == "ACK" {
+			err = c.svc.AcknowledgeMessage(c.ctx, sub.st.subscription, id)
+		} else {
+			err = c.svc.ModifyAckDeadline(c.ctx, sub.st.subscription, id, time.Now())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close ends the connection once it is closed: delivery stops, and the messages awaiting acknowledgement are nacked.
+func (c *stompConn) close() {
+	c.cancel()
+	for id, sub := range c.subscriptions {
+		delete(c.subscriptions, id)
+		c.stop(sub)
+	}
+}
+
+// stop stops delivering the messages of a subscription, which nacks those awaiting acknowledgement, and deletes it if
+// it was created for a /topic/ destination.
+func (c *stompConn) stop(sub *stompSubscription) {
+	sub.cancel()
+	<-sub.done
+	c.mu.Lock()
+	for _, id := range sub.pending {
+		delete(c.acks, id)
+	}
+	sub.pending = nil
+	c.mu.Unlock()
+	if sub.temporary {
+		ctx, cancel := context.WithTimeout(context.Background(), nackTimeout)
+		defer cancel()
+		c.svc.DeleteSubscription(ctx, sub.st.subscription)
+	}
+}
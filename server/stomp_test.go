@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nigel-campbell/pubsub/internal/stomp"
+	service "github.com/nigel-campbell/pubsub/pubsub"
+)
+
+// stompClient is a minimal STOMP client reading and writing frames one at a time.
+type stompClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialSTOMP(t *testing.T, addr string) *stompClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &stompClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	c.write("CONNECT", nil, "accept-version", "1.0,1.2", "host", "localhost")
+	if f := c.read(); f.Command != "CONNECTED" || f.Get("version") != "1.2" {
+		t.Fatalf("expected the connection to be accepted, got %+v", f)
+	}
+	return c
+}
+
+// write sends a frame with the headers given as key and value pairs.
+func (c *stompClient) write(command string, body []byte, headers ...string) {
+	c.t.Helper()
+	f := &stomp.Frame{Command: command, Body: body}
+	for i := 0; i < len(headers); i += 2 {
+		f.Add(headers[i], headers[i+1])
+	}
+	if err := stomp.Write(c.conn, f); err != nil {
+		c.t.Fatalf("failed to write %s: %v", command, err)
+	}
+}
+
+func (c *stompClient) read() *stomp.Frame {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		f, err := stomp.Read(c.r)
+		if err != nil {
+			c.t.Fatalf("failed to read frame: %v", err)
+		}
+		if f.Command != "" {
+			return f
+		}
+	}
+}
+
+// expect reads the next frame, failing unless it has the command.
+func (c *stompClient) expect(command string) *stomp.Frame {
+	c.t.Helper()
+	f := c.read()
+	if f.Command != command {
+		c.t.Fatalf("expected %s, got %s %v %q", command, f.Command, f.Headers, f.Body)
+	}
+	return f
+}
+
+func TestSTOMP(t *testing.T) {
+	ctx := context.Background()
+	svc, err := service.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	defer svc.Close()
+	if err := svc.Init(ctx); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+	if err := svc.CreateTopic(ctx, "orders", nil); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	if err := svc.CreateSubscription(ctx, "orders", "billing", nil); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := NewSTOMP(svc, t.Logf)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(lis) }()
+	defer func() {
+		srv.Close()
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected Serve to return ErrServerClosed, got %v", err)
+		}
+	}()
+
+	consumer := dialSTOMP(t, lis.Addr().String())
+	consumer.write("SUBSCRIBE", nil, "id", "0", "destination", "/subscription/billing", "ack", "client-individual", "receipt", "r1")
+	if f := consumer.expect("RECEIPT"); f.Get("receipt-id") != "r1" {
+		t.Fatalf("unexpected receipt %v", f.Headers)
+	}
+
+	// Headers of SEND travel as attributes, and back as headers of MESSAGE.
+	producer := dialSTOMP(t, lis.Addr().String())
+	producer.write("SEND", []byte("first"), "destination", "/topic/orders", "region", "eu", "receipt", "r2")
+	producer.expect("RECEIPT")
+	first := consumer.expect("MESSAGE")
+	if string(first.Body) != "first" || first.Get("subscription") != "0" || first.Get("destination") != "/subscription/billing" ||
+		first.Get("region") != "eu" || first.Get("ack") == "" || first.Get("message-id") == "" {
+		t.Fatalf("unexpected message %v %q", first.Headers, first.Body)
+	}
+	consumer.write("ACK", nil, "id", first.Get("ack"))
+
+	// NACK makes the message available again.
+	producer.write("SEND", []byte("second"), "destination", "/topic/orders")
+	second := consumer.expect("MESSAGE")
+	if string(second.Body) != "second" {
+		t.Fatalf("unexpected message %q", second.Body)
+	}
+	consumer.write("NACK", nil, "id", second.Get("ack"))
+	if again := consumer.expect("MESSAGE"); again.Get("message-id") != second.Get("message-id") {
+		t.Fatalf("expected the nacked message to be redelivered, got %v %q", again.Headers, again.Body)
+	}
+
+	// Transactions apply their frames on COMMIT only.
+	producer.write("BEGIN", nil, "transaction", "tx1")
+	producer.write("SEND", []byte("aborted"), "destination", "/topic/orders", "transaction", "tx1")
+	producer.write("ABORT", nil, "transaction", "tx1")
+	producer.write("BEGIN", nil, "transaction", "tx2")
+	producer.write("SEND", []byte("committed"), "destination", "/topic/orders", "transaction", "tx2")
+	producer.write("COMMIT", nil, "transaction", "tx2", "receipt", "r3")
+	producer.expect("RECEIPT")
+	if f := consumer.expect("MESSAGE"); string(f.Body) != "committed" {
+		t.Fatalf("expected only the committed message, got %q", f.Body)
+	}
+
+	// Messages not acknowledged are redelivered once the client disconnects.
+	consumer.write("DISCONNECT", nil, "receipt", "bye")
+	consumer.expect("RECEIPT")
+	consumer.conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages, err := svc.Pull(ctx, "billing", 10, time.Time{})
+		if err != nil {
+			t.Fatalf("failed to pull messages: %v", err)
+		}
+		if len(messages) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the unacknowledged messages to be redelivered, got %d", len(messages))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Topic destinations get a subscription of their own, deleted by UNSUBSCRIBE.
+	producer.write("SUBSCRIBE", nil, "id", "t", "destination", "/topic/orders", "receipt", "r4")
+	producer.expect("RECEIPT")
+	producer.write("SEND", []byte("broadcast"), "destination", "/topic/orders")
+	if f := producer.expect("MESSAGE"); string(f.Body) != "broadcast" || f.Get("ack") != "" {
+		t.Fatalf("unexpected message %v %q", f.Headers, f.Body)
+	}
+	producer.write("UNSUBSCRIBE", nil, "id", "t", "receipt", "r5")
+	producer.expect("RECEIPT")
+	subscriptions, err := svc.ListSubscriptions(ctx, "orders")
+	if err != nil || len(subscriptions) != 1 {
+		t.Fatalf("expected unsubscribing to delete the subscription, got %d, %v", len(subscriptions), err)
+	}
+
+	// Subscriptions of other projects are named by resource path, and acknowledged there.
+	if err := svc.CreateTopic(ctx, "projects/team-b/topics/orders", nil); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	if err := svc.CreateSubscription(ctx, "projects/team-b/topics/orders", "projects/team-b/subscriptions/billing", nil); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	other := dialSTOMP(t, lis.Addr().String())
+	other.write("SUBSCRIBE", nil, "id", "b", "destination", "/subscription/projects/team-b/subscriptions/billing", "ack", "client-individual", "receipt", "r7")
+	other.expect("RECEIPT")
+	if err := svc.PublishMessage(ctx, "projects/team-b/topics/orders", "team-b", nil); err != nil {
+		t.Fatalf("failed to publish message: %v", err)
+	}
+	f := other.expect("MESSAGE")
+	if string(f.Body) != "team-b" {
+		t.Fatalf("unexpected message %q", f.Body)
+	}
+	other.write("ACK", nil, "id", f.Get("ack"), "receipt", "r8")
+	other.expect("RECEIPT")
+	messages, err := svc.GetMessages(ctx, "projects/team-b/subscriptions/billing")
+	if err != nil || len(messages) != 1 || !messages[0].Acknowledged {
+		t.Fatalf("expected the message to be acknowledged in its project, got %v, %v", messages, err)
+	}
+
+	// Errors are reported with ERROR, which closes the connection.
+	producer.write("SEND", []byte("lost"), "destination", "/queue/orders", "receipt", "r6")
+	if f := producer.expect("ERROR"); f.Get("receipt-id") != "r6" || f.Get("message") == "" {
+		t.Fatalf("unexpected error %v", f.Headers)
+	}
+}
+
+func TestSTOMPFrames(t *testing.T) {
+	f := &stomp.Frame{Command: "MESSAGE", Body: []byte("a\x00b")}
+	f.Add("key:with\ncolon", "value\\")
+	f.Add("key:with\ncolon", "shadowed")
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		stomp.Write(server, f)
+		server.Write([]byte("\r\n\nSEND\r\ndestination:/topic/x\r\n\r\nbody\x00"))
+	}()
+	r := bufio.NewReader(client)
+	got, err := stomp.Read(r)
+	if err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	if got.Get("key:with\ncolon") != "value\\" || string(got.Body) != "a\x00b" {
+		t.Fatalf("unexpected frame %+v", got)
+	}
+	for _, command := range []string{"", "", "SEND"} {
+		got, err := stomp.Read(r)
+		if err != nil || got.Command != command {
+			t.Fatalf("expected %q, got %+v, %v", command, got, err)
+		}
+		if command == "SEND" && (got.Get("destination") != "/topic/x" || string(got.Body) != "body") {
+			t.Fatalf("unexpected frame %+v", got)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/gorilla/websocket"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// defaultMaxOutstanding caps the messages a stream delivers that have not been acknowledged or nacked yet.
+	defaultMaxOutstanding = 100
+	// keepaliveInterval is how often an idle stream is written to, so that disconnected clients are noticed.
+	keepaliveInterval = 15 * time.Second
+	// nackTimeout bounds the nacking of a disconnected stream's messages.
+	nackTimeout = 5 * time.Second
+)
+
+// stream delivers the messages of a subscription to a browser over Server-Sent Events, or over a WebSocket when the
+// request asks to upgrade. Messages are leased while the stream is connected, and nacked when it disconnects so that
+// they are redelivered.
+func (s *restServer) stream(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := r.Context()
+	// Any page open in a browser could otherwise read and acknowledge the messages of a local emulator.
+	if !s.checkOrigin(r) {
+		writeError(w, status.Errorf(codes.PermissionDenied, "origin %q is not allowed to stream, see serve --allow-origin", r.Header.Get("Origin")))
+		return
+	}
+	svc := s.subscriber.svc
+	subscription, err := svc.GetSubscription(ctx, name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if subscription.Detached {
+		writeError(w, fmt.Errorf("cannot stream subscription %q: %w", name, pubsub.ErrDetached))
+		return
+	}
+	maxOutstanding := defaultMaxOutstanding
+	if v := r.URL.Query().Get("maxOutstanding"); v != "" {
+		if maxOutstanding, err = strconv.Atoi(v); err != nil || maxOutstanding <= 0 {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid maxOutstanding %q", v))
+			return
+		}
+	}
+	st := &streamer{
+		svc:            svc,
+		subscription:   name,
+		ackDeadline:    subscription.Config.EffectiveAckDeadline(),
+		maxOutstanding: maxOutstanding,
+		outstanding:    map[int]bool{},
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already answered.
+			return
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				req := &pubsubpb.StreamingPullRequest{}
+				if err := protojson.Unmarshal(data, req); err == nil {
+					err = st.apply(ctx, req)
+				}
+				if err != nil {
+					reason := status.Convert(grpcError(err)).Message()
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseUnsupportedData, reason), time.Now().Add(time.Second))
+					return
+				}
+			}
+		}()
+		st.run(ctx, func(message *pubsubpb.ReceivedMessage) error {
+			data, err := protojson.Marshal(message)
+			if err != nil {
+				return err
+			}
+			return conn.WriteMessage(websocket.TextMessage, data)
+		}, func() error {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepaliveInterval))
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, status.Error(codes.Unimplemented, "streaming is not supported by this connection"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	st.run(ctx, func(message *pubsubpb.ReceivedMessage) error {
+		data, err := protojson.Marshal(message)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\nevent: message\ndata: %s\n\n", message.GetAckId(), data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, func() error {
+		if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+// checkOrigin reports whether the page a request comes from may stream. Requests without an Origin header do not come
+// from a browser page and are allowed.
+func (s *restServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamer delivers the messages of a subscription to a stream and manages their leases: the leases of outstanding
+// messages are extended while the stream is connected, and the messages nacked when it disconnects.
+type streamer struct {
+	svc            *pubsub.Service
+	subscription   string
+	ackDeadline    time.Duration
+	maxOutstanding int
+
+	mu          sync.Mutex
+	outstanding map[int]bool // Ack IDs delivered and neither acknowledged nor nacked on the stream
+}
+
+// run delivers messages with send until ctx is done or sending fails, calling keepalive when the stream is idle.
+func (st *streamer) run(ctx context.Context, send func(*pubsubpb.ReceivedMessage) error, keepalive func() error) {
+	defer st.nackOutstanding(ctx)
+
+	poll := time.NewTicker(streamingPullInterval)
+	defer poll.Stop()
+	extend := time.NewTicker(st.ackDeadline / 2)
+	defer extend.Stop()
+	idle := time.NewTicker(keepaliveInterval)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			st.mu.Lock()
+			capacity := st.maxOutstanding - len(st.outstanding)
+			st.mu.Unlock()
+			if capacity <= 0 {
+				continue
+			}
+			messages, err := st.svc.Pull(ctx, st.subscription, capacity, time.Now().Add(st.ackDeadline))
+			if err != nil {
+				return
+			}
+			for _, message := range messages {
+				st.mu.Lock()
+				st.outstanding[message.ID] = true
+				st.mu.Unlock()
+				if err := send(receivedMessage(message)); err != nil {
+					return
+				}
+			}
+			if len(messages) > 0 {
+				idle.Reset(keepaliveInterval)
+			}
+		case <-extend.C:
+			if err := st.extend(ctx); err != nil {
+				return
+			}
+		case <-idle.C:
+			if err := keepalive(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// extend renews the leases of the outstanding messages, first forgetting those acknowledged through other means, such
+// as the :acknowledge method.
+func (st *streamer) extend(ctx context.Context) error {
+	messages, err := st.svc.GetMessages(ctx, st.subscription)
+	if err != nil {
+		return err
+	}
+	unacked := map[int]bool{}
+	for _, message := range messages {
+		if !message.Acknowledged {
+			unacked[message.ID] = true
+		}
+	}
+	st.mu.Lock()
+	var ids []int
+	for id := range st.outstanding {
+		if unacked[id] {
+			ids = append(ids, id)
+		} else {
+			delete(st.outstanding, id)
+		}
+	}
+	st.mu.Unlock()
+
+	deadline := time.Now().Add(st.ackDeadline)
+	for _, id := range ids {
+		if err := st.svc.ModifyAckDeadline(ctx, st.subscription, id, deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply applies the acks and deadline modifications a WebSocket client sends. A deadline of zero nacks the message.
+func (st *streamer) apply(ctx context.Context, req *pubsubpb.StreamingPullRequest) error {
+	acks, err := ackIDs(req.GetAckIds())
+	if err != nil {
+		return err
+	}
+	for _, id := range acks {
+		if err := st.svc.AcknowledgeMessage(ctx, st.subscription, id); err != nil {
+			return err
+		}
+		st.forget(id)
+	}
+	if len(req.GetModifyDeadlineAckIds()) != len(req.GetModifyDeadlineSeconds()) {
+		return status.Error(codes.InvalidArgument, "modifyDeadlineAckIds and modifyDeadlineSeconds must have the same length")
+	}
+	modacks, err := ackIDs(req.GetModifyDeadlineAckIds())
+	if err != nil {
+		return err
+	}
+	for i, id := range modacks {
+		seconds := req.GetModifyDeadlineSeconds()[i]
+		if err := st.svc.ModifyAckDeadline(ctx, st.subscription, id, time.Now().Add(time.Duration(seconds)*time.Second)); err != nil {
+			return err
+		}
+		if seconds == 0 {
+			st.forget(id)
+		}
+	}
+	return nil
+}
+
+func (st *streamer) forget(id int) {
+	st.mu.Lock()
+	delete(st.outstanding, id)
+	st.mu.Unlock()
+}
+
+// nackOutstanding makes the outstanding messages available again once the stream has disconnected.
+func (st *streamer) nackOutstanding(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), nackTimeout)
+	defer cancel()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for id := range st.outstanding {
+		st.svc.ModifyAckDeadline(ctx, st.subscription, id, time.Now())
+	}
+	st.outstanding = map[int]bool{}
+}
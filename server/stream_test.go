@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/gorilla/websocket"
+	service "github.com/nigel-campbell/pubsub/pubsub"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// newStreamTest serves a fresh service over REST, with a topic and a subscription that has two messages.
+func newStreamTest(t *testing.T, allowedOrigins ...string) (*httptest.Server, *service.Service) {
+	t.Helper()
+	ctx := service.WithProject(context.Background(), "test")
+	svc, err := service.NewService(filepath.Join(t.TempDir(), "pubsub.db"))
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	t.Cleanup(func() { svc.Close() })
+	if err := svc.Init(ctx); err != nil {
+		t.Fatalf("failed to initialize service: %v", err)
+	}
+	srv := httptest.NewServer(NewREST(svc, allowedOrigins...))
+	t.Cleanup(srv.Close)
+
+	if err := svc.CreateTopic(ctx, "orders", nil); err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	if err := svc.CreateSubscription(ctx, "orders", "billing", nil); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+	for _, content := range []string{"first", "second"} {
+		if _, err := svc.Publish(ctx, "orders", content, nil); err != nil {
+			t.Fatalf("failed to publish message: %v", err)
+		}
+	}
+	return srv, svc
+}
+
+// waitRedelivered waits until the message with the ack ID can be pulled again, after its stream disconnected.
+func waitRedelivered(t *testing.T, svc *service.Service, ackID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages, err := svc.Pull(context.Background(), "projects/test/subscriptions/billing", 10, time.Time{})
+		if err != nil {
+			t.Fatalf("failed to pull messages: %v", err)
+		}
+		if len(messages) > 1 {
+			t.Fatalf("expected only the unacknowledged message to be redelivered, got %+v", messages)
+		}
+		if len(messages) == 1 {
+			if strconv.Itoa(messages[0].ID) != ackID {
+				t.Fatalf("expected message %s to be redelivered, got %+v", ackID, messages[0])
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected message %s to be redelivered after the stream disconnected", ackID)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStreamSSE(t *testing.T) {
+	srv, svc := newStreamTest(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/projects/test/subscriptions/billing:stream", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %s %q", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	var received []*pubsubpb.ReceivedMessage
+	scanner := bufio.NewScanner(resp.Body)
+	var id string
+	for len(received) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			message := &pubsubpb.ReceivedMessage{}
+			if err := protojson.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), message); err != nil {
+				t.Fatalf("invalid event %q: %v", line, err)
+			}
+			if message.AckId != id {
+				t.Fatalf("expected the event ID to be the ack ID, got %q and %q", id, message.AckId)
+			}
+			received = append(received, message)
+		}
+	}
+	if len(received) != 2 || string(received[0].Message.Data) != "first" || string(received[1].Message.Data) != "second" {
+		t.Fatalf("unexpected messages %v", received)
+	}
+
+	// Messages streamed over SSE are acknowledged through the :acknowledge method.
+	if code := call(t, srv, "POST", "/v1/projects/test/subscriptions/billing:acknowledge",
+		`{"ackIds": ["`+received[0].AckId+`"]}`, nil); code != http.StatusOK {
+		t.Fatalf("expected acknowledging to succeed, got %d", code)
+	}
+	cancel()
+	waitRedelivered(t, svc, received[1].AckId)
+
+	var apiErr restError
+	if code := call(t, srv, "GET", "/v1/projects/test/subscriptions/missing:stream", "", &apiErr); code != http.StatusNotFound {
+		t.Fatalf("expected streaming a missing subscription to fail with 404, got %d", code)
+	}
+	if code := call(t, srv, "GET", "/v1/projects/test/subscriptions/billing:stream?maxOutstanding=0", "", &apiErr); code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid maxOutstanding to fail with 400, got %d", code)
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	srv, svc := newStreamTest(t)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/projects/test/subscriptions/billing:stream?maxOutstanding=1"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer conn.Close()
+
+	read := func() *pubsubpb.ReceivedMessage {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		message := &pubsubpb.ReceivedMessage{}
+		if err := protojson.Unmarshal(data, message); err != nil {
+			t.Fatalf("invalid message %q: %v", data, err)
+		}
+		return message
+	}
+
+	// With one message outstanding at a time, the second is only delivered once the first is acknowledged.
+	first := read()
+	if string(first.Message.Data) != "first" {
+		t.Fatalf("unexpected message %v", first)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"ackIds": ["`+first.AckId+`"]}`)); err != nil {
+		t.Fatalf("failed to acknowledge message: %v", err)
+	}
+	second := read()
+	if string(second.Message.Data) != "second" {
+		t.Fatalf("unexpected message %v", second)
+	}
+
+	conn.Close()
+	waitRedelivered(t, svc, second.AckId)
+}
+
+func TestStreamOrigins(t *testing.T) {
+	srv, _ := newStreamTest(t, "http://app.example")
+	path := "/v1/projects/test/subscriptions/billing:stream"
+
+	// open starts an SSE stream from a page of the origin, and closes it at once.
+	open := func(origin string) *http.Response {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Origin", origin)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("failed to open stream: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := open("http://evil.example"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a page of another origin to be refused, got %s", resp.Status)
+	}
+	if resp := open("http://app.example"); resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "http://app.example" {
+		t.Fatalf("expected an allowed origin to stream, got %s %v", resp.Status, resp.Header)
+	}
+	if resp := open(srv.URL); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a page of the same origin to stream, got %s", resp.Status)
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + path
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a WebSocket from another origin to be refused, got %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://app.example"}})
+	if err != nil {
+		t.Fatalf("expected a WebSocket from an allowed origin to connect, got %v", err)
+	}
+	conn.Close()
+}
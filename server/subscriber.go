@@ -0,0 +1,382 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/nigel-campbell/pubsub/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	// streamingPullInterval is how often a streaming pull checks for new messages.
+	streamingPullInterval = 100 * time.Millisecond
+	// maxStreamedMessages caps the messages sent in one streaming pull response.
+	maxStreamedMessages = 100
+)
+
+type subscriberServer struct {
+	pubsubpb.UnimplementedSubscriberServer
+	svc *pubsub.Service
+}
+
+func subscriptionConfig(s *pubsubpb.Subscription) (*pubsub.SubscriptionConfig, error) {
+	if s.GetBigqueryConfig() != nil || s.GetCloudStorageConfig() != nil || s.GetDeadLetterPolicy() != nil || s.GetEnableExactlyOnceDelivery() {
+		return nil, status.Error(codes.Unimplemented, "BigQuery and Cloud Storage delivery, dead lettering and exactly-once delivery are not supported")
+	}
+	// Ordering is accepted as messages are always delivered in publish order.
+	config := &pubsub.SubscriptionConfig{
+		Labels:                   s.GetLabels(),
+		AckDeadline:              time.Duration(s.GetAckDeadlineSeconds()) * time.Second,
+		MessageRetentionDuration: duration(s.GetMessageRetentionDuration()),
+		RetainAckedMessages:      s.GetRetainAckedMessages(),
+		Filter:                   s.GetFilter(),
+	}
+	if p := s.GetRetryPolicy(); p != nil {
+		config.RetryPolicy = &pubsub.RetryPolicy{MinimumBackoff: duration(p.GetMinimumBackoff()), MaximumBackoff: duration(p.GetMaximumBackoff())}
+	}
+	if p := s.GetExpirationPolicy(); p != nil {
+		config.ExpirationPolicy = &pubsub.ExpirationPolicy{TTL: duration(p.GetTtl())}
+	}
+	config.PushConfig = pushConfig(s.GetPushConfig())
+	return config, nil
+}
+
+// pushConfig returns the push configuration of a subscription, where an empty endpoint means the subscription is
+// pulled from. Authentication and payload options are ignored.
+func pushConfig(p *pubsubpb.PushConfig) *pubsub.PushConfig {
+	if p.GetPushEndpoint() == "" {
+		return nil
+	}
+	return &pubsub.PushConfig{Endpoint: p.GetPushEndpoint()}
+}
+
+func subscriptionProto(subscription *pubsub.Subscription) *pubsubpb.Subscription {
+	config := subscription.Config
+	topic := deletedTopic
+	if subscription.Topic != "" {
+		topic = subscription.TopicResourceName()
+	}
+	s := &pubsubpb.Subscription{
+		Name:                     subscription.ResourceName(),
+		Topic:                    topic,
+		AckDeadlineSeconds:       int32(config.EffectiveAckDeadline() / time.Second),
+		RetainAckedMessages:      config.RetainAckedMessages,
+		MessageRetentionDuration: durationProto(config.MessageRetentionDuration),
+		Labels:                   config.Labels,
+		Filter:                   config.Filter,
+		Detached:                 subscription.Detached,
+		State:                    pubsubpb.Subscription_ACTIVE,
+	}
+	if p := config.RetryPolicy; p != nil {
+		s.RetryPolicy = &pubsubpb.RetryPolicy{MinimumBackoff: durationProto(p.MinimumBackoff), MaximumBackoff: durationProto(p.MaximumBackoff)}
+	}
+	if p := config.ExpirationPolicy; p != nil {
+		s.ExpirationPolicy = &pubsubpb.ExpirationPolicy{Ttl: durationProto(p.TTL)}
+	}
+	if p := config.PushConfig; p != nil {
+		s.PushConfig = &pubsubpb.PushConfig{PushEndpoint: p.Endpoint}
+	}
+	return s
+}
+
+func receivedMessage(message *pubsub.Message) *pubsubpb.ReceivedMessage {
+	return &pubsubpb.ReceivedMessage{
+		AckId: strconv.Itoa(message.ID),
+		Message: &pubsubpb.PubsubMessage{
+			Data:        []byte(message.Content),
+			Attributes:  message.Attributes,
+			MessageId:   strconv.FormatInt(message.PublishID, 10),
+			PublishTime: timestampProto(message.PublishedAt),
+		},
+	}
+}
+
+// ackIDs parses ack IDs, which are the IDs of the subscription's copies of the messages.
+func ackIDs(ids []string) ([]int, error) {
+	parsed := make([]int, len(ids))
+	for i, id := range ids {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid ack ID %q", id)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+func (s *subscriberServer) CreateSubscription(ctx context.Context, req *pubsubpb.Subscription) (*pubsubpb.Subscription, error) {
+	config, err := subscriptionConfig(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.svc.CreateSubscription(ctx, req.GetTopic(), req.GetName(), config); err != nil {
+		return nil, grpcError(err)
+	}
+	return s.GetSubscription(ctx, &pubsubpb.GetSubscriptionRequest{Subscription: req.GetName()})
+}
+
+func (s *subscriberServer) GetSubscription(ctx context.Context, req *pubsubpb.GetSubscriptionRequest) (*pubsubpb.Subscription, error) {
+	subscription, err := s.svc.GetSubscription(ctx, req.GetSubscription())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return subscriptionProto(subscription), nil
+}
+
+func (s *subscriberServer) UpdateSubscription(ctx context.Context, req *pubsubpb.UpdateSubscriptionRequest) (*pubsubpb.Subscription, error) {
+	config, err := subscriptionConfig(req.GetSubscription())
+	if err != nil {
+		return nil, err
+	}
+	name := req.GetSubscription().GetName()
+	subscription, err := s.svc.UpdateSubscription(ctx, name, config, updateMask(req.GetUpdateMask().GetPaths()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return subscriptionProto(subscription), nil
+}
+
+func (s *subscriberServer) ListSubscriptions(ctx context.Context, req *pubsubpb.ListSubscriptionsRequest) (*pubsubpb.ListSubscriptionsResponse, error) {
+	ctx, err := projectContext(ctx, req.GetProject())
+	if err != nil {
+		return nil, err
+	}
+	project, _ := pubsub.ProjectFromContext(ctx)
+	subscriptions, err := s.svc.ListAllSubscriptions(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &pubsubpb.ListSubscriptionsResponse{}
+	for _, subscription := range subscriptions {
+		if subscription.Project == project {
+			res.Subscriptions = append(res.Subscriptions, subscriptionProto(subscription))
+		}
+	}
+	return res, nil
+}
+
+func (s *subscriberServer) DeleteSubscription(ctx context.Context, req *pubsubpb.DeleteSubscriptionRequest) (*emptypb.Empty, error) {
+	if err := s.svc.DeleteSubscription(ctx, req.GetSubscription()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *subscriberServer) ModifyAckDeadline(ctx context.Context, req *pubsubpb.ModifyAckDeadlineRequest) (*emptypb.Empty, error) {
+	ids, err := ackIDs(req.GetAckIds())
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(time.Duration(req.GetAckDeadlineSeconds()) * time.Second)
+	for _, id := range ids {
+		if err := s.svc.ModifyAckDeadline(ctx, req.GetSubscription(), id, deadline); err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *subscriberServer) Acknowledge(ctx context.Context, req *pubsubpb.AcknowledgeRequest) (*emptypb.Empty, error) {
+	ids, err := ackIDs(req.GetAckIds())
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := s.svc.AcknowledgeMessage(ctx, req.GetSubscription(), id); err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Pull returns the messages available now instead of waiting for some to arrive.
+func (s *subscriberServer) Pull(ctx context.Context, req *pubsubpb.PullRequest) (*pubsubpb.PullResponse, error) {
+	if req.GetMaxMessages() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "max_messages must be positive")
+	}
+	messages, err := s.svc.Pull(ctx, req.GetSubscription(), int(req.GetMaxMessages()), time.Time{})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &pubsubpb.PullResponse{}
+	for _, message := range messages {
+		res.ReceivedMessages = append(res.ReceivedMessages, receivedMessage(message))
+	}
+	return res, nil
+}
+
+// StreamingPull polls the subscription for messages and streams them to the client, while applying the acks and
+// deadline modifications the client sends on the same stream.
+func (s *subscriberServer) StreamingPull(stream pubsubpb.Subscriber_StreamingPullServer) error {
+	ctx := stream.Context()
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	subscription := req.GetSubscription()
+	if subscription == "" {
+		return status.Error(codes.InvalidArgument, "the first request must name the subscription")
+	}
+	if _, err := s.svc.GetSubscription(ctx, subscription); err != nil {
+		return grpcError(err)
+	}
+
+	var ackDeadline atomic.Int64
+	errc := make(chan error, 1)
+	go func(req *pubsubpb.StreamingPullRequest) {
+		for {
+			if seconds := req.GetStreamAckDeadlineSeconds(); seconds > 0 {
+				ackDeadline.Store(int64(seconds) * int64(time.Second))
+			}
+			if err := s.applyStreamingRequest(ctx, subscription, req); err != nil {
+				errc <- err
+				return
+			}
+			var err error
+			if req, err = stream.Recv(); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}(req)
+
+	ticker := time.NewTicker(streamingPullInterval)
+	defer ticker.Stop()
+	for {
+		var deadline time.Time
+		if d := ackDeadline.Load(); d > 0 {
+			deadline = time.Now().Add(time.Duration(d))
+		}
+		messages, err := s.svc.Pull(ctx, subscription, maxStreamedMessages, deadline)
+		if err != nil {
+			return grpcError(err)
+		}
+		if len(messages) > 0 {
+			res := &pubsubpb.StreamingPullResponse{}
+			for _, message := range messages {
+				res.ReceivedMessages = append(res.ReceivedMessages, receivedMessage(message))
+			}
+			if err := stream.Send(res); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errc:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return grpcError(err)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *subscriberServer) applyStreamingRequest(ctx context.Context, subscription string, req *pubsubpb.StreamingPullRequest) error {
+	acks, err := ackIDs(req.GetAckIds())
+	if err != nil {
+		return err
+	}
+	for _, id := range acks {
+		if err := s.svc.AcknowledgeMessage(ctx, subscription, id); err != nil {
+			return err
+		}
+	}
+	if len(req.GetModifyDeadlineAckIds()) != len(req.GetModifyDeadlineSeconds()) {
+		return status.Error(codes.InvalidArgument, "modify_deadline_ack_ids and modify_deadline_seconds must have the same length")
+	}
+	modacks, err := ackIDs(req.GetModifyDeadlineAckIds())
+	if err != nil {
+		return err
+	}
+	for i, id := range modacks {
+		deadline := time.Now().Add(time.Duration(req.GetModifyDeadlineSeconds()[i]) * time.Second)
+		if err := s.svc.ModifyAckDeadline(ctx, subscription, id, deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ModifyPushConfig turns a subscription into a push subscription, or back into a pull subscription when the endpoint
+// is empty. Messages are pushed by the background work of pubsub serve.
+func (s *subscriberServer) ModifyPushConfig(ctx context.Context, req *pubsubpb.ModifyPushConfigRequest) (*emptypb.Empty, error) {
+	config := &pubsub.SubscriptionConfig{PushConfig: pushConfig(req.GetPushConfig())}
+	if _, err := s.svc.UpdateSubscription(ctx, req.GetSubscription(), config, []string{"pushConfig"}); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func snapshotProto(snapshot *pubsub.Snapshot) *pubsubpb.Snapshot {
+	return &pubsubpb.Snapshot{
+		Name:       snapshot.ResourceName(),
+		Topic:      snapshot.TopicResourceName(),
+		ExpireTime: timestampProto(snapshot.ExpireAt),
+	}
+}
+
+func (s *subscriberServer) GetSnapshot(ctx context.Context, req *pubsubpb.GetSnapshotRequest) (*pubsubpb.Snapshot, error) {
+	snapshot, err := s.svc.GetSnapshot(ctx, req.GetSnapshot())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return snapshotProto(snapshot), nil
+}
+
+func (s *subscriberServer) ListSnapshots(ctx context.Context, req *pubsubpb.ListSnapshotsRequest) (*pubsubpb.ListSnapshotsResponse, error) {
+	ctx, err := projectContext(ctx, req.GetProject())
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := s.svc.ListSnapshots(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &pubsubpb.ListSnapshotsResponse{}
+	for _, snapshot := range snapshots {
+		res.Snapshots = append(res.Snapshots, snapshotProto(snapshot))
+	}
+	return res, nil
+}
+
+func (s *subscriberServer) CreateSnapshot(ctx context.Context, req *pubsubpb.CreateSnapshotRequest) (*pubsubpb.Snapshot, error) {
+	snapshot, err := s.svc.CreateSnapshot(ctx, req.GetName(), req.GetSubscription(), pubsub.DefaultSnapshotLifetime)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return snapshotProto(snapshot), nil
+}
+
+func (s *subscriberServer) DeleteSnapshot(ctx context.Context, req *pubsubpb.DeleteSnapshotRequest) (*emptypb.Empty, error) {
+	if err := s.svc.DeleteSnapshot(ctx, req.GetSnapshot()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *subscriberServer) Seek(ctx context.Context, req *pubsubpb.SeekRequest) (*pubsubpb.SeekResponse, error) {
+	var err error
+	switch target := req.GetTarget().(type) {
+	case *pubsubpb.SeekRequest_Time:
+		_, err = s.svc.Seek(ctx, req.GetSubscription(), target.Time.AsTime())
+	case *pubsubpb.SeekRequest_Snapshot:
+		_, err = s.svc.SeekToSnapshot(ctx, req.GetSubscription(), target.Snapshot)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "a time or snapshot to seek to is required")
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pubsubpb.SeekResponse{}, nil
+}
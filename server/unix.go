@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ListenUnix listens on a Unix socket at path with the permissions of mode, which are its access control, as
+// connecting to a socket requires write permission on it. A socket left behind by a server that is gone is replaced,
+// but not one another server listens on. Closing the listener removes the socket.
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// The socket is created under a temporary name and only renamed once its permissions are set, so that it cannot
+	// be connected to with the default ones in the meantime.
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"-"+randomHex())
+	lis, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	lis.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		lis.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		lis.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	return &unixListener{UnixListener: lis, addr: &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+// unixListener reports the path the socket was renamed to, and removes it once closed.
+type unixListener struct {
+	*net.UnixListener
+	addr   *net.UnixAddr
+	remove sync.Once
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Close may be called by several servers sharing the listener, but the socket is only removed once, in case another
+// server has replaced it since.
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	l.remove.Do(func() { os.Remove(l.addr.Name) })
+	return err
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pubsub.sock")
+	lis, err := ListenUnix(path, 0660)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected the socket to exist: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0660 {
+		t.Fatalf("unexpected mode %v", info.Mode())
+	}
+	if lis.Addr().String() != path {
+		t.Fatalf("expected the listener to report %s, got %s", path, lis.Addr())
+	}
+	go func() {
+		if conn, err := lis.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	conn.Close()
+
+	// A socket another server listens on is left alone.
+	if _, err := ListenUnix(path, 0600); err == nil {
+		t.Fatal("expected a socket in use to be refused")
+	}
+	if err := lis.Close(); err != nil {
+		t.Fatalf("failed to close listener: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected closing to remove the socket, got %v", err)
+	}
+
+	// A socket left behind by a server that is gone is replaced.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	replaced, err := ListenUnix(path, 0600)
+	if err != nil {
+		t.Fatalf("expected a stale socket to be replaced, got %v", err)
+	}
+	replaced.Close()
+
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := ListenUnix(path, 0600); err == nil {
+		t.Fatal("expected a file that is not a socket to be refused")
+	}
+}